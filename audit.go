@@ -0,0 +1,84 @@
+package rona
+
+import (
+	"context"
+	"time"
+)
+
+// AuditAction is a state change of a QuickTest.
+type AuditAction string
+
+// Audited actions.
+const (
+	AuditActionCreate   AuditAction = "create"
+	AuditActionRegister AuditAction = "register"
+	AuditActionResult   AuditAction = "result"
+	AuditActionExpire   AuditAction = "expire"
+)
+
+// AuditOutcomeSuccess is the outcome of a successful action. Failed
+// actions record the error code as their outcome.
+const AuditOutcomeSuccess = "success"
+
+// AuditEventMaxLimit is the maximum number of events returned at once.
+const AuditEventMaxLimit = 1000
+
+// AuditEvent records who changed a QuickTest and when. Audit events are
+// append-only and never contain PII such as the registered Person.
+type AuditEvent struct {
+	ID          int         `json:"id"`
+	Action      AuditAction `json:"action"`
+	QuickTestID QuickTestID `json:"test_id"`
+
+	// ActorID and ActorRole identify the user who performed the action.
+	// Both are empty for anonymous people and background jobs.
+	ActorID   int    `json:"actor_id,omitempty"`
+	ActorRole Role   `json:"actor_role,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+
+	// Outcome is AuditOutcomeSuccess or the error code of a failed action.
+	Outcome string `json:"outcome"`
+
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// AuditService queries the audit log. Events are recorded by the
+// QuickTestService as part of each state change.
+type AuditService interface {
+	// FindAuditEvents retrieves the events matching the filter, newest
+	// first, and the total number of matching events.
+	// Only admins can read the audit log.
+	// Returns EUNAUTHORIZED if the user isn't an admin.
+	FindAuditEvents(ctx context.Context, filter AuditEventFilter) ([]*AuditEvent, int, error)
+}
+
+// AuditEventFilter selects audit events. Nil fields match everything.
+type AuditEventFilter struct {
+	QuickTestID *QuickTestID
+	Action      *AuditAction
+	ActorID     *int
+
+	Offset int
+	Limit  int
+}
+
+// NewAuditEvent creates an audit event for the action performed by the
+// user in the context.
+func NewAuditEvent(ctx context.Context, action AuditAction, id QuickTestID, err error) *AuditEvent {
+	event := &AuditEvent{
+		Action:      action,
+		QuickTestID: id,
+		RequestID:   RequestIDFromContext(ctx),
+		Outcome:     AuditOutcomeSuccess,
+	}
+
+	if user := UserFromContext(ctx); user != nil {
+		event.ActorID = user.ID
+		event.ActorRole = user.Role
+	}
+
+	if err != nil {
+		event.Outcome = ErrorCode(err)
+	}
+	return event
+}
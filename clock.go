@@ -0,0 +1,20 @@
+package rona
+
+import "time"
+
+// Clock tells the current time. Services read the time from a Clock instead
+// of the system, so tests and simulations can control it.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is the Clock of the operating system.
+var SystemClock Clock = ClockFunc(time.Now)
+
+// ClockFunc adapts a function to a Clock.
+type ClockFunc func() time.Time
+
+// Now calls f.
+func (f ClockFunc) Now() time.Time {
+	return f()
+}
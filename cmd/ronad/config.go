@@ -0,0 +1,427 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/richardmarbach/rona"
+	"github.com/richardmarbach/rona/envelope"
+	"github.com/richardmarbach/rona/scheduler"
+)
+
+// DefaultConfigPath is the config file read when none is given.
+const DefaultConfigPath = "ronad.toml"
+
+// Stores
+const (
+	StoreSQLite   = "sqlite"
+	StorePostgres = "postgres"
+	StoreMemory   = "memory"
+)
+
+// Log levels. Error only logs failures. Info also logs requests, expired
+// tests and the lifecycle of the daemon. Debug also logs the effective
+// configuration and every expiry run.
+const (
+	LogLevelDebug = "debug"
+	LogLevelInfo  = "info"
+	LogLevelError = "error"
+)
+
+// logLevelSeverity orders the log levels from most to least verbose.
+var logLevelSeverity = map[string]int{
+	LogLevelDebug: 0,
+	LogLevelInfo:  1,
+	LogLevelError: 2,
+}
+
+// Config holds the daemon configuration. Values are read from the config
+// file first, then overridden by environment variables and finally by
+// command line flags.
+type Config struct {
+	DB struct {
+		// Store selects where data is kept. The memory store loses all
+		// data on exit and only has an admin whose API key is logged on
+		// start. The postgres store lets several instances share one
+		// database.
+		Store string `toml:"store"`
+
+		// DSN is the sqlite database file or the postgres connection
+		// string.
+		DSN string `toml:"dsn"`
+	} `toml:"db"`
+
+	HTTP struct {
+		Addr     string `toml:"addr"`
+		BaseURL  string `toml:"base_url"`
+		CertFile string `toml:"cert_file"`
+		KeyFile  string `toml:"key_file"`
+
+		// SessionKey is the hex encoded key signing session cookies.
+		// A random key is used when empty, which signs everybody out
+		// on restart.
+		SessionKey string `toml:"session_key"`
+	} `toml:"http"`
+
+	Admin struct {
+		// Addr is the private address serving metrics, health checks
+		// and build info. Keep it off the public network. The admin
+		// server is disabled when empty.
+		Addr string `toml:"addr"`
+
+		// Pprof serves the runtime profiles under /debug/pprof/.
+		Pprof bool `toml:"pprof"`
+	} `toml:"admin"`
+
+	Encryption struct {
+		// KeyFile holds the keyring encrypting personal data with one
+		// "<id> <hex key>" pair per line. The first key encrypts new
+		// data, the others are kept to decrypt existing data.
+		KeyFile string `toml:"key_file"`
+
+		// Keys is an inline keyring in the same format, with pairs
+		// separated by newlines or commas. Takes precedence over KeyFile.
+		Keys string `toml:"keys"`
+	} `toml:"encryption"`
+
+	QuickTest struct {
+		ValidityDuration Duration `toml:"validity_duration"`
+		ExpiryInterval   Duration `toml:"expiry_interval"`
+	} `toml:"quicktest"`
+
+	Log struct {
+		// Level is debug, info or error. Each level logs what the
+		// next one does and more.
+		Level string `toml:"level"`
+	} `toml:"log"`
+
+	Shutdown struct {
+		// GracePeriod is how long in-flight requests get to finish
+		// before the server is stopped.
+		GracePeriod Duration `toml:"grace_period"`
+	} `toml:"shutdown"`
+}
+
+// DefaultConfig returns the configuration used when nothing is overridden.
+func DefaultConfig() Config {
+	var config Config
+	config.DB.Store = StoreSQLite
+	config.DB.DSN = "data/rona.db"
+	config.HTTP.Addr = ":8080"
+	config.Admin.Addr = "127.0.0.1:9090"
+	config.QuickTest.ValidityDuration = Duration(rona.QuickTestValidityDuration)
+	config.QuickTest.ExpiryInterval = Duration(scheduler.DefaultExpiryInterval)
+	config.Log.Level = LogLevelInfo
+	config.Shutdown.GracePeriod = Duration(30 * time.Second)
+	return config
+}
+
+// Validate the configuration.
+func (c *Config) Validate() error {
+	if c.DB.Store != StoreSQLite && c.DB.Store != StorePostgres && c.DB.Store != StoreMemory {
+		return fmt.Errorf("invalid db.store: %q", c.DB.Store)
+	} else if c.DB.Store != StoreMemory && c.DB.DSN == "" {
+		return fmt.Errorf("db.dsn is required")
+	} else if c.HTTP.Addr == "" {
+		return fmt.Errorf("http.addr is required")
+	} else if c.HTTP.BaseURL != "" && !isAbsoluteURL(c.HTTP.BaseURL) {
+		return fmt.Errorf("http.base_url must be an absolute http(s) URL")
+	} else if c.Admin.Addr != "" && c.Admin.Addr == c.HTTP.Addr {
+		return fmt.Errorf("admin.addr must differ from http.addr")
+	} else if (c.HTTP.CertFile == "") != (c.HTTP.KeyFile == "") {
+		return fmt.Errorf("http.cert_file and http.key_file must be set together")
+	} else if _, err := c.SessionKeyBytes(); err != nil {
+		return err
+	} else if c.QuickTest.ValidityDuration <= 0 {
+		return fmt.Errorf("quicktest.validity_duration must be positive")
+	} else if c.QuickTest.ExpiryInterval <= 0 {
+		return fmt.Errorf("quicktest.expiry_interval must be positive")
+	} else if c.Shutdown.GracePeriod < 0 {
+		return fmt.Errorf("shutdown.grace_period must not be negative")
+	}
+
+	if _, ok := logLevelSeverity[c.Log.Level]; !ok {
+		return fmt.Errorf("invalid log.level: %q", c.Log.Level)
+	}
+	return nil
+}
+
+// LogEnabled reports whether messages of the given level are logged.
+func (c *Config) LogEnabled(level string) bool {
+	return logLevelSeverity[level] >= logLevelSeverity[c.Log.Level]
+}
+
+// SessionKeyBytes decodes the session key. Returns nil if no key is set.
+func (c *Config) SessionKeyBytes() ([]byte, error) {
+	if c.HTTP.SessionKey == "" {
+		return nil, nil
+	}
+
+	key, err := hex.DecodeString(c.HTTP.SessionKey)
+	if err != nil {
+		return nil, fmt.Errorf("http.session_key must be hex encoded")
+	} else if len(key) < 32 {
+		return nil, fmt.Errorf("http.session_key must be at least 32 bytes long")
+	}
+	return key, nil
+}
+
+// Keyring loads the keyring encrypting personal data.
+func (c *Config) Keyring() (*envelope.Keyring, error) {
+	if c.Encryption.Keys != "" {
+		keyring, err := envelope.ParseKeyring(strings.NewReader(strings.ReplaceAll(c.Encryption.Keys, ",", "\n")))
+		if err != nil {
+			return nil, fmt.Errorf("encryption.keys: %w", err)
+		}
+		return keyring, nil
+	} else if c.Encryption.KeyFile == "" {
+		return nil, fmt.Errorf("encryption.key_file or encryption.keys is required")
+	}
+
+	f, err := os.Open(c.Encryption.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	keyring, err := envelope.ParseKeyring(f)
+	if err != nil {
+		return nil, fmt.Errorf("key file %s: %w", c.Encryption.KeyFile, err)
+	}
+	return keyring, nil
+}
+
+// requireSQLite reports an error when the command can't run against the
+// configured store.
+func (c *Config) requireSQLite(command string) error {
+	if c.DB.Store != StoreSQLite {
+		return fmt.Errorf("%s requires db.store %q, got %q", command, StoreSQLite, c.DB.Store)
+	}
+	return nil
+}
+
+// optionalKeyring loads the keyring if one is configured. Migrations only
+// need it to re-encrypt existing data.
+func (c *Config) optionalKeyring() (*envelope.Keyring, error) {
+	if c.Encryption.Keys == "" && c.Encryption.KeyFile == "" {
+		return nil, nil
+	}
+	return c.Keyring()
+}
+
+// ParseConfig builds the configuration from the config file, the
+// environment and the command line arguments.
+func ParseConfig(args []string, getenv func(string) string) (Config, error) {
+	fs := flag.NewFlagSet("ronad", flag.ContinueOnError)
+	configFlag := fs.String("config", DefaultConfigPath, "config file path")
+	store := fs.String("store", "", "where data is kept (sqlite, postgres, memory)")
+	dsn := fs.String("dsn", "", "database DSN")
+	addr := fs.String("addr", "", "HTTP listen address")
+	baseURL := fs.String("base-url", "", "public URL of the server used in QR codes")
+	certFile := fs.String("cert-file", "", "TLS certificate file")
+	keyFile := fs.String("key-file", "", "TLS key file")
+	adminAddr := fs.String("admin-addr", "", "private listen address for metrics and health checks, empty to disable")
+	adminPprof := fs.Bool("admin-pprof", false, "serve runtime profiles on the admin address")
+	validity := fs.Duration("validity", 0, "how long a registered test stays valid")
+	expiryInterval := fs.Duration("expiry-interval", 0, "how often outdated tests are expired")
+	logLevel := fs.String("log-level", "", "log level (debug, info, error)")
+	gracePeriod := fs.Duration("grace-period", 0, "how long in-flight requests get to finish on shutdown")
+	sessionKey := fs.String("session-key", "", "hex encoded key for signing session cookies")
+	encryptionKeyFile := fs.String("encryption-key-file", "", "keyring file encrypting personal data")
+	if err := fs.Parse(args); err != nil {
+		return Config{}, err
+	}
+
+	config, err := loadConfig(fs, *configFlag, getenv)
+	if err != nil {
+		return config, err
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "store":
+			config.DB.Store = *store
+		case "dsn":
+			config.DB.DSN = *dsn
+		case "addr":
+			config.HTTP.Addr = *addr
+		case "base-url":
+			config.HTTP.BaseURL = *baseURL
+		case "cert-file":
+			config.HTTP.CertFile = *certFile
+		case "key-file":
+			config.HTTP.KeyFile = *keyFile
+		case "admin-addr":
+			config.Admin.Addr = *adminAddr
+		case "admin-pprof":
+			config.Admin.Pprof = *adminPprof
+		case "validity":
+			config.QuickTest.ValidityDuration = Duration(*validity)
+		case "expiry-interval":
+			config.QuickTest.ExpiryInterval = Duration(*expiryInterval)
+		case "log-level":
+			config.Log.Level = *logLevel
+		case "grace-period":
+			config.Shutdown.GracePeriod = Duration(*gracePeriod)
+		case "session-key":
+			config.HTTP.SessionKey = *sessionKey
+		case "encryption-key-file":
+			config.Encryption.KeyFile = *encryptionKeyFile
+		}
+	})
+
+	if err := config.Validate(); err != nil {
+		return config, fmt.Errorf("invalid config: %w", err)
+	}
+	return config, nil
+}
+
+// loadConfig reads the config file and applies the environment on top of
+// the defaults. The file given by the -config flag or RONA_CONFIG must
+// exist, the default file is optional.
+func loadConfig(fs *flag.FlagSet, configFlag string, getenv func(string) string) (Config, error) {
+	config := DefaultConfig()
+
+	configPath, required := DefaultConfigPath, false
+	if v := getenv("RONA_CONFIG"); v != "" {
+		configPath, required = v, true
+	}
+	if isFlagSet(fs, "config") {
+		configPath, required = configFlag, true
+	}
+	if err := readConfigFile(&config, configPath, required); err != nil {
+		return config, err
+	}
+
+	if err := applyEnv(&config, getenv); err != nil {
+		return config, err
+	}
+	return config, nil
+}
+
+// readConfigFile decodes the TOML file at path into config. A missing file
+// is only an error when it was explicitly requested. Unknown keys are an
+// error, so a misspelled setting doesn't silently fall back to its default.
+func readConfigFile(config *Config, path string, required bool) error {
+	buf, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && !required {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	md, err := toml.Decode(string(buf), config)
+	if err != nil {
+		return fmt.Errorf("config file %s: %w", path, err)
+	}
+	if undecoded := md.Undecoded(); len(undecoded) > 0 {
+		keys := make([]string, len(undecoded))
+		for i, key := range undecoded {
+			keys[i] = key.String()
+		}
+		return fmt.Errorf("config file %s: unknown keys: %s", path, strings.Join(keys, ", "))
+	}
+	return nil
+}
+
+// applyEnv overrides config with the RONA_* environment variables.
+func applyEnv(config *Config, getenv func(string) string) error {
+	if v := getenv("RONA_STORE"); v != "" {
+		config.DB.Store = strings.ToLower(v)
+	}
+	if v := getenv("RONA_DSN"); v != "" {
+		config.DB.DSN = v
+	}
+	if v := getenv("RONA_ADDR"); v != "" {
+		config.HTTP.Addr = v
+	}
+	if v := getenv("RONA_BASE_URL"); v != "" {
+		config.HTTP.BaseURL = v
+	}
+	if v := getenv("RONA_SESSION_KEY"); v != "" {
+		config.HTTP.SessionKey = v
+	}
+	if v := getenv("RONA_CERT_FILE"); v != "" {
+		config.HTTP.CertFile = v
+	}
+	if v := getenv("RONA_KEY_FILE"); v != "" {
+		config.HTTP.KeyFile = v
+	}
+	if v := getenv("RONA_ADMIN_ADDR"); v != "" {
+		config.Admin.Addr = v
+	}
+	if v := getenv("RONA_ADMIN_PPROF"); v != "" {
+		pprof, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("RONA_ADMIN_PPROF: %w", err)
+		}
+		config.Admin.Pprof = pprof
+	}
+	if v := getenv("RONA_ENCRYPTION_KEY_FILE"); v != "" {
+		config.Encryption.KeyFile = v
+	}
+	if v := getenv("RONA_ENCRYPTION_KEYS"); v != "" {
+		config.Encryption.Keys = v
+	}
+	if v := getenv("RONA_VALIDITY_DURATION"); v != "" {
+		if err := config.QuickTest.ValidityDuration.UnmarshalText([]byte(v)); err != nil {
+			return fmt.Errorf("RONA_VALIDITY_DURATION: %w", err)
+		}
+	}
+	if v := getenv("RONA_EXPIRY_INTERVAL"); v != "" {
+		if err := config.QuickTest.ExpiryInterval.UnmarshalText([]byte(v)); err != nil {
+			return fmt.Errorf("RONA_EXPIRY_INTERVAL: %w", err)
+		}
+	}
+	if v := getenv("RONA_LOG_LEVEL"); v != "" {
+		config.Log.Level = strings.ToLower(v)
+	}
+	if v := getenv("RONA_GRACE_PERIOD"); v != "" {
+		if err := config.Shutdown.GracePeriod.UnmarshalText([]byte(v)); err != nil {
+			return fmt.Errorf("RONA_GRACE_PERIOD: %w", err)
+		}
+	}
+	return nil
+}
+
+// isAbsoluteURL reports whether s is an absolute http or https URL.
+func isAbsoluteURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// isFlagSet reports whether the flag was passed on the command line.
+func isFlagSet(fs *flag.FlagSet, name string) (set bool) {
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// Duration is a time.Duration that decodes from strings like "24h".
+type Duration time.Duration
+
+// UnmarshalText parses the duration string.
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalText formats the duration as a string.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseConfig(t *testing.T) {
+	t.Run("use defaults without a config file", func(t *testing.T) {
+		config, err := ParseConfig([]string{}, env(nil))
+		assertNoError(t, err)
+
+		if config.HTTP.Addr != ":8080" {
+			t.Errorf("want addr %v, got %v", ":8080", config.HTTP.Addr)
+		}
+		if time.Duration(config.QuickTest.ValidityDuration) != 24*time.Hour {
+			t.Errorf("want validity %v, got %v", 24*time.Hour, config.QuickTest.ValidityDuration)
+		}
+	})
+
+	t.Run("read the config file", func(t *testing.T) {
+		path := writeConfigFile(t, `
+[db]
+dsn = "/var/lib/rona/rona.db"
+
+[http]
+addr = ":9090"
+
+[quicktest]
+validity_duration = "12h"
+expiry_interval = "30s"
+
+[log]
+level = "debug"
+`)
+
+		config, err := ParseConfig([]string{"-config", path}, env(nil))
+		assertNoError(t, err)
+
+		if config.DB.DSN != "/var/lib/rona/rona.db" {
+			t.Errorf("unexpected dsn: %v", config.DB.DSN)
+		}
+		if config.HTTP.Addr != ":9090" {
+			t.Errorf("unexpected addr: %v", config.HTTP.Addr)
+		}
+		if time.Duration(config.QuickTest.ValidityDuration) != 12*time.Hour {
+			t.Errorf("unexpected validity: %v", config.QuickTest.ValidityDuration)
+		}
+		if time.Duration(config.QuickTest.ExpiryInterval) != 30*time.Second {
+			t.Errorf("unexpected expiry interval: %v", config.QuickTest.ExpiryInterval)
+		}
+		if config.Log.Level != LogLevelDebug {
+			t.Errorf("unexpected log level: %v", config.Log.Level)
+		}
+	})
+
+	t.Run("flags override the environment which overrides the file", func(t *testing.T) {
+		path := writeConfigFile(t, `
+[db]
+dsn = "file.db"
+
+[http]
+addr = ":9090"
+`)
+
+		config, err := ParseConfig(
+			[]string{"-config", path, "-addr", ":7070"},
+			env(map[string]string{"RONA_DSN": "env.db", "RONA_ADDR": ":6060"}),
+		)
+		assertNoError(t, err)
+
+		if config.DB.DSN != "env.db" {
+			t.Errorf("want dsn %v, got %v", "env.db", config.DB.DSN)
+		}
+		if config.HTTP.Addr != ":7070" {
+			t.Errorf("want addr %v, got %v", ":7070", config.HTTP.Addr)
+		}
+	})
+
+	t.Run("load the keyring from the environment", func(t *testing.T) {
+		key := strings.Repeat("ab", 32)
+		config, err := ParseConfig(nil, env(map[string]string{"RONA_ENCRYPTION_KEYS": "new " + key + ",old " + key}))
+		assertNoError(t, err)
+
+		keyring, err := config.Keyring()
+		assertNoError(t, err)
+
+		if keyring.PrimaryKeyID() != "new" {
+			t.Errorf("want primary key new, got %v", keyring.PrimaryKeyID())
+		}
+	})
+
+	t.Run("skip the optional keyring without keys", func(t *testing.T) {
+		config, err := ParseConfig(nil, env(nil))
+		assertNoError(t, err)
+
+		if keyring, err := config.optionalKeyring(); err != nil || keyring != nil {
+			t.Errorf("want no keyring, got %v, %v", keyring, err)
+		}
+	})
+
+	t.Run("select the memory store without a dsn", func(t *testing.T) {
+		config, err := ParseConfig([]string{"-dsn", ""}, env(map[string]string{"RONA_STORE": "memory"}))
+		assertNoError(t, err)
+
+		if config.DB.Store != StoreMemory {
+			t.Errorf("want store %v, got %v", StoreMemory, config.DB.Store)
+		}
+	})
+
+	t.Run("select the postgres store", func(t *testing.T) {
+		dsn := "postgres://localhost/rona?sslmode=disable"
+		config, err := ParseConfig([]string{"-store", "postgres", "-dsn", dsn}, env(nil))
+		assertNoError(t, err)
+
+		if config.DB.Store != StorePostgres || config.DB.DSN != dsn {
+			t.Errorf("unexpected db config %+v", config.DB)
+		}
+	})
+
+	t.Run("order the log levels", func(t *testing.T) {
+		cases := []struct {
+			level   string
+			enabled []string
+		}{
+			{LogLevelDebug, []string{LogLevelDebug, LogLevelInfo, LogLevelError}},
+			{LogLevelInfo, []string{LogLevelInfo, LogLevelError}},
+			{LogLevelError, []string{LogLevelError}},
+		}
+
+		for _, tc := range cases {
+			config, err := ParseConfig([]string{"-log-level", tc.level}, env(nil))
+			assertNoError(t, err)
+
+			var enabled []string
+			for _, level := range []string{LogLevelDebug, LogLevelInfo, LogLevelError} {
+				if config.LogEnabled(level) {
+					enabled = append(enabled, level)
+				}
+			}
+			if strings.Join(enabled, ",") != strings.Join(tc.enabled, ",") {
+				t.Errorf("level %s: want %v enabled, got %v", tc.level, tc.enabled, enabled)
+			}
+		}
+	})
+
+	t.Run("configure the admin server", func(t *testing.T) {
+		config, err := ParseConfig(nil, env(map[string]string{"RONA_ADMIN_ADDR": "127.0.0.1:9191", "RONA_ADMIN_PPROF": "true"}))
+		assertNoError(t, err)
+
+		if config.Admin.Addr != "127.0.0.1:9191" || !config.Admin.Pprof {
+			t.Errorf("unexpected admin config %+v", config.Admin)
+		}
+
+		config, err = ParseConfig([]string{"-admin-addr", ""}, env(nil))
+		assertNoError(t, err)
+
+		if config.Admin.Addr != "" {
+			t.Errorf("expected the admin server to be disabled, got %v", config.Admin.Addr)
+		}
+	})
+
+	t.Run("fail when an explicit config file is missing", func(t *testing.T) {
+		_, err := ParseConfig([]string{"-config", filepath.Join(t.TempDir(), "missing.toml")}, env(nil))
+		if err == nil {
+			t.Error("expected an error but didn't get one")
+		}
+	})
+
+	t.Run("fail on unknown keys in the config file", func(t *testing.T) {
+		path := writeConfigFile(t, `
+[http]
+adr = ":9090"
+
+[quicktest]
+validity = "12h"
+`)
+
+		_, err := ParseConfig([]string{"-config", path}, env(nil))
+		if err == nil {
+			t.Fatal("expected an error but didn't get one")
+		}
+		for _, key := range []string{"http.adr", "quicktest.validity"} {
+			if !strings.Contains(err.Error(), key) {
+				t.Errorf("expected %v to be reported: %v", key, err)
+			}
+		}
+	})
+
+	t.Run("read the example config file", func(t *testing.T) {
+		_, err := ParseConfig([]string{"-config", "../../ronad.example.toml"}, env(nil))
+		assertNoError(t, err)
+	})
+
+	t.Run("report validation errors", func(t *testing.T) {
+		cases := []struct {
+			message string
+			args    []string
+		}{
+			{"empty dsn", []string{"-dsn", ""}},
+			{"unknown store", []string{"-store", "disk"}},
+			{"postgres without dsn", []string{"-store", "postgres", "-dsn", ""}},
+			{"admin on the public address", []string{"-admin-addr", ":8080"}},
+			{"relative base url", []string{"-base-url", "example.com"}},
+			{"short session key", []string{"-session-key", "abcd"}},
+			{"session key not hex", []string{"-session-key", "zz"}},
+			{"cert without key", []string{"-cert-file", "tls.crt"}},
+			{"negative validity", []string{"-validity", "-1h"}},
+			{"zero expiry interval", []string{"-expiry-interval", "0s"}},
+			{"unknown log level", []string{"-log-level", "loud"}},
+			{"negative grace period", []string{"-grace-period", "-1s"}},
+		}
+
+		for _, tc := range cases {
+			t.Run(tc.message, func(t *testing.T) {
+				if _, err := ParseConfig(tc.args, env(nil)); err == nil {
+					t.Error("expected an error but didn't get one")
+				}
+			})
+		}
+	})
+}
+
+func env(vars map[string]string) func(string) string {
+	return func(key string) string {
+		return vars[key]
+	}
+}
+
+func writeConfigFile(tb testing.TB, content string) string {
+	tb.Helper()
+
+	path := filepath.Join(tb.TempDir(), "ronad.toml")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		tb.Fatal(err)
+	}
+	return path
+}
+
+func assertNoError(tb testing.TB, err error) {
+	tb.Helper()
+	if err != nil {
+		tb.Fatalf("expected no error, got %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/richardmarbach/rona/sqlite"
+)
+
+// runCounters executes the counters subcommands.
+func runCounters(args []string, getenv func(string) string, stdout io.Writer) error {
+	const usage = "usage: ronad counters reconcile [flags]"
+	if len(args) == 0 || args[0] != "reconcile" {
+		return fmt.Errorf(usage)
+	}
+
+	fs := flag.NewFlagSet("ronad counters reconcile", flag.ContinueOnError)
+	configFlag := fs.String("config", DefaultConfigPath, "config file path")
+	dsn := fs.String("dsn", "", "database DSN")
+	dryRun := fs.Bool("dry-run", false, "report drift without correcting the counters")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	config, err := loadConfig(fs, *configFlag, getenv)
+	if err != nil {
+		return err
+	}
+	if isFlagSet(fs, "dsn") {
+		config.DB.DSN = *dsn
+	}
+	if err := config.requireSQLite("ronad counters"); err != nil {
+		return err
+	}
+
+	db := sqlite.NewDB(config.DB.DSN)
+	if db.Keyring, err = config.optionalKeyring(); err != nil {
+		return err
+	}
+	if err := db.Open(); err != nil {
+		return err
+	}
+	defer db.Close()
+
+	counters, err := db.ReconcileCounters(context.Background(), *dryRun)
+	if err != nil {
+		return err
+	}
+
+	drifted := 0
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "COUNTER\tSTORED\tACTUAL\tDRIFT")
+	for _, counter := range counters {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%+d\n", counter.Name, counter.Stored, counter.Actual, counter.Drift())
+		if counter.Drift() != 0 {
+			drifted++
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	switch {
+	case drifted == 0:
+		fmt.Fprintln(stdout, "counters are in sync")
+	case *dryRun:
+		fmt.Fprintf(stdout, "%d counters drifted, dry run, no changes were made\n", drifted)
+	default:
+		fmt.Fprintf(stdout, "corrected %d counters\n", drifted)
+	}
+	return nil
+}
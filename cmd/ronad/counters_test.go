@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/richardmarbach/rona/sqlite"
+)
+
+func TestRunCounters(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "rona.db")
+
+	reconcile := func(t *testing.T, args ...string) string {
+		t.Helper()
+
+		var out bytes.Buffer
+		err := runCounters(append([]string{"reconcile", "-dsn", dsn}, args...), env(nil), &out)
+		assertNoError(t, err)
+		return out.String()
+	}
+
+	t.Run("report counters in sync", func(t *testing.T) {
+		if out := reconcile(t); !strings.Contains(out, "counters are in sync") {
+			t.Errorf("unexpected output %q", out)
+		}
+	})
+
+	t.Run("correct drifted counters", func(t *testing.T) {
+		raw, err := sql.Open(sqlite.DriverName, dsn)
+		assertNoError(t, err)
+		_, err = raw.Exec(`UPDATE quick_test_counters SET value = 3 WHERE name = 'total'`)
+		raw.Close()
+		assertNoError(t, err)
+
+		out := reconcile(t, "-dry-run")
+		if !strings.Contains(out, "+3") || !strings.Contains(out, "dry run") {
+			t.Errorf("unexpected output %q", out)
+		}
+		if out := reconcile(t); !strings.Contains(out, "corrected 1 counters") {
+			t.Errorf("unexpected output %q", out)
+		}
+		if out := reconcile(t); !strings.Contains(out, "counters are in sync") {
+			t.Errorf("unexpected output %q", out)
+		}
+	})
+	t.Run("refuse other stores", func(t *testing.T) {
+		err := runCounters([]string{"reconcile", "-dsn", dsn}, env(map[string]string{"RONA_STORE": "postgres"}), &bytes.Buffer{})
+		if err == nil || !strings.Contains(err.Error(), "requires db.store") {
+			t.Errorf("unexpected error %v", err)
+		}
+	})
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/richardmarbach/rona/envelope"
+	"github.com/richardmarbach/rona/postgres"
+	"github.com/richardmarbach/rona/sqlite"
+)
+
+// DefaultRotateBatchSize is the number of rows re-encrypted per transaction.
+const DefaultRotateBatchSize = 500
+
+// runKeys executes the keys subcommands.
+func runKeys(args []string, getenv func(string) string, stdout io.Writer) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "generate":
+			return runKeysGenerate(args[1:], stdout)
+		case "rotate":
+			return runKeysRotate(args[1:], getenv, stdout)
+		}
+	}
+	return fmt.Errorf("usage: ronad keys generate|rotate [flags]")
+}
+
+// runKeysGenerate prints a new keyring line. Prepend it to the key file to
+// make it the primary key.
+func runKeysGenerate(args []string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("ronad keys generate", flag.ContinueOnError)
+	id := fs.String("id", time.Now().UTC().Format("20060102"), "id of the new key")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "%s %s\n", *id, hex.EncodeToString(envelope.NewKey()))
+	return nil
+}
+
+// runKeysRotate re-encrypts all personal data with the primary key of the
+// keyring. Once it completes, older keys can be removed from the keyring.
+func runKeysRotate(args []string, getenv func(string) string, stdout io.Writer) error {
+	fs := flag.NewFlagSet("ronad keys rotate", flag.ContinueOnError)
+	configFlag := fs.String("config", DefaultConfigPath, "config file path")
+	dsn := fs.String("dsn", "", "database DSN")
+	keyFile := fs.String("encryption-key-file", "", "keyring file encrypting personal data")
+	batchSize := fs.Int("batch-size", DefaultRotateBatchSize, "rows re-encrypted per transaction")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	config, err := loadConfig(fs, *configFlag, getenv)
+	if err != nil {
+		return err
+	}
+	if isFlagSet(fs, "dsn") {
+		config.DB.DSN = *dsn
+	}
+	if isFlagSet(fs, "encryption-key-file") {
+		config.Encryption.KeyFile = *keyFile
+		config.Encryption.Keys = ""
+	}
+
+	keyring, err := config.Keyring()
+	if err != nil {
+		return err
+	}
+
+	db, err := openKeyRotator(config, keyring)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// Batches are committed as they go, so an interrupted rotation can
+	// simply be restarted.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	n, err := db.RotateKeys(ctx, *batchSize)
+	fmt.Fprintf(stdout, "re-encrypted %d rows with key %s\n", n, keyring.PrimaryKeyID())
+	return err
+}
+
+// keyRotator is a database that re-encrypts personal data.
+type keyRotator interface {
+	RotateKeys(ctx context.Context, batchSize int) (int, error)
+	Close() error
+}
+
+// openKeyRotator opens the database of the configured store.
+func openKeyRotator(config Config, keyring *envelope.Keyring) (keyRotator, error) {
+	switch config.DB.Store {
+	case StoreSQLite:
+		db := sqlite.NewDB(config.DB.DSN)
+		db.Keyring = keyring
+		return db, db.Open()
+	case StorePostgres:
+		db := postgres.NewDB(config.DB.DSN)
+		db.Keyring = keyring
+		return db, db.Open()
+	}
+	return nil, fmt.Errorf("ronad keys rotate requires an sqlite or postgres store, got %q", config.DB.Store)
+}
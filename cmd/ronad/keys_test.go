@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunKeys(t *testing.T) {
+	t.Run("generate a key and rotate with it", func(t *testing.T) {
+		var key bytes.Buffer
+		assertNoError(t, runKeys([]string{"generate", "-id", "k1"}, env(nil), &key))
+
+		if !strings.HasPrefix(key.String(), "k1 ") {
+			t.Fatalf("unexpected key line %q", key.String())
+		}
+
+		keyFile := filepath.Join(t.TempDir(), "keys")
+		if err := ioutil.WriteFile(keyFile, key.Bytes(), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		var out bytes.Buffer
+		err := runKeys(
+			[]string{"rotate", "-dsn", filepath.Join(t.TempDir(), "rona.db"), "-encryption-key-file", keyFile},
+			env(nil),
+			&out,
+		)
+		assertNoError(t, err)
+
+		if out.String() != "re-encrypted 0 rows with key k1\n" {
+			t.Errorf("unexpected output %q", out.String())
+		}
+	})
+
+	t.Run("require a keyring", func(t *testing.T) {
+		err := runKeys([]string{"rotate", "-dsn", filepath.Join(t.TempDir(), "rona.db")}, env(nil), ioutil.Discard)
+		if err == nil {
+			t.Error("expected an error but didn't get one")
+		}
+	})
+}
@@ -1,31 +1,249 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"log"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/richardmarbach/rona"
 	"github.com/richardmarbach/rona/http"
+	"github.com/richardmarbach/rona/inmem"
+	"github.com/richardmarbach/rona/postgres"
+	"github.com/richardmarbach/rona/scheduler"
 	"github.com/richardmarbach/rona/sqlite"
 )
 
+// Build information, set with -ldflags "-X main.version=... -X main.commit=...".
+var (
+	version = "dev"
+	commit  = ""
+)
+
 func main() {
-	if err := run(); err != nil {
+	if err := run(os.Args[1:]); err != nil {
 		fmt.Fprintf(os.Stderr, "%v\n", err)
 		os.Exit(1)
 	}
 }
 
-func run() error {
-	db := sqlite.NewDB(":memory:")
+func run(args []string) error {
+	if len(args) > 0 && args[0] == "user" {
+		return runUser(args[1:], os.Getenv, os.Stdin, os.Stdout)
+	} else if len(args) > 0 && args[0] == "keys" {
+		return runKeys(args[1:], os.Getenv, os.Stdout)
+	} else if len(args) > 0 && args[0] == "migrate" {
+		return runMigrate(args[1:], os.Getenv, os.Stdout)
+	} else if len(args) > 0 && args[0] == "counters" {
+		return runCounters(args[1:], os.Getenv, os.Stdout)
+	}
 
-	if err := db.Open(); err != nil {
+	config, err := ParseConfig(args, os.Getenv)
+	if err != nil {
 		return err
 	}
-	quickTestService := sqlite.NewQuickTestService(db)
-	server, err := http.NewServer(quickTestService)
+	logf(config, LogLevelDebug, "config: store=%s addr=%s admin_addr=%s validity=%s expiry_interval=%s",
+		config.DB.Store, config.HTTP.Addr, config.Admin.Addr,
+		time.Duration(config.QuickTest.ValidityDuration), time.Duration(config.QuickTest.ExpiryInterval))
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	store, err := openStore(config)
 	if err != nil {
 		return err
 	}
+	defer func() {
+		if err := store.Close(); err != nil {
+			log.Printf("close store: %v", err)
+		}
+	}()
+	quickTestService := store.QuickTestService
+
+	expirer := scheduler.NewExpirer(quickTestService)
+	expirer.Interval = time.Duration(config.QuickTest.ExpiryInterval)
+	expirer.Validity = time.Duration(config.QuickTest.ValidityDuration)
+	expirer.LogExpired = config.LogEnabled(LogLevelInfo)
+	expirer.LogRuns = config.LogEnabled(LogLevelDebug)
 
-	return server.Start()
+	// Wait for the expirer to stop before the store is closed.
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	expiryCtx, cancelExpiry := context.WithCancel(ctx)
+	defer cancelExpiry()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		expirer.Run(expiryCtx)
+	}()
+
+	server, err := newServer(config, store)
+	if err != nil {
+		return err
+	}
+	if err := server.Open(); err != nil {
+		return err
+	}
+	logf(config, LogLevelInfo, "listening on %s", server.URL())
+
+	if config.Admin.Addr != "" {
+		admin := http.NewAdminServer()
+		admin.Addr = config.Admin.Addr
+		admin.Ready = store.Ready
+		admin.BuildInfo.Version = version
+		admin.BuildInfo.Commit = commit
+		admin.Pprof = config.Admin.Pprof
+
+		if err := admin.Open(); err != nil {
+			return err
+		}
+		defer admin.Shutdown(context.Background())
+		logf(config, LogLevelInfo, "admin listening on %s", admin.URL())
+	}
+
+	<-ctx.Done()
+	stop()
+	logf(config, LogLevelInfo, "shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(config.Shutdown.GracePeriod))
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("http shutdown: %w", err)
+	}
+	return nil
+}
+
+// logf logs the message if the configured log level includes level.
+func logf(config Config, level, format string, v ...interface{}) {
+	if config.LogEnabled(level) {
+		log.Printf(format, v...)
+	}
+}
+
+// newServer creates the HTTP server for the services of the store.
+func newServer(config Config, store *store) (*http.Server, error) {
+	server, err := http.NewServer(store.QuickTestService)
+	if err != nil {
+		return nil, err
+	}
+	server.UserService = store.UserService
+	server.AuditService = store.AuditService
+	if key, err := config.SessionKeyBytes(); err != nil {
+		return nil, err
+	} else if key != nil {
+		server.SessionKey = key
+	} else {
+		logf(config, LogLevelInfo, "no session key configured, sessions end on restart")
+	}
+	server.Addr = config.HTTP.Addr
+	server.BaseURL = config.HTTP.BaseURL
+	server.CertFile = config.HTTP.CertFile
+	server.KeyFile = config.HTTP.KeyFile
+	server.LogRequests = config.LogEnabled(LogLevelInfo)
+	return server, nil
+}
+
+// store holds the services backed by the configured store.
+type store struct {
+	QuickTestService rona.QuickTestService
+	UserService      rona.UserService
+	AuditService     rona.AuditService
+
+	// AdminAPIKey authenticates as the admin seeded into the memory
+	// store, which has no other users. Empty for the other stores.
+	AdminAPIKey string
+
+	// Ready checks that the store can serve requests.
+	Ready func(ctx context.Context) error
+
+	// Close releases the store.
+	Close func() error
+}
+
+// openStore opens the store selected by db.store.
+func openStore(config Config) (*store, error) {
+	if config.DB.Store == StoreMemory {
+		userService := inmem.NewUserService()
+		key, err := seedAdmin(userService)
+		if err != nil {
+			return nil, err
+		}
+
+		// The key is the only way in, so it is logged at every level.
+		log.Printf("using the memory store, data is lost on exit, use the admin api key %s", key)
+		return &store{
+			QuickTestService: inmem.NewQuickTestService(),
+			UserService:      userService,
+			AdminAPIKey:      key,
+			Close:            func() error { return nil },
+		}, nil
+	}
+
+	keyring, err := config.Keyring()
+	if err != nil {
+		return nil, err
+	}
+
+	if config.DB.Store == StorePostgres {
+		db := postgres.NewDB(config.DB.DSN)
+		db.Keyring = keyring
+		if err := db.Open(); err != nil {
+			return nil, err
+		}
+
+		return &store{
+			QuickTestService: postgres.NewQuickTestService(db),
+			UserService:      postgres.NewUserService(db),
+			AuditService:     postgres.NewAuditService(db),
+			Ready:            db.Ping,
+			Close:            db.Close,
+		}, nil
+	}
+
+	db := sqlite.NewDB(config.DB.DSN)
+	db.Keyring = keyring
+	if err := db.Open(); err != nil {
+		return nil, err
+	}
+
+	return &store{
+		QuickTestService: sqlite.NewQuickTestService(db),
+		UserService:      sqlite.NewUserService(db),
+		AuditService:     sqlite.NewAuditService(db),
+		Ready:            db.Ping,
+		Close:            db.Close,
+	}, nil
+}
+
+// seedAdmin creates an admin in a store without users and returns its API
+// key. The admin has a random password that is never shown, so it can only
+// sign in with the key.
+func seedAdmin(userService rona.UserService) (string, error) {
+	password := make([]byte, 32)
+	if _, err := rand.Read(password); err != nil {
+		return "", err
+	}
+
+	// The seed runs with full access, like the ronad user command.
+	ctx := rona.NewContextWithUser(context.Background(), &rona.User{Name: "ronad", Role: rona.RoleAdmin})
+
+	admin, err := userService.CreateUser(ctx, &rona.UserCreate{
+		Name:     "Admin",
+		Email:    "admin@localhost",
+		Role:     rona.RoleAdmin,
+		Password: hex.EncodeToString(password),
+	})
+	if err != nil {
+		return "", err
+	}
+	return userService.GenerateAPIKey(ctx, admin.ID)
 }
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/richardmarbach/rona"
+)
+
+func TestOpenStore(t *testing.T) {
+	t.Run("use the memory store with the seeded admin", func(t *testing.T) {
+		config, err := ParseConfig(nil, env(map[string]string{"RONA_STORE": "memory"}))
+		assertNoError(t, err)
+
+		store, err := openStore(config)
+		assertNoError(t, err)
+		defer store.Close()
+
+		server, err := newServer(config, store)
+		assertNoError(t, err)
+
+		do := func(path, body string, v interface{}) {
+			t.Helper()
+
+			request := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+			request.Header.Set("Accept", "application/json")
+			request.Header.Set("Content-Type", "application/json")
+			request.Header.Set("Authorization", "Bearer "+store.AdminAPIKey)
+			response := httptest.NewRecorder()
+			server.ServeHTTP(response, request)
+
+			if response.Code != http.StatusOK && response.Code != http.StatusCreated {
+				t.Fatalf("POST %s: unexpected status %d: %s", path, response.Code, response.Body)
+			}
+			assertNoError(t, json.NewDecoder(response.Body).Decode(v))
+		}
+
+		var created rona.QuickTest
+		do("/tests", `{}`, &created)
+
+		var registered rona.QuickTest
+		do("/tests/"+string(created.ID)+"/register", `{"person":"Tim"}`, &registered)
+
+		if registered.ID != created.ID || !registered.Registered() {
+			t.Errorf("expected %v to be registered, got %+v", created.ID, registered)
+		}
+	})
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/richardmarbach/rona/sqlite"
+)
+
+// runMigrate executes the migrate subcommands.
+func runMigrate(args []string, getenv func(string) string, stdout io.Writer) error {
+	const usage = "usage: ronad migrate status|up|down|to <version> [flags]"
+	if len(args) == 0 {
+		return fmt.Errorf(usage)
+	}
+	command := args[0]
+
+	fs := flag.NewFlagSet("ronad migrate "+command, flag.ContinueOnError)
+	configFlag := fs.String("config", DefaultConfigPath, "config file path")
+	dsn := fs.String("dsn", "", "database DSN")
+	dryRun := fs.Bool("dry-run", false, "show and test the migrations without applying them")
+	steps := fs.Int("steps", 1, "number of migrations to revert with down")
+
+	var version int
+	if command == "to" {
+		if len(args) < 2 {
+			return fmt.Errorf(usage)
+		}
+		v, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid version %q", args[1])
+		}
+		version, args = v, args[1:]
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	config, err := loadConfig(fs, *configFlag, getenv)
+	if err != nil {
+		return err
+	}
+	if isFlagSet(fs, "dsn") {
+		config.DB.DSN = *dsn
+	}
+	if err := config.requireSQLite("ronad migrate"); err != nil {
+		return err
+	}
+
+	db := sqlite.NewDB(config.DB.DSN)
+	db.SkipMigrations = true
+	if db.Keyring, err = config.optionalKeyring(); err != nil {
+		return err
+	}
+	if err := db.Open(); err != nil {
+		return err
+	}
+	defer db.Close()
+
+	ctx := context.Background()
+
+	var migrated []*sqlite.MigrationStep
+	switch command {
+	case "status":
+		return printMigrationStatus(ctx, db, stdout)
+	case "up":
+		migrated, err = db.MigrateUp(ctx, *dryRun)
+	case "down":
+		migrated, err = db.MigrateDown(ctx, *steps, *dryRun)
+	case "to":
+		migrated, err = db.MigrateTo(ctx, version, *dryRun)
+	default:
+		return fmt.Errorf(usage)
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, step := range migrated {
+		if *dryRun {
+			fmt.Fprintf(stdout, "-- %s\n%s\n", step, step.SQL())
+		} else {
+			fmt.Fprintf(stdout, "migrated %s\n", step)
+		}
+	}
+	if len(migrated) == 0 {
+		fmt.Fprintln(stdout, "nothing to migrate")
+	} else if *dryRun {
+		fmt.Fprintln(stdout, "dry run, no changes were made")
+	}
+	return nil
+}
+
+// printMigrationStatus writes a table of all migrations.
+func printMigrationStatus(ctx context.Context, db *sqlite.DB, stdout io.Writer) error {
+	statuses, err := db.MigrationStatus(ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+	for _, status := range statuses {
+		state, appliedAt := "pending", ""
+		if status.Applied {
+			state, appliedAt = "applied", status.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		if status.Unknown {
+			state = "unknown, newer than this binary"
+		} else if status.Modified {
+			state = "modified"
+		}
+		fmt.Fprintf(w, "%08d\t%s\t%s\t%s\n", status.Version, status.Name, state, appliedAt)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunMigrate(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "rona.db")
+
+	migrate := func(t *testing.T, args ...string) string {
+		t.Helper()
+
+		var out bytes.Buffer
+		err := runMigrate(append(args, "-dsn", dsn), env(nil), &out)
+		assertNoError(t, err)
+		return out.String()
+	}
+
+	t.Run("migrate up", func(t *testing.T) {
+		if out := migrate(t, "up"); !strings.Contains(out, "migrated up 00000000_quick_tests") {
+			t.Errorf("unexpected output %q", out)
+		}
+		if out := migrate(t, "up"); out != "nothing to migrate\n" {
+			t.Errorf("unexpected output %q", out)
+		}
+	})
+
+	t.Run("dry run down", func(t *testing.T) {
+		out := migrate(t, "down", "-steps", "2", "-dry-run")
+		if !strings.Contains(out, "dry run") || strings.Count(out, "-- down") != 2 {
+			t.Errorf("unexpected output %q", out)
+		}
+		if out := migrate(t, "status"); strings.Contains(out, "pending") {
+			t.Errorf("expected all migrations to be applied:\n%s", out)
+		}
+	})
+
+	t.Run("migrate to a version", func(t *testing.T) {
+		migrate(t, "to", "1")
+
+		out := migrate(t, "status")
+		for _, line := range strings.Split(out, "\n") {
+			if strings.Contains(line, "quick_test_results") && !strings.Contains(line, "applied") {
+				t.Errorf("expected quick_test_results to be applied: %s", line)
+			} else if strings.Contains(line, "quick_test_codes") && !strings.Contains(line, "pending") {
+				t.Errorf("expected quick_test_codes to be pending: %s", line)
+			}
+		}
+	})
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/richardmarbach/rona"
+	"github.com/richardmarbach/rona/envelope"
+	"github.com/richardmarbach/rona/postgres"
+	"github.com/richardmarbach/rona/sqlite"
+)
+
+// runUser executes the user subcommands.
+func runUser(args []string, getenv func(string) string, stdin io.Reader, stdout io.Writer) error {
+	if len(args) == 0 || args[0] != "create" {
+		return fmt.Errorf("usage: ronad user create [flags]")
+	}
+	return runUserCreate(args[1:], getenv, stdin, stdout)
+}
+
+// runUserCreate creates a user directly in the database. This is how the
+// first admin is created. The password is read from RONA_USER_PASSWORD or
+// from the first line of stdin.
+func runUserCreate(args []string, getenv func(string) string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("ronad user create", flag.ContinueOnError)
+	configFlag := fs.String("config", DefaultConfigPath, "config file path")
+	dsn := fs.String("dsn", "", "database DSN")
+	name := fs.String("name", "", "name of the user")
+	email := fs.String("email", "", "email of the user")
+	role := fs.String("role", "", "role of the user (manufacturer, staff, admin)")
+	apiKey := fs.Bool("api-key", false, "generate an API key for the user")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	config, err := loadConfig(fs, *configFlag, getenv)
+	if err != nil {
+		return err
+	}
+	if isFlagSet(fs, "dsn") {
+		config.DB.DSN = *dsn
+	}
+
+	password := getenv("RONA_USER_PASSWORD")
+	if password == "" {
+		line, err := bufio.NewReader(stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+
+	// Opening the database runs pending migrations, which may need the
+	// keyring to re-encrypt existing data.
+	keyring, err := config.optionalKeyring()
+	if err != nil {
+		return err
+	}
+	userService, closeDB, err := openUserService(config, keyring)
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	// The command line has full access to the database and acts as admin.
+	ctx := rona.NewContextWithUser(context.Background(), &rona.User{Name: "ronad", Role: rona.RoleAdmin})
+
+	user, err := userService.CreateUser(ctx, &rona.UserCreate{
+		Name:     *name,
+		Email:    *email,
+		Role:     rona.Role(*role),
+		Password: password,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "created user %d <%s> with role %s\n", user.ID, user.Email, user.Role)
+
+	if *apiKey {
+		key, err := userService.GenerateAPIKey(ctx, user.ID)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "api key: %s\n", key)
+	}
+
+	return nil
+}
+
+// openUserService opens the database of the configured store and returns
+// its UserService together with a function closing the database.
+func openUserService(config Config, keyring *envelope.Keyring) (rona.UserService, func() error, error) {
+	switch config.DB.Store {
+	case StoreSQLite:
+		db := sqlite.NewDB(config.DB.DSN)
+		db.Keyring = keyring
+		return sqlite.NewUserService(db), db.Close, db.Open()
+	case StorePostgres:
+		db := postgres.NewDB(config.DB.DSN)
+		db.Keyring = keyring
+		return postgres.NewUserService(db), db.Close, db.Open()
+	}
+	return nil, nil, fmt.Errorf("ronad user requires an sqlite or postgres store, got %q", config.DB.Store)
+}
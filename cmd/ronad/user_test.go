@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/hex"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/richardmarbach/rona"
+	"github.com/richardmarbach/rona/envelope"
+	"github.com/richardmarbach/rona/sqlite"
+)
+
+func TestRunUser(t *testing.T) {
+	t.Run("create a user", func(t *testing.T) {
+		var out bytes.Buffer
+		err := runUser(
+			[]string{"create", "-dsn", filepath.Join(t.TempDir(), "rona.db"), "-name", "Jane", "-email", "jane@example.com", "-role", "admin"},
+			env(map[string]string{"RONA_USER_PASSWORD": "password123"}),
+			strings.NewReader(""),
+			&out,
+		)
+		assertNoError(t, err)
+
+		if !strings.Contains(out.String(), "created user 1 <jane@example.com> with role admin") {
+			t.Errorf("unexpected output %q", out.String())
+		}
+	})
+
+	t.Run("migrate encrypted data with the keyring", func(t *testing.T) {
+		dsn := filepath.Join(t.TempDir(), "rona.db")
+		assertNoError(t, runMigrate([]string{"to", "7", "-dsn", dsn}, env(nil), &bytes.Buffer{}))
+
+		// Converting the ID of an encrypted person to its canonical form
+		// seals the person again.
+		key := envelope.NewKey()
+		keyring, err := envelope.NewKeyring("k1", map[string][]byte{"k1": key})
+		assertNoError(t, err)
+
+		id := rona.QuickTestID(strings.ToUpper(string(rona.NewQuickTestID())))
+		person, err := keyring.Seal(id, "Tim")
+		assertNoError(t, err)
+
+		raw, err := sql.Open(sqlite.DriverName, dsn)
+		assertNoError(t, err)
+		_, err = raw.Exec(`
+			INSERT INTO quick_tests (id, code, person, person_key_id, person_dek, created_at)
+			VALUES (?, ?, ?, ?, ?, 0)
+		`, string(id), rona.NewQuickTestCode(), person.Ciphertext, person.KeyID, person.DataKey)
+		raw.Close()
+		assertNoError(t, err)
+
+		err = runUser(
+			[]string{"create", "-dsn", dsn, "-name", "Jane", "-email", "jane@example.com", "-role", "admin"},
+			env(map[string]string{
+				"RONA_USER_PASSWORD":   "password123",
+				"RONA_ENCRYPTION_KEYS": "k1 " + hex.EncodeToString(key),
+			}),
+			strings.NewReader(""),
+			&bytes.Buffer{},
+		)
+		assertNoError(t, err)
+	})
+}
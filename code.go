@@ -0,0 +1,125 @@
+package rona
+
+import (
+	"crypto/rand"
+	"strings"
+)
+
+// Short code constants
+const (
+	// QuickTestCodeLen is the number of characters in a short code,
+	// including the check character.
+	QuickTestCodeLen = 10
+
+	// quickTestCodeGroup is the number of characters per group when a
+	// code is formatted for humans.
+	quickTestCodeGroup = 5
+)
+
+// crockford is the Crockford base32 alphabet. It excludes I, L, O and U
+// which are easily confused with other characters.
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// QuickTestCode is a short, human-friendly alternative to the QuickTestID.
+// It consists of Crockford base32 characters with a trailing check
+// character that detects single character typos and most swaps of
+// adjacent characters.
+//
+// Codes are stored normalized: upper case without group separators.
+type QuickTestCode string
+
+// NewQuickTestCode generates a new random short code.
+func NewQuickTestCode() QuickTestCode {
+	buf := make([]byte, QuickTestCodeLen-1)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+
+	for i, b := range buf {
+		buf[i] = crockford[b&31]
+	}
+	return QuickTestCode(string(buf) + string(crockford[checkValue(buf)]))
+}
+
+// ParseQuickTestCode normalizes a code typed by a person and validates it.
+// Case, group separators and the commonly confused characters I, L and O
+// are accepted.
+func ParseQuickTestCode(s string) (QuickTestCode, error) {
+	var buf []byte
+	for _, r := range strings.ToUpper(s) {
+		switch r {
+		case '-', ' ':
+			continue
+		case 'I', 'L':
+			r = '1'
+		case 'O':
+			r = '0'
+		}
+
+		if r > 0x7f || strings.IndexByte(crockford, byte(r)) == -1 {
+			return "", Errorf(EINVALID, "invalid character in code: %q", r)
+		}
+		buf = append(buf, byte(r))
+	}
+
+	code := QuickTestCode(buf)
+	if err := code.Validate(); err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// Validate the normalized code.
+func (c QuickTestCode) Validate() error {
+	if c == "" {
+		return Errorf(EINVALID, "code is required")
+	} else if len(c) != QuickTestCodeLen {
+		return Errorf(EINVALID, "code must be %d characters long", QuickTestCodeLen)
+	}
+
+	for i := 0; i < len(c); i++ {
+		if strings.IndexByte(crockford, c[i]) == -1 {
+			return Errorf(EINVALID, "invalid character in code: %q", c[i])
+		}
+	}
+
+	if crockford[checkValue([]byte(c[:len(c)-1]))] != c[len(c)-1] {
+		return Errorf(EINVALID, "code is invalid, please check it for typos")
+	}
+	return nil
+}
+
+// String formats the code in groups for readability, e.g. "ABCDE-FGHJK".
+func (c QuickTestCode) String() string {
+	if len(c) <= quickTestCodeGroup {
+		return string(c)
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(c); i += quickTestCodeGroup {
+		if i > 0 {
+			b.WriteByte('-')
+		}
+		end := i + quickTestCodeGroup
+		if end > len(c) {
+			end = len(c)
+		}
+		b.WriteString(string(c[i:end]))
+	}
+	return b.String()
+}
+
+// checkValue calculates the check character value of the Crockford
+// encoded payload using the Luhn mod N algorithm.
+func checkValue(payload []byte) int {
+	const n = len(crockford)
+
+	factor, sum := 2, 0
+	for i := len(payload) - 1; i >= 0; i-- {
+		addend := factor * strings.IndexByte(crockford, payload[i])
+		sum += addend/n + addend%n
+
+		factor = 3 - factor
+	}
+	return (n - sum%n) % n
+}
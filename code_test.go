@@ -0,0 +1,89 @@
+package rona_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/richardmarbach/rona"
+)
+
+func TestNewQuickTestCode(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		code := rona.NewQuickTestCode()
+		if err := code.Validate(); err != nil {
+			t.Fatalf("generated invalid code %v: %v", code, err)
+		}
+	}
+}
+
+func TestParseQuickTestCode(t *testing.T) {
+	code := rona.NewQuickTestCode()
+
+	t.Run("accept the formatted code", func(t *testing.T) {
+		parsed, err := rona.ParseQuickTestCode(code.String())
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if parsed != code {
+			t.Errorf("want %v, got %v", code, parsed)
+		}
+	})
+
+	t.Run("normalize case and confusable characters", func(t *testing.T) {
+		typed := strings.NewReplacer("0", "o", "1", "l").Replace(strings.ToLower(code.String()))
+
+		parsed, err := rona.ParseQuickTestCode(typed)
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if parsed != code {
+			t.Errorf("want %v, got %v", code, parsed)
+		}
+	})
+
+	t.Run("detect every single character typo", func(t *testing.T) {
+		const alphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+		for i := 0; i < len(code); i++ {
+			for j := 0; j < len(alphabet); j++ {
+				if alphabet[j] == code[i] {
+					continue
+				}
+				typo := string(code[:i]) + string(alphabet[j]) + string(code[i+1:])
+
+				_, err := rona.ParseQuickTestCode(typo)
+				if rona.ErrorCode(err) != rona.EINVALID {
+					t.Fatalf("expected EINVALID for typo %v of %v, got %v", typo, code, err)
+				}
+			}
+		}
+	})
+
+	t.Run("reject malformed codes", func(t *testing.T) {
+		cases := []struct {
+			message string
+			code    string
+		}{
+			{"empty", ""},
+			{"too short", "ABCDE"},
+			{"too long", string(code) + "0"},
+			{"invalid character", "ABCDE-FGHJU"},
+		}
+
+		for _, tc := range cases {
+			t.Run(tc.message, func(t *testing.T) {
+				_, err := rona.ParseQuickTestCode(tc.code)
+				if rona.ErrorCode(err) != rona.EINVALID {
+					t.Errorf("expected EINVALID, got %v", err)
+				}
+			})
+		}
+	})
+}
+
+func TestQuickTestCode_String(t *testing.T) {
+	code := rona.QuickTestCode("ABCDEFGHJK")
+	if got := code.String(); got != "ABCDE-FGHJK" {
+		t.Errorf("want %v, got %v", "ABCDE-FGHJK", got)
+	}
+}
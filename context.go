@@ -6,6 +6,8 @@ type contextKey int
 
 const (
 	flashContextKey = contextKey(iota + 1)
+	userContextKey
+	requestIDContextKey
 )
 
 // NewContextWithFlash creates a context with the flash value.
@@ -18,3 +20,26 @@ func FlashFromContext(ctx context.Context) string {
 	v, _ := ctx.Value(flashContextKey).(string)
 	return v
 }
+
+// NewContextWithUser creates a context with the authenticated user.
+func NewContextWithUser(ctx context.Context, user *User) context.Context {
+	return context.WithValue(ctx, userContextKey, user)
+}
+
+// UserFromContext returns the authenticated user for the current request.
+// Returns nil when no user is signed in.
+func UserFromContext(ctx context.Context) *User {
+	user, _ := ctx.Value(userContextKey).(*User)
+	return user
+}
+
+// NewContextWithRequestID creates a context with the ID of the current request.
+func NewContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey, id)
+}
+
+// RequestIDFromContext returns the ID of the current request.
+func RequestIDFromContext(ctx context.Context) string {
+	v, _ := ctx.Value(requestIDContextKey).(string)
+	return v
+}
@@ -0,0 +1,244 @@
+// Package envelope encrypts personal data with envelope encryption. Each
+// value is encrypted with its own data key, which is wrapped by a key of
+// the keyring.
+package envelope
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/richardmarbach/rona"
+)
+
+// KeySize is the size of the AES-256 keys in the keyring.
+const KeySize = 32
+
+// keyIDPattern restricts key IDs to names that are safe to store and log.
+var keyIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Keyring holds the key encryption keys used to protect personal data.
+//
+// Each person is encrypted with its own random data key, which is stored
+// next to the ciphertext wrapped with a key from the keyring. Rows record
+// the ID of the wrapping key, so keys can be rotated by re-wrapping the
+// data keys without touching the ciphertext.
+type Keyring struct {
+	primary string
+	keys    map[string][]byte
+}
+
+// NewKeyring creates a keyring. New data is encrypted with the primary key,
+// the other keys are only used for decryption.
+func NewKeyring(primary string, keys map[string][]byte) (*Keyring, error) {
+	if _, ok := keys[primary]; !ok {
+		return nil, fmt.Errorf("primary key %q is missing", primary)
+	}
+
+	k := &Keyring{primary: primary, keys: make(map[string][]byte, len(keys))}
+	for id, key := range keys {
+		if !keyIDPattern.MatchString(id) {
+			return nil, fmt.Errorf("invalid key id %q", id)
+		} else if len(key) != KeySize {
+			return nil, fmt.Errorf("key %q must be %d bytes long", id, KeySize)
+		}
+		k.keys[id] = key
+	}
+	return k, nil
+}
+
+// ParseKeyring reads a keyring with one "<id> <hex key>" pair per line. The
+// first key is the primary key. Empty lines and lines starting with # are
+// ignored.
+func ParseKeyring(r io.Reader) (*Keyring, error) {
+	var primary string
+	keys := make(map[string][]byte)
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected \"<id> <hex key>\"", line)
+		}
+
+		id := fields[0]
+		key, err := hex.DecodeString(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: key must be hex encoded", line)
+		} else if _, ok := keys[id]; ok {
+			return nil, fmt.Errorf("line %d: duplicate key id %q", line, id)
+		}
+
+		if primary == "" {
+			primary = id
+		}
+		keys[id] = key
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if primary == "" {
+		return nil, fmt.Errorf("keyring is empty")
+	}
+	return NewKeyring(primary, keys)
+}
+
+// NewKey generates a random key for the keyring.
+func NewKey() []byte {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// PrimaryKeyID returns the ID of the key new data is encrypted with.
+func (k *Keyring) PrimaryKeyID() string {
+	return k.primary
+}
+
+// Sealed is the encrypted form of a person as stored in the database.
+type Sealed struct {
+	// Ciphertext holds the person encrypted with the data key. Rows
+	// written before encryption was introduced hold the plaintext and
+	// have no KeyID.
+	Ciphertext []byte
+
+	// KeyID identifies the keyring key that wraps DataKey.
+	KeyID string
+
+	// DataKey is the wrapped data key of the person.
+	DataKey []byte
+}
+
+// Seal encrypts the person of a quick test with a new data key. The test ID
+// is authenticated so ciphertexts can't be moved between tests.
+func (k *Keyring) Seal(id rona.QuickTestID, person string) (*Sealed, error) {
+	if person == "" {
+		return &Sealed{}, nil
+	}
+
+	dataKey := NewKey()
+	ciphertext, err := encrypt(dataKey, []byte(person), []byte(id))
+	if err != nil {
+		return nil, err
+	}
+
+	sealed := &Sealed{Ciphertext: ciphertext}
+	if err := k.wrap(sealed, dataKey); err != nil {
+		return nil, err
+	}
+	return sealed, nil
+}
+
+// Open decrypts the person of a quick test. Rows written before encryption
+// was introduced have no key ID and contain the plaintext.
+func (k *Keyring) Open(id rona.QuickTestID, sealed *Sealed) (string, error) {
+	if sealed.KeyID == "" {
+		return string(sealed.Ciphertext), nil
+	}
+
+	dataKey, err := k.unwrap(sealed)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext, err := decrypt(dataKey, sealed.Ciphertext, []byte(id))
+	if err != nil {
+		return "", fmt.Errorf("cannot decrypt person of %v: %w", id, err)
+	}
+	return string(plaintext), nil
+}
+
+// Rewrap wraps the data key of sealed with the primary key.
+func (k *Keyring) Rewrap(id rona.QuickTestID, sealed *Sealed) error {
+	if sealed.KeyID == "" {
+		person := string(sealed.Ciphertext)
+		resealed, err := k.Seal(id, person)
+		if err != nil {
+			return err
+		}
+		*sealed = *resealed
+		return nil
+	}
+
+	dataKey, err := k.unwrap(sealed)
+	if err != nil {
+		return err
+	}
+	return k.wrap(sealed, dataKey)
+}
+
+// wrap encrypts the data key with the primary key.
+func (k *Keyring) wrap(sealed *Sealed, dataKey []byte) error {
+	wrapped, err := encrypt(k.keys[k.primary], dataKey, []byte(k.primary))
+	if err != nil {
+		return err
+	}
+	sealed.KeyID = k.primary
+	sealed.DataKey = wrapped
+	return nil
+}
+
+// unwrap decrypts the data key of sealed.
+func (k *Keyring) unwrap(sealed *Sealed) ([]byte, error) {
+	key, ok := k.keys[sealed.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("encryption key %q is not in the keyring", sealed.KeyID)
+	}
+
+	dataKey, err := decrypt(key, sealed.DataKey, []byte(sealed.KeyID))
+	if err != nil {
+		return nil, fmt.Errorf("cannot unwrap data key with %q: %w", sealed.KeyID, err)
+	}
+	return dataKey, nil
+}
+
+// encrypt seals plaintext with AES-GCM. The random nonce is prepended to
+// the ciphertext.
+func encrypt(key, plaintext, additionalData []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// decrypt opens a ciphertext created by encrypt.
+func decrypt(key, ciphertext, additionalData []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	nonce, ciphertext := ciphertext[:aead.NonceSize()], ciphertext[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
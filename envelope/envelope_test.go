@@ -0,0 +1,130 @@
+package envelope_test
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/richardmarbach/rona"
+	"github.com/richardmarbach/rona/envelope"
+)
+
+func TestParseKeyring(t *testing.T) {
+	key := hex.EncodeToString(envelope.NewKey())
+
+	t.Run("first key is the primary key", func(t *testing.T) {
+		keyring, err := envelope.ParseKeyring(strings.NewReader(fmt.Sprintf(`
+# rotated 2021-06
+new %s
+old %s
+`, key, hex.EncodeToString(envelope.NewKey()))))
+		assertNoError(t, err)
+
+		if keyring.PrimaryKeyID() != "new" {
+			t.Errorf("want primary key new, got %v", keyring.PrimaryKeyID())
+		}
+	})
+
+	cases := []struct {
+		message string
+		keyring string
+	}{
+		{"empty keyring", "# no keys\n"},
+		{"missing key", "primary\n"},
+		{"key not hex", "primary zz\n"},
+		{"short key", "primary abcd\n"},
+		{"duplicate key id", fmt.Sprintf("primary %s\nprimary %s\n", key, key)},
+		{"invalid key id", fmt.Sprintf("prim/ary %s\n", key)},
+	}
+	for _, tc := range cases {
+		t.Run(tc.message, func(t *testing.T) {
+			if _, err := envelope.ParseKeyring(strings.NewReader(tc.keyring)); err == nil {
+				t.Error("expected an error but didn't get one")
+			}
+		})
+	}
+}
+
+func TestKeyring_Seal(t *testing.T) {
+	keyring := MustNewKeyring(t, "new", "old")
+	id := rona.NewQuickTestID()
+
+	t.Run("open sealed persons", func(t *testing.T) {
+		sealed, err := keyring.Seal(id, "Tim Tester")
+		assertNoError(t, err)
+
+		if strings.Contains(string(sealed.Ciphertext), "Tim Tester") || sealed.KeyID != "new" {
+			t.Fatalf("expected the person to be encrypted with the primary key, got %+v", sealed)
+		}
+
+		person, err := keyring.Open(id, sealed)
+		assertNoError(t, err)
+		if person != "Tim Tester" {
+			t.Errorf("want person Tim Tester, got %q", person)
+		}
+	})
+
+	t.Run("reject persons of other ids", func(t *testing.T) {
+		sealed, err := keyring.Seal(id, "Tim Tester")
+		assertNoError(t, err)
+
+		if _, err := keyring.Open(rona.NewQuickTestID(), sealed); err == nil {
+			t.Error("expected an error but didn't get one")
+		}
+	})
+
+	t.Run("rewrap with the primary key", func(t *testing.T) {
+		old := MustNewKeyring(t, "old")
+		sealed, err := old.Seal(id, "Tim Tester")
+		assertNoError(t, err)
+
+		assertNoError(t, keyring.Rewrap(id, sealed))
+		if sealed.KeyID != "new" {
+			t.Errorf("want key new, got %v", sealed.KeyID)
+		}
+
+		person, err := keyring.Open(id, sealed)
+		assertNoError(t, err)
+		if person != "Tim Tester" {
+			t.Errorf("want person Tim Tester, got %q", person)
+		}
+	})
+
+	t.Run("read plaintext persons", func(t *testing.T) {
+		person, err := keyring.Open(id, &envelope.Sealed{Ciphertext: []byte("Tim Tester")})
+		assertNoError(t, err)
+		if person != "Tim Tester" {
+			t.Errorf("want person Tim Tester, got %q", person)
+		}
+	})
+}
+
+var testKeys = map[string][]byte{}
+
+// MustNewKeyring returns a keyring with the named keys. The first key is
+// the primary key.
+func MustNewKeyring(tb testing.TB, ids ...string) *envelope.Keyring {
+	tb.Helper()
+
+	keys := make(map[string][]byte)
+	for _, id := range ids {
+		if _, ok := testKeys[id]; !ok {
+			testKeys[id] = envelope.NewKey()
+		}
+		keys[id] = testKeys[id]
+	}
+
+	keyring, err := envelope.NewKeyring(ids[0], keys)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return keyring
+}
+
+func assertNoError(tb testing.TB, err error) {
+	tb.Helper()
+	if err != nil {
+		tb.Fatalf("expected no error, got %v", err)
+	}
+}
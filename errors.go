@@ -13,6 +13,14 @@ const (
 	ECONFLICT     = "conflict"
 	EEXPIRED      = "expired"
 	EUNAUTHORIZED = "unauthorized"
+
+	// EUNAVAILABLE reports a temporary failure, the operation can be
+	// retried.
+	EUNAVAILABLE = "unavailable"
+
+	// ECANCELED reports that the operation was canceled or timed out
+	// before it completed.
+	ECANCELED = "canceled"
 )
 
 // An Error in the application. All non-application
@@ -23,13 +31,25 @@ type Error struct {
 
 	// Human readable error message
 	Message string
+
+	// Err is the underlying error, if any. It is logged but never shown
+	// to users.
+	Err error
 }
 
 // Error implements the Error interface
 func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("rona error: code=%s message=%s: %v", e.Code, e.Message, e.Err)
+	}
 	return fmt.Sprintf("rona error: code=%s message=%s", e.Code, e.Message)
 }
 
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
 // ErrorCode unwraps the error into an application error code
 func ErrorCode(err error) string {
 	var e *Error
@@ -42,6 +62,19 @@ func ErrorCode(err error) string {
 	return EINTERNAL
 }
 
+// ErrorMessage unwraps the error into a human readable message. All
+// non-application errors return a generic message.
+func ErrorMessage(err error) string {
+	var e *Error
+
+	if err == nil {
+		return ""
+	} else if errors.As(err, &e) {
+		return e.Message
+	}
+	return "Internal error."
+}
+
 // Errorf creates a new formatted error for the given error code.
 func Errorf(code string, message string, args ...interface{}) *Error {
 	return &Error{
@@ -49,3 +82,13 @@ func Errorf(code string, message string, args ...interface{}) *Error {
 		Message: fmt.Sprintf(message, args...),
 	}
 }
+
+// WrapError creates a new formatted error for the given error code that
+// wraps the underlying error.
+func WrapError(code string, err error, message string, args ...interface{}) *Error {
+	return &Error{
+		Code:    code,
+		Message: fmt.Sprintf(message, args...),
+		Err:     err,
+	}
+}
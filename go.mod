@@ -3,9 +3,14 @@ module github.com/richardmarbach/rona
 go 1.16
 
 require (
+	github.com/BurntSushi/toml v1.0.0
 	github.com/go-chi/chi v1.5.4
-	github.com/google/uuid v1.2.0
-	github.com/mattn/go-sqlite3 v1.14.6
+	github.com/google/uuid v1.3.0
+	github.com/lib/pq v1.9.0
+	github.com/mattn/go-sqlite3 v1.14.12
 	github.com/pkg/errors v0.9.1
 	github.com/prometheus/client_golang v1.10.0
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
+	golang.org/x/crypto v0.0.0-20210322153248-0c34fe9e7dc2
+	modernc.org/sqlite v1.17.3
 )
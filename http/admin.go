@@ -0,0 +1,148 @@
+package http
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// readyTimeout limits how long the readiness check may take.
+const readyTimeout = 5 * time.Second
+
+var buildInfoGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "rona_build_info",
+	Help: "Build information of the running binary",
+}, []string{"version", "commit", "go_version"})
+
+// BuildInfo describes the running binary.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+// AdminServer serves the operational endpoints: metrics, health checks,
+// build info and optionally the runtime profiles. It must listen on a
+// private address, apart from the public Server.
+type AdminServer struct {
+	server *http.Server
+	ln     net.Listener
+	router chi.Router
+
+	// Addr is the address the server listens on.
+	Addr string
+
+	// Ready checks whether the application can serve requests, for
+	// example by pinging the database. Always ready when nil.
+	Ready func(ctx context.Context) error
+
+	// BuildInfo is reported on /buildinfo and as the rona_build_info metric.
+	BuildInfo BuildInfo
+
+	// Pprof serves the runtime profiles under /debug/pprof/.
+	Pprof bool
+}
+
+// NewAdminServer creates a new admin server.
+func NewAdminServer() *AdminServer {
+	s := &AdminServer{
+		Addr:      "127.0.0.1:9090",
+		BuildInfo: BuildInfo{Version: "dev", GoVersion: runtime.Version()},
+	}
+
+	router := chi.NewRouter()
+	router.Use(middleware.Recoverer)
+	router.Method(http.MethodGet, "/metrics", promhttp.Handler())
+	router.Get("/healthz", s.healthz)
+	router.Get("/readyz", s.readyz)
+	router.Get("/buildinfo", s.buildInfo)
+	router.Mount("/debug", s.pprof(middleware.Profiler()))
+
+	s.router = router
+	s.server = &http.Server{Handler: s}
+	return s
+}
+
+// Open starts listening on Addr and serves requests in the background.
+func (s *AdminServer) Open() (err error) {
+	buildInfoGauge.WithLabelValues(s.BuildInfo.Version, s.BuildInfo.Commit, s.BuildInfo.GoVersion).Set(1)
+
+	if s.ln, err = net.Listen("tcp", s.Addr); err != nil {
+		return err
+	}
+
+	go func() {
+		if err := s.server.Serve(s.ln); err != nil && err != http.ErrServerClosed {
+			log.Printf("http: admin serve error: %v", err)
+		}
+	}()
+	return nil
+}
+
+// Shutdown stops accepting new connections and waits for in-flight
+// requests to finish until ctx is done.
+func (s *AdminServer) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+// URL returns the base URL the server is listening on. Only valid after
+// the server has been opened.
+func (s *AdminServer) URL() string {
+	if s.ln == nil {
+		return "http://" + s.Addr
+	}
+	return "http://" + s.ln.Addr().String()
+}
+
+func (s *AdminServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.router.ServeHTTP(w, r)
+}
+
+// statusResponse is the JSON body of the health checks.
+type statusResponse struct {
+	Status string `json:"status"`
+}
+
+// healthz reports that the process is up.
+func (s *AdminServer) healthz(w http.ResponseWriter, r *http.Request) {
+	encodeJSON(w, http.StatusOK, &statusResponse{Status: "ok"})
+}
+
+// readyz reports whether the application can serve requests.
+func (s *AdminServer) readyz(w http.ResponseWriter, r *http.Request) {
+	if s.Ready != nil {
+		ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+		defer cancel()
+
+		if err := s.Ready(ctx); err != nil {
+			log.Printf("http: not ready: %v", err)
+			encodeJSON(w, http.StatusServiceUnavailable, &statusResponse{Status: "unavailable"})
+			return
+		}
+	}
+	encodeJSON(w, http.StatusOK, &statusResponse{Status: "ok"})
+}
+
+func (s *AdminServer) buildInfo(w http.ResponseWriter, r *http.Request) {
+	encodeJSON(w, http.StatusOK, &s.BuildInfo)
+}
+
+// pprof only passes requests to the profiler when profiling is enabled.
+func (s *AdminServer) pprof(profiler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.Pprof {
+			http.NotFound(w, r)
+			return
+		}
+		profiler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,111 @@
+package http_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/richardmarbach/rona"
+	ronahttp "github.com/richardmarbach/rona/http"
+)
+
+func TestAdminServer(t *testing.T) {
+	get := func(handler http.Handler, path string) *httptest.ResponseRecorder {
+		request, _ := http.NewRequest(http.MethodGet, path, nil)
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		return response
+	}
+
+	t.Run("report health", func(t *testing.T) {
+		admin := ronahttp.NewAdminServer()
+
+		if response := get(admin, "/healthz"); response.Code != http.StatusOK {
+			t.Errorf("want %v, got %v", http.StatusOK, response.Code)
+		}
+	})
+
+	t.Run("report readiness", func(t *testing.T) {
+		admin := ronahttp.NewAdminServer()
+
+		var ready error
+		admin.Ready = func(ctx context.Context) error { return ready }
+
+		if response := get(admin, "/readyz"); response.Code != http.StatusOK {
+			t.Errorf("want %v, got %v", http.StatusOK, response.Code)
+		}
+
+		ready = errors.New("database is gone")
+		if response := get(admin, "/readyz"); response.Code != http.StatusServiceUnavailable {
+			t.Errorf("want %v, got %v", http.StatusServiceUnavailable, response.Code)
+		}
+	})
+
+	t.Run("report build info", func(t *testing.T) {
+		admin := ronahttp.NewAdminServer()
+		admin.BuildInfo.Version = "v1.2.3"
+
+		var info ronahttp.BuildInfo
+		if err := json.NewDecoder(get(admin, "/buildinfo").Body).Decode(&info); err != nil {
+			t.Fatal(err)
+		}
+		if info.Version != "v1.2.3" || info.GoVersion == "" {
+			t.Errorf("unexpected build info %+v", info)
+		}
+	})
+
+	t.Run("serve request metrics by route", func(t *testing.T) {
+		server := MustCreateServer(t)
+		server.LogRequests = false
+		server.QuickTestService.FindQuickTestByIDFn = func(ctx context.Context, id rona.QuickTestID) (*rona.QuickTest, error) {
+			return &rona.QuickTest{ID: id}, nil
+		}
+		request, _ := http.NewRequest(http.MethodGet, "/tests/"+string(testID), nil)
+		request.Header.Set("Accept", "application/json")
+		server.ServeHTTP(httptest.NewRecorder(), request)
+
+		body, _ := ioutil.ReadAll(get(ronahttp.NewAdminServer(), "/metrics").Body)
+
+		want := `rona_http_requests_total{method="GET",route="/tests/{testID}",status="200"}`
+		if !strings.Contains(string(body), want) {
+			t.Errorf("expected %s in metrics", want)
+		}
+		if strings.Contains(string(body), string(testID)) {
+			t.Errorf("expected no test IDs in metrics")
+		}
+	})
+
+	t.Run("label unknown methods as other", func(t *testing.T) {
+		server := MustCreateServer(t)
+		server.LogRequests = false
+		request, _ := http.NewRequest("RONA-METHOD", "/tests/"+string(testID), nil)
+		server.ServeHTTP(httptest.NewRecorder(), request)
+
+		body, _ := ioutil.ReadAll(get(ronahttp.NewAdminServer(), "/metrics").Body)
+
+		if strings.Contains(string(body), "RONA-METHOD") {
+			t.Errorf("expected no label for the unknown method")
+		}
+		if !strings.Contains(string(body), `method="other"`) {
+			t.Errorf("expected the unknown method to be labeled other")
+		}
+	})
+
+	t.Run("serve profiles only when enabled", func(t *testing.T) {
+		admin := ronahttp.NewAdminServer()
+
+		if response := get(admin, "/debug/pprof/"); response.Code != http.StatusNotFound {
+			t.Errorf("want %v, got %v", http.StatusNotFound, response.Code)
+		}
+
+		admin.Pprof = true
+		if response := get(admin, "/debug/pprof/"); response.Code != http.StatusOK {
+			t.Errorf("want %v, got %v", http.StatusOK, response.Code)
+		}
+	})
+}
@@ -0,0 +1,122 @@
+package http
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/google/uuid"
+	"github.com/richardmarbach/rona"
+)
+
+// auditPageSize is the number of events shown per page.
+const auditPageSize = 100
+
+// registerAuditRoutes mounts the audit log routes on r.
+func (s *Server) registerAuditRoutes(r chi.Router) {
+	r.Get("/audit", s.showAuditEvents)
+}
+
+// auditEventsResponse is the JSON response of the audit log.
+type auditEventsResponse struct {
+	Events []*rona.AuditEvent `json:"events"`
+	N      int                `json:"n"`
+}
+
+// auditPageData is passed to the audit template.
+type auditPageData struct {
+	Events      []*rona.AuditEvent
+	N           int
+	QuickTestID string
+	Action      string
+	Actions     []rona.AuditAction
+	Offset      int
+	PrevURL     string
+	NextURL     string
+}
+
+// showAuditEvents lists audit events, optionally filtered by test and
+// action. Only admins can read the audit log.
+func (s *Server) showAuditEvents(w http.ResponseWriter, r *http.Request) {
+	if s.AuditService == nil {
+		s.writeError(w, r, rona.Errorf(rona.ENOTFOUND, "audit log is not available"))
+		return
+	}
+
+	query := r.URL.Query()
+	filter := rona.AuditEventFilter{Limit: auditPageSize}
+	if v := query.Get("test"); v != "" {
+		id, err := s.resolveQuickTestID(r.Context(), v)
+		if err != nil {
+			s.writeError(w, r, err)
+			return
+		}
+		// Audit events name tests by their canonical ID.
+		if u, err := uuid.Parse(string(id)); err == nil {
+			id = rona.QuickTestID(u.String())
+		}
+		filter.QuickTestID = &id
+	}
+	if v := query.Get("action"); v != "" {
+		action := rona.AuditAction(v)
+		filter.Action = &action
+	}
+	if v := query.Get("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			s.writeError(w, r, rona.Errorf(rona.EINVALID, "invalid offset"))
+			return
+		}
+		filter.Offset = offset
+	}
+
+	events, n, err := s.AuditService.FindAuditEvents(r.Context(), filter)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	if acceptsJSON(r) {
+		encodeJSON(w, http.StatusOK, &auditEventsResponse{Events: events, N: n})
+		return
+	}
+
+	data := &auditPageData{
+		Events:      events,
+		N:           n,
+		QuickTestID: query.Get("test"),
+		Action:      query.Get("action"),
+		Actions: []rona.AuditAction{
+			rona.AuditActionCreate,
+			rona.AuditActionRegister,
+			rona.AuditActionResult,
+			rona.AuditActionExpire,
+		},
+		Offset: filter.Offset,
+	}
+	if filter.Offset > 0 {
+		data.PrevURL = auditPageURL(query, filter.Offset-auditPageSize)
+	}
+	if filter.Offset+len(events) < n {
+		data.NextURL = auditPageURL(query, filter.Offset+auditPageSize)
+	}
+
+	if err := s.TC.Render(w, "audit", data); err != nil {
+		logError(r, err)
+	}
+}
+
+// auditPageURL returns the audit log URL for the page at offset.
+func auditPageURL(query url.Values, offset int) string {
+	if offset < 0 {
+		offset = 0
+	}
+
+	q := url.Values{}
+	for k, v := range query {
+		q[k] = v
+	}
+	q.Set("offset", strconv.Itoa(offset))
+	return "/admin/audit?" + q.Encode()
+}
@@ -0,0 +1,124 @@
+package http_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/richardmarbach/rona"
+)
+
+func TestShowAuditEvents(t *testing.T) {
+	t.Run("filter by test as json", func(t *testing.T) {
+		server := MustCreateServer(t)
+
+		server.AuditService.FindAuditEventsFn = func(ctx context.Context, filter rona.AuditEventFilter) ([]*rona.AuditEvent, int, error) {
+			if filter.QuickTestID == nil || *filter.QuickTestID != testID {
+				t.Errorf("want test filter %v, got %v", testID, filter.QuickTestID)
+			}
+			if filter.Action == nil || *filter.Action != rona.AuditActionExpire {
+				t.Errorf("want action filter %v, got %v", rona.AuditActionExpire, filter.Action)
+			}
+			return []*rona.AuditEvent{{ID: 1, Action: rona.AuditActionExpire, QuickTestID: testID, Outcome: rona.AuditOutcomeSuccess}}, 1, nil
+		}
+
+		request, _ := http.NewRequest(http.MethodGet, "/admin/audit?test="+string(testID)+"&action=expire", nil)
+		request.Header.Set("Accept", "application/json")
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		if response.Code != http.StatusOK {
+			t.Fatalf("want %v, got %v", http.StatusOK, response.Code)
+		}
+
+		var got struct {
+			Events []*rona.AuditEvent `json:"events"`
+			N      int                `json:"n"`
+		}
+		MustDecodeJSON(t, response, &got)
+		if got.N != 1 || len(got.Events) != 1 || got.Events[0].QuickTestID != testID {
+			t.Errorf("unexpected response %+v", got)
+		}
+	})
+
+	t.Run("filter by the canonical test id", func(t *testing.T) {
+		server := MustCreateServer(t)
+
+		server.AuditService.FindAuditEventsFn = func(ctx context.Context, filter rona.AuditEventFilter) ([]*rona.AuditEvent, int, error) {
+			if filter.QuickTestID == nil || *filter.QuickTestID != testID {
+				t.Errorf("want test filter %v, got %v", testID, filter.QuickTestID)
+			}
+			return nil, 0, nil
+		}
+
+		request, _ := http.NewRequest(http.MethodGet, "/admin/audit?test="+strings.ToUpper(string(testID)), nil)
+		request.Header.Set("Accept", "application/json")
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		if response.Code != http.StatusOK {
+			t.Errorf("want %v, got %v", http.StatusOK, response.Code)
+		}
+	})
+
+	t.Run("render the audit page", func(t *testing.T) {
+		server := MustCreateServer(t)
+
+		server.AuditService.FindAuditEventsFn = func(ctx context.Context, filter rona.AuditEventFilter) ([]*rona.AuditEvent, int, error) {
+			return []*rona.AuditEvent{{ID: 1, Action: rona.AuditActionRegister, QuickTestID: testID, RequestID: "req-1", Outcome: rona.EEXPIRED}}, 1, nil
+		}
+
+		request, _ := http.NewRequest(http.MethodGet, "/admin/audit", nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		if response.Code != http.StatusOK {
+			t.Fatalf("want %v, got %v", http.StatusOK, response.Code)
+		}
+		for _, want := range []string{string(testID), "req-1", rona.EEXPIRED} {
+			if !strings.Contains(response.Body.String(), want) {
+				t.Errorf("expected page to contain %q", want)
+			}
+		}
+	})
+
+	t.Run("pass the request id to the services", func(t *testing.T) {
+		server := MustCreateServer(t)
+
+		var got string
+		server.QuickTestService.ExpireQuickTestFn = func(ctx context.Context, id rona.QuickTestID) error {
+			got = rona.RequestIDFromContext(ctx)
+			return nil
+		}
+
+		request, _ := http.NewRequest(http.MethodPost, "/tests/"+string(testID)+"/expire", nil)
+		request.Header.Set("X-Request-Id", "req-42")
+		server.ServeHTTP(httptest.NewRecorder(), request)
+
+		if got != "req-42" {
+			t.Errorf("want request id req-42, got %q", got)
+		}
+	})
+
+	t.Run("reject non admins", func(t *testing.T) {
+		server := MustCreateServer(t)
+
+		server.AuditService.FindAuditEventsFn = func(ctx context.Context, filter rona.AuditEventFilter) ([]*rona.AuditEvent, int, error) {
+			return nil, 0, rona.RequireRole(ctx, rona.RoleAdmin)
+		}
+
+		request, _ := http.NewRequest(http.MethodGet, "/admin/audit", nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		if response.Code != http.StatusUnauthorized {
+			t.Errorf("want %v, got %v", http.StatusUnauthorized, response.Code)
+		}
+	})
+}
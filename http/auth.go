@@ -0,0 +1,244 @@
+package http
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/richardmarbach/rona"
+)
+
+// Session constants
+const (
+	sessionCookieName = "session"
+	sessionDuration   = 12 * time.Hour
+)
+
+// NewSessionKey generates a random key for signing session cookies.
+func NewSessionKey() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// registerAuthRoutes mounts the sign in routes on r.
+func (s *Server) registerAuthRoutes(r chi.Router) {
+	r.Get("/login", s.showLoginForm)
+	r.Post("/login", s.login)
+	r.Post("/logout", s.logout)
+	r.Post("/users/me/api-key", s.generateAPIKey)
+}
+
+// authenticate adds the user identified by the bearer API key or the
+// session cookie to the request context. Requests without credentials
+// continue anonymously; authorization is left to the services.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.UserService == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if v := r.Header.Get("Authorization"); v != "" {
+			key := strings.TrimPrefix(v, "Bearer ")
+			if key == v {
+				s.writeError(w, r, rona.Errorf(rona.EUNAUTHORIZED, "unsupported authorization scheme"))
+				return
+			}
+
+			user, err := s.UserService.AuthenticateAPIKey(r.Context(), key)
+			if err != nil {
+				s.writeError(w, r, err)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(rona.NewContextWithUser(r.Context(), user)))
+			return
+		}
+
+		if userID, ok := s.readSession(r); ok {
+			user, err := s.UserService.FindUserByID(r.Context(), userID)
+			if rona.ErrorCode(err) == rona.ENOTFOUND {
+				s.clearSession(w, r)
+			} else if err != nil {
+				s.writeError(w, r, err)
+				return
+			} else {
+				r = r.WithContext(rona.NewContextWithUser(r.Context(), user))
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// loginFormData is passed to the login template.
+type loginFormData struct {
+	Email string
+	Next  string
+	Error string
+}
+
+func (s *Server) showLoginForm(w http.ResponseWriter, r *http.Request) {
+	s.renderLoginForm(w, r, http.StatusOK, &loginFormData{Next: r.URL.Query().Get("next")})
+}
+
+func (s *Server) login(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		s.writeError(w, r, rona.Errorf(rona.EINVALID, "invalid form: %v", err))
+		return
+	}
+
+	data := &loginFormData{
+		Email: strings.TrimSpace(r.PostForm.Get("email")),
+		Next:  r.PostForm.Get("next"),
+	}
+
+	// Servers without a UserService can't sign anyone in.
+	if s.UserService == nil {
+		data.Error = "signing in is not available"
+		s.renderLoginForm(w, r, http.StatusUnauthorized, data)
+		return
+	}
+
+	user, err := s.UserService.Authenticate(r.Context(), data.Email, r.PostForm.Get("password"))
+	if rona.ErrorCode(err) == rona.EUNAUTHORIZED {
+		data.Error = rona.ErrorMessage(err)
+		s.renderLoginForm(w, r, http.StatusUnauthorized, data)
+		return
+	} else if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	s.setSession(w, r, user.ID)
+
+	// Only redirect to local paths to avoid open redirects.
+	next := data.Next
+	if !isLocalPath(next) {
+		next = "/tests/"
+	}
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// isLocalPath reports whether next is an absolute path on this server.
+// Browsers treat backslashes like slashes, so "/\evil.com" would leave
+// the site just like "//evil.com".
+func isLocalPath(next string) bool {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.Contains(next, `\`) {
+		return false
+	}
+
+	u, err := url.Parse(next)
+	return err == nil && u.Scheme == "" && u.Host == ""
+}
+
+func (s *Server) logout(w http.ResponseWriter, r *http.Request) {
+	s.clearSession(w, r)
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+}
+
+// apiKeyResponse is the JSON body returned for a newly generated API key.
+type apiKeyResponse struct {
+	APIKey string `json:"api_key"`
+}
+
+// generateAPIKey creates a new API key for the signed in user.
+func (s *Server) generateAPIKey(w http.ResponseWriter, r *http.Request) {
+	user := rona.UserFromContext(r.Context())
+	if user == nil {
+		s.writeError(w, r, rona.Errorf(rona.EUNAUTHORIZED, "you must be signed in"))
+		return
+	}
+
+	key, err := s.UserService.GenerateAPIKey(r.Context(), user.ID)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	encodeJSON(w, http.StatusCreated, &apiKeyResponse{APIKey: key})
+}
+
+func (s *Server) renderLoginForm(w http.ResponseWriter, r *http.Request, status int, data *loginFormData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+
+	if err := s.TC.Render(w, "login", data); err != nil {
+		logError(r, err)
+	}
+}
+
+// setSession stores the signed in user in a signed session cookie.
+func (s *Server) setSession(w http.ResponseWriter, r *http.Request, userID int) {
+	expires := s.Clock.Now().Add(sessionDuration)
+	payload := fmt.Sprintf("%d:%d", userID, expires.Unix())
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." + s.signSession(payload),
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   s.TLS() || r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// readSession returns the user ID from a valid, unexpired session cookie.
+func (s *Server) readSession(r *http.Request) (int, bool) {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return 0, false
+	}
+
+	i := strings.IndexByte(cookie.Value, '.')
+	if i == -1 {
+		return 0, false
+	}
+	buf, err := base64.RawURLEncoding.DecodeString(cookie.Value[:i])
+	if err != nil {
+		return 0, false
+	}
+	payload := string(buf)
+
+	if !hmac.Equal([]byte(s.signSession(payload)), []byte(cookie.Value[i+1:])) {
+		return 0, false
+	}
+
+	var userID int
+	var expires int64
+	if _, err := fmt.Sscanf(payload, "%d:%d", &userID, &expires); err != nil {
+		return 0, false
+	} else if s.Clock.Now().Unix() > expires {
+		return 0, false
+	}
+	return userID, true
+}
+
+// clearSession removes the session cookie.
+func (s *Server) clearSession(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   s.TLS() || r.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// signSession returns the HMAC of the session payload.
+func (s *Server) signSession(payload string) string {
+	mac := hmac.New(sha256.New, s.SessionKey)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,248 @@
+package http_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/richardmarbach/rona"
+	"github.com/richardmarbach/rona/ronatest"
+)
+
+func TestAuthenticate(t *testing.T) {
+	t.Run("authenticate with a bearer api key", func(t *testing.T) {
+		server := MustCreateServer(t)
+		user := &rona.User{ID: 1, Role: rona.RoleManufacturer}
+
+		server.UserService.AuthenticateAPIKeyFn = func(ctx context.Context, key string) (*rona.User, error) {
+			if key != "rona_secret" {
+				t.Errorf("unexpected key %v", key)
+			}
+			return user, nil
+		}
+		var got *rona.User
+		server.QuickTestService.CreateQuickTestFn = func(ctx context.Context, id rona.QuickTestID) (*rona.QuickTest, error) {
+			got = rona.UserFromContext(ctx)
+			return &rona.QuickTest{ID: id}, nil
+		}
+
+		request, _ := http.NewRequest(http.MethodPost, "/tests", strings.NewReader(`{}`))
+		request.Header.Set("Authorization", "Bearer rona_secret")
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		if response.Code != http.StatusCreated {
+			t.Errorf("want %v, got %v", http.StatusCreated, response.Code)
+		}
+		if got != user {
+			t.Errorf("expected the user in the context, got %#v", got)
+		}
+	})
+
+	t.Run("reject an invalid api key", func(t *testing.T) {
+		server := MustCreateServer(t)
+
+		server.UserService.AuthenticateAPIKeyFn = func(ctx context.Context, key string) (*rona.User, error) {
+			return nil, rona.Errorf(rona.EUNAUTHORIZED, "invalid api key")
+		}
+
+		request, _ := http.NewRequest(http.MethodPost, "/tests", strings.NewReader(`{}`))
+		request.Header.Set("Authorization", "Bearer rona_wrong")
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		if response.Code != http.StatusUnauthorized {
+			t.Errorf("want %v, got %v", http.StatusUnauthorized, response.Code)
+		}
+	})
+
+	t.Run("pass unauthorized service errors to the client", func(t *testing.T) {
+		server := MustCreateServer(t)
+
+		server.QuickTestService.ExpireQuickTestFn = func(ctx context.Context, id rona.QuickTestID) error {
+			return rona.RequireRole(ctx, rona.RoleStaff)
+		}
+
+		request, _ := http.NewRequest(http.MethodPost, "/tests/"+string(testID)+"/expire", nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		if response.Code != http.StatusUnauthorized {
+			t.Errorf("want %v, got %v", http.StatusUnauthorized, response.Code)
+		}
+	})
+}
+
+func TestLogin(t *testing.T) {
+	t.Run("sign in and use the session", func(t *testing.T) {
+		server := MustCreateServer(t)
+		user := &rona.User{ID: 7, Role: rona.RoleStaff}
+
+		server.UserService.AuthenticateFn = func(ctx context.Context, email, password string) (*rona.User, error) {
+			if email != "jane@example.com" || password != "password123" {
+				return nil, rona.Errorf(rona.EUNAUTHORIZED, "invalid email or password")
+			}
+			return user, nil
+		}
+		server.UserService.FindUserByIDFn = func(ctx context.Context, id int) (*rona.User, error) {
+			if id != user.ID {
+				t.Errorf("want user %d, got %d", user.ID, id)
+			}
+			return user, nil
+		}
+
+		response := postForm(server, "/login", url.Values{
+			"email":    {"jane@example.com"},
+			"password": {"password123"},
+			"next":     {"/tests/" + string(testID) + "/result"},
+		})
+
+		if response.Code != http.StatusSeeOther {
+			t.Fatalf("want %v, got %v", http.StatusSeeOther, response.Code)
+		}
+		if location := response.Header().Get("Location"); location != "/tests/"+string(testID)+"/result" {
+			t.Errorf("unexpected redirect: %v", location)
+		}
+
+		var got *rona.User
+		server.QuickTestService.ExpireQuickTestFn = func(ctx context.Context, id rona.QuickTestID) error {
+			got = rona.UserFromContext(ctx)
+			return nil
+		}
+
+		request, _ := http.NewRequest(http.MethodPost, "/tests/"+string(testID)+"/expire", nil)
+		for _, cookie := range response.Result().Cookies() {
+			request.AddCookie(cookie)
+		}
+		server.ServeHTTP(httptest.NewRecorder(), request)
+
+		if got != user {
+			t.Errorf("expected the user in the context, got %#v", got)
+		}
+	})
+
+	t.Run("ignore tampered sessions", func(t *testing.T) {
+		server := MustCreateServer(t)
+
+		server.UserService.AuthenticateFn = func(ctx context.Context, email, password string) (*rona.User, error) {
+			return &rona.User{ID: 7, Role: rona.RoleStaff}, nil
+		}
+		server.UserService.FindUserByIDFn = func(ctx context.Context, id int) (*rona.User, error) {
+			t.Errorf("expected tampered session to be ignored, got user %d", id)
+			return nil, rona.Errorf(rona.ENOTFOUND, "user not found")
+		}
+
+		response := postForm(server, "/login", url.Values{"email": {"jane@example.com"}, "password": {"password123"}})
+
+		var got *rona.User
+		server.QuickTestService.ExpireQuickTestFn = func(ctx context.Context, id rona.QuickTestID) error {
+			got = rona.UserFromContext(ctx)
+			return nil
+		}
+
+		request, _ := http.NewRequest(http.MethodPost, "/tests/"+string(testID)+"/expire", nil)
+		for _, cookie := range response.Result().Cookies() {
+			cookie.Value = "OToxOTk5OTk5OTk5." + strings.SplitN(cookie.Value, ".", 2)[1]
+			request.AddCookie(cookie)
+		}
+		server.ServeHTTP(httptest.NewRecorder(), request)
+
+		if got != nil {
+			t.Errorf("expected no user in the context, got %#v", got)
+		}
+	})
+
+	t.Run("ignore expired sessions", func(t *testing.T) {
+		server := MustCreateServer(t)
+		clock := ronatest.NewClock()
+		server.Clock = clock
+
+		server.UserService.AuthenticateFn = func(ctx context.Context, email, password string) (*rona.User, error) {
+			return &rona.User{ID: 7, Role: rona.RoleStaff}, nil
+		}
+		server.UserService.FindUserByIDFn = func(ctx context.Context, id int) (*rona.User, error) {
+			t.Errorf("expected expired session to be ignored, got user %d", id)
+			return nil, rona.Errorf(rona.ENOTFOUND, "user not found")
+		}
+
+		response := postForm(server, "/login", url.Values{"email": {"jane@example.com"}, "password": {"password123"}})
+		clock.Add(13 * time.Hour)
+
+		var got *rona.User
+		server.QuickTestService.ExpireQuickTestFn = func(ctx context.Context, id rona.QuickTestID) error {
+			got = rona.UserFromContext(ctx)
+			return nil
+		}
+
+		request, _ := http.NewRequest(http.MethodPost, "/tests/"+string(testID)+"/expire", nil)
+		for _, cookie := range response.Result().Cookies() {
+			request.AddCookie(cookie)
+		}
+		server.ServeHTTP(httptest.NewRecorder(), request)
+
+		if got != nil {
+			t.Errorf("expected no user in the context, got %#v", got)
+		}
+	})
+
+	t.Run("rerender the form for wrong credentials", func(t *testing.T) {
+		server := MustCreateServer(t)
+
+		server.UserService.AuthenticateFn = func(ctx context.Context, email, password string) (*rona.User, error) {
+			return nil, rona.Errorf(rona.EUNAUTHORIZED, "invalid email or password")
+		}
+
+		response := postForm(server, "/login", url.Values{"email": {"jane@example.com"}, "password": {"wrong"}})
+
+		if response.Code != http.StatusUnauthorized {
+			t.Errorf("want %v, got %v", http.StatusUnauthorized, response.Code)
+		}
+		if !strings.Contains(response.Body.String(), "invalid email or password") {
+			t.Errorf("expected error message in body: %s", response.Body.String())
+		}
+	})
+
+	t.Run("refuse to sign in without a user service", func(t *testing.T) {
+		server := MustCreateServer(t)
+		server.Server.UserService = nil
+
+		response := postForm(server, "/login", url.Values{"email": {"jane@example.com"}, "password": {"password123"}})
+
+		if response.Code != http.StatusUnauthorized {
+			t.Errorf("want %v, got %v", http.StatusUnauthorized, response.Code)
+		}
+		if !strings.Contains(response.Body.String(), "signing in is not available") {
+			t.Errorf("expected error message in body: %s", response.Body.String())
+		}
+	})
+
+	t.Run("never redirect to other hosts", func(t *testing.T) {
+		server := MustCreateServer(t)
+
+		server.UserService.AuthenticateFn = func(ctx context.Context, email, password string) (*rona.User, error) {
+			return &rona.User{ID: 7, Role: rona.RoleStaff}, nil
+		}
+
+		for _, next := range []string{
+			"//evil.example.com",
+			`/\evil.example.com`,
+			`/\/evil.example.com`,
+			"https://evil.example.com",
+			"/\tests",
+			"/%zz",
+		} {
+			response := postForm(server, "/login", url.Values{"next": {next}})
+
+			if location := response.Header().Get("Location"); location != "/tests/" {
+				t.Errorf("want redirect to /tests/ for %q, got %v", next, location)
+			}
+		}
+	})
+}
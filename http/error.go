@@ -0,0 +1,81 @@
+package http
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/go-chi/chi/middleware"
+	"github.com/richardmarbach/rona"
+)
+
+// statusClientClosedRequest is the non-standard status for requests the
+// client gave up on before the response was written.
+const statusClientClosedRequest = 499
+
+// errorStatusCodes maps application error codes to HTTP status codes.
+var errorStatusCodes = map[string]int{
+	rona.EINVALID:      http.StatusBadRequest,
+	rona.EUNAUTHORIZED: http.StatusUnauthorized,
+	rona.ENOTFOUND:     http.StatusNotFound,
+	rona.ECONFLICT:     http.StatusConflict,
+	rona.EEXPIRED:      http.StatusGone,
+	rona.EUNAVAILABLE:  http.StatusServiceUnavailable,
+	rona.ECANCELED:     statusClientClosedRequest,
+	rona.EINTERNAL:     http.StatusInternalServerError,
+}
+
+// ErrorStatusCode returns the HTTP status code for an application error code.
+func ErrorStatusCode(code string) int {
+	if status, ok := errorStatusCodes[code]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// ErrorResponse is the JSON body returned for failed requests.
+type ErrorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// errorPageData is passed to the error template.
+type errorPageData struct {
+	Status    int
+	Title     string
+	Message   string
+	RequestID string
+}
+
+// writeError writes err to the client as JSON or as an HTML page depending
+// on what the client accepts. Internal errors are logged and their details
+// are never sent to the client.
+func (s *Server) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	code, message := rona.ErrorCode(err), rona.ErrorMessage(err)
+	if code == rona.EINTERNAL {
+		logError(r, err)
+		message = "Internal error."
+	}
+	status := ErrorStatusCode(code)
+
+	if acceptsJSON(r) {
+		encodeJSON(w, status, &ErrorResponse{Code: code, Message: message})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+
+	if err := s.TC.Render(w, "error", &errorPageData{
+		Status:    status,
+		Title:     http.StatusText(status),
+		Message:   message,
+		RequestID: middleware.GetReqID(r.Context()),
+	}); err != nil {
+		logError(r, err)
+	}
+}
+
+// logError logs the error together with the request it occurred in.
+func logError(r *http.Request, err error) {
+	log.Printf("http: error: %s %s request_id=%q: %v", r.Method, r.URL.Path, middleware.GetReqID(r.Context()), err)
+}
@@ -0,0 +1,115 @@
+package http_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/richardmarbach/rona"
+	ronahttp "github.com/richardmarbach/rona/http"
+)
+
+func TestErrorStatusCode(t *testing.T) {
+	cases := []struct {
+		code   string
+		status int
+	}{
+		{rona.EINVALID, http.StatusBadRequest},
+		{rona.ENOTFOUND, http.StatusNotFound},
+		{rona.ECONFLICT, http.StatusConflict},
+		{rona.EEXPIRED, http.StatusGone},
+		{rona.EUNAUTHORIZED, http.StatusUnauthorized},
+		{rona.EUNAVAILABLE, http.StatusServiceUnavailable},
+		{rona.ECANCELED, 499},
+		{rona.EINTERNAL, http.StatusInternalServerError},
+		{"unknown", http.StatusInternalServerError},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.code, func(t *testing.T) {
+			if got := ronahttp.ErrorStatusCode(tc.code); got != tc.status {
+				t.Errorf("want %v, got %v", tc.status, got)
+			}
+		})
+	}
+}
+
+func TestError(t *testing.T) {
+	t.Run("render a json error body", func(t *testing.T) {
+		server := MustCreateServer(t)
+
+		server.QuickTestService.FindQuickTestByIDFn = func(ctx context.Context, id rona.QuickTestID) (*rona.QuickTest, error) {
+			return nil, rona.Errorf(rona.EINVALID, "invalid id")
+		}
+
+		request, _ := http.NewRequest(http.MethodGet, "/tests/"+string(testID), nil)
+		request.Header.Set("Accept", "application/json")
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		if response.Code != http.StatusBadRequest {
+			t.Errorf("want %v, got %v", http.StatusBadRequest, response.Code)
+		}
+
+		var body ronahttp.ErrorResponse
+		MustDecodeJSON(t, response, &body)
+		if body.Code != rona.EINVALID || body.Message != "invalid id" {
+			t.Errorf("unexpected error body: %#v", body)
+		}
+	})
+
+	t.Run("render an html error page", func(t *testing.T) {
+		server := MustCreateServer(t)
+
+		server.QuickTestService.FindQuickTestByIDFn = func(ctx context.Context, id rona.QuickTestID) (*rona.QuickTest, error) {
+			return nil, rona.Errorf(rona.EEXPIRED, "test has already expired")
+		}
+
+		request, _ := http.NewRequest(http.MethodGet, "/tests/"+string(testID), nil)
+		request.Header.Set("Accept", "text/html")
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		if response.Code != http.StatusGone {
+			t.Errorf("want %v, got %v", http.StatusGone, response.Code)
+		}
+		if ct := response.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+			t.Errorf("want html content type, got %v", ct)
+		}
+		if !strings.Contains(response.Body.String(), "test has already expired") {
+			t.Errorf("expected message in body: %s", response.Body.String())
+		}
+	})
+
+	t.Run("hide internal error details", func(t *testing.T) {
+		server := MustCreateServer(t)
+
+		server.QuickTestService.FindQuickTestByIDFn = func(ctx context.Context, id rona.QuickTestID) (*rona.QuickTest, error) {
+			return nil, errors.New("database is on fire")
+		}
+
+		request, _ := http.NewRequest(http.MethodGet, "/tests/"+string(testID), nil)
+		request.Header.Set("Accept", "application/json")
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		if response.Code != http.StatusInternalServerError {
+			t.Errorf("want %v, got %v", http.StatusInternalServerError, response.Code)
+		}
+
+		var body ronahttp.ErrorResponse
+		MustDecodeJSON(t, response, &body)
+		if body.Code != rona.EINTERNAL {
+			t.Errorf("want code %v, got %v", rona.EINTERNAL, body.Code)
+		}
+		if strings.Contains(body.Message, "fire") {
+			t.Errorf("internal error details leaked: %v", body.Message)
+		}
+	})
+}
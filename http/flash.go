@@ -0,0 +1,46 @@
+package http
+
+import (
+	"encoding/base64"
+	"net/http"
+
+	"github.com/richardmarbach/rona"
+)
+
+// flashCookieName is the cookie carrying the flash message to the next
+// request.
+const flashCookieName = "flash"
+
+// setFlash stores a message to be shown on the next page the client loads.
+func setFlash(w http.ResponseWriter, message string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     flashCookieName,
+		Value:    base64.URLEncoding.EncodeToString([]byte(message)),
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// loadFlash moves the flash message from the cookie into the request
+// context and clears the cookie so the message is only shown once.
+func loadFlash(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie(flashCookieName)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		http.SetCookie(w, &http.Cookie{
+			Name:   flashCookieName,
+			Path:   "/",
+			MaxAge: -1,
+		})
+
+		if message, err := base64.URLEncoding.DecodeString(cookie.Value); err == nil {
+			r = r.WithContext(rona.NewContextWithFlash(r.Context(), string(message)))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
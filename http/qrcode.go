@@ -0,0 +1,207 @@
+package http
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/richardmarbach/rona"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// QR code sizes in pixels
+const (
+	defaultQRCodeSize = 256
+	minQRCodeSize     = 64
+	maxQRCodeSize     = 1024
+)
+
+// maxLabels limits how many labels are rendered on a single sheet.
+const maxLabels = 1000
+
+// registerQRCodeRoutes mounts the QR code routes on the quick test router.
+func (s *Server) registerQRCodeRoutes(r chi.Router) {
+	r.Post("/labels", s.showLabels)
+	r.Get("/{testID}/qr.png", s.qrCodePNG)
+	r.Get("/{testID}/qr.svg", s.qrCodeSVG)
+}
+
+// requireBaseURL reports an error when no base URL is configured. The Host
+// header is chosen by the client, so it can't be trusted to build the URLs
+// printed on the labels.
+func (s *Server) requireBaseURL() error {
+	if s.BaseURL == "" {
+		return rona.Errorf(rona.ENOTFOUND, "qr codes are not available, http.base_url is not configured")
+	}
+	return nil
+}
+
+// registrationURL returns the URL encoded in the QR code of a test.
+func (s *Server) registrationURL(id rona.QuickTestID) string {
+	return strings.TrimSuffix(s.BaseURL, "/") + "/tests/" + string(id) + "/register"
+}
+
+// qrCodeFor encodes the registration URL of the test in the request URL.
+func (s *Server) qrCodeFor(r *http.Request) (*qrcode.QRCode, error) {
+	if err := s.requireBaseURL(); err != nil {
+		return nil, err
+	}
+
+	id := rona.QuickTestID(chi.URLParam(r, "testID"))
+	if err := id.Validate(); err != nil {
+		return nil, err
+	}
+	return qrcode.New(s.registrationURL(id), qrcode.Medium)
+}
+
+func (s *Server) qrCodePNG(w http.ResponseWriter, r *http.Request) {
+	size := defaultQRCodeSize
+	if v := r.URL.Query().Get("size"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < minQRCodeSize || n > maxQRCodeSize {
+			s.writeError(w, r, rona.Errorf(rona.EINVALID, "size must be between %d and %d", minQRCodeSize, maxQRCodeSize))
+			return
+		}
+		size = n
+	}
+
+	code, err := s.qrCodeFor(r)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	buf, err := code.PNG(size)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(buf)
+}
+
+func (s *Server) qrCodeSVG(w http.ResponseWriter, r *http.Request) {
+	code, err := s.qrCodeFor(r)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Write(qrCodeSVG(code))
+}
+
+// qrCodeSVG renders the QR code as a scalable SVG image, one path segment
+// per run of dark modules.
+func qrCodeSVG(code *qrcode.QRCode) []byte {
+	bitmap := code.Bitmap()
+
+	var path strings.Builder
+	for y, row := range bitmap {
+		for x := 0; x < len(row); x++ {
+			if !row[x] {
+				continue
+			}
+			start := x
+			for x < len(row) && row[x] {
+				x++
+			}
+			fmt.Fprintf(&path, "M%d %dh%dv1h-%dz", start, y, x-start, x-start)
+		}
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, len(bitmap), len(bitmap))
+	fmt.Fprintf(&buf, `<rect width="100%%" height="100%%" fill="#fff"/><path fill="#000" d="%s"/></svg>`, path.String())
+	return buf.Bytes()
+}
+
+// label is a single sticker on the label sheet.
+type label struct {
+	ID     rona.QuickTestID
+	Code   rona.QuickTestCode
+	URL    string
+	QRCode template.HTML
+}
+
+// labelsPageData is passed to the labels template.
+type labelsPageData struct {
+	Labels []*label
+}
+
+// showLabels renders a printable sheet of QR code labels. The request is
+// either the JSON output of the batch endpoint or a form with one test ID
+// per line. Only the IDs are taken from the request, the codes printed on
+// the labels are looked up.
+func (s *Server) showLabels(w http.ResponseWriter, r *http.Request) {
+	if err := s.requireBaseURL(); err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	var ids []rona.QuickTestID
+	if sentJSON(r) {
+		var req quickTestsResponse
+		if err := decodeJSON(w, r, &req); err != nil {
+			s.writeError(w, r, err)
+			return
+		}
+		for _, qt := range req.QuickTests {
+			ids = append(ids, qt.ID)
+		}
+	} else {
+		if err := r.ParseForm(); err != nil {
+			s.writeError(w, r, rona.Errorf(rona.EINVALID, "invalid form: %v", err))
+			return
+		}
+		for _, id := range strings.Fields(r.PostForm.Get("ids")) {
+			ids = append(ids, rona.QuickTestID(id))
+		}
+	}
+
+	if len(ids) == 0 {
+		s.writeError(w, r, rona.Errorf(rona.EINVALID, "at least one test id is required"))
+		return
+	} else if len(ids) > maxLabels {
+		s.writeError(w, r, rona.Errorf(rona.EINVALID, "at most %d labels can be printed at once", maxLabels))
+		return
+	}
+
+	labels := make([]*label, 0, len(ids))
+	for _, id := range ids {
+		if err := id.Validate(); err != nil {
+			s.writeError(w, r, err)
+			return
+		}
+
+		qt, err := s.QuickTestService.FindQuickTestByID(r.Context(), id)
+		if err != nil {
+			s.writeError(w, r, err)
+			return
+		}
+
+		url := s.registrationURL(qt.ID)
+		code, err := qrcode.New(url, qrcode.Medium)
+		if err != nil {
+			s.writeError(w, r, err)
+			return
+		}
+
+		labels = append(labels, &label{
+			ID:     qt.ID,
+			Code:   qt.Code,
+			URL:    url,
+			QRCode: template.HTML(qrCodeSVG(code)),
+		})
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := s.TC.Render(w, "labels", &labelsPageData{Labels: labels}); err != nil {
+		logError(r, err)
+	}
+}
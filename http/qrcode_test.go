@@ -0,0 +1,197 @@
+package http_test
+
+import (
+	"context"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/richardmarbach/rona"
+)
+
+func TestGETQRCode(t *testing.T) {
+	t.Run("render a png", func(t *testing.T) {
+		server := MustCreateServer(t)
+		server.BaseURL = "https://rona.example.com"
+		id := rona.NewQuickTestID()
+
+		request, _ := http.NewRequest(http.MethodGet, "/tests/"+string(id)+"/qr.png?size=128", nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		if response.Code != http.StatusOK {
+			t.Fatalf("want %v, got %v", http.StatusOK, response.Code)
+		}
+		if ct := response.Header().Get("Content-Type"); ct != "image/png" {
+			t.Errorf("want image/png, got %v", ct)
+		}
+
+		img, err := png.Decode(response.Body)
+		if err != nil {
+			t.Fatalf("failed to decode png: %v", err)
+		}
+		if img.Bounds().Dx() != 128 {
+			t.Errorf("want width 128, got %d", img.Bounds().Dx())
+		}
+	})
+
+	t.Run("render an svg", func(t *testing.T) {
+		server := MustCreateServer(t)
+		server.BaseURL = "https://rona.example.com"
+		id := rona.NewQuickTestID()
+
+		request, _ := http.NewRequest(http.MethodGet, "/tests/"+string(id)+"/qr.svg", nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		if response.Code != http.StatusOK {
+			t.Fatalf("want %v, got %v", http.StatusOK, response.Code)
+		}
+		if ct := response.Header().Get("Content-Type"); ct != "image/svg+xml" {
+			t.Errorf("want image/svg+xml, got %v", ct)
+		}
+		if !strings.HasPrefix(response.Body.String(), "<svg") {
+			t.Errorf("expected an svg document: %s", response.Body.String())
+		}
+	})
+
+	t.Run("reject invalid ids", func(t *testing.T) {
+		server := MustCreateServer(t)
+		server.BaseURL = "https://rona.example.com"
+
+		request, _ := http.NewRequest(http.MethodGet, "/tests/abc/qr.png", nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		if response.Code != http.StatusBadRequest {
+			t.Errorf("want %v, got %v", http.StatusBadRequest, response.Code)
+		}
+	})
+
+	t.Run("reject out of range sizes", func(t *testing.T) {
+		server := MustCreateServer(t)
+		server.BaseURL = "https://rona.example.com"
+		id := rona.NewQuickTestID()
+
+		request, _ := http.NewRequest(http.MethodGet, "/tests/"+string(id)+"/qr.png?size=100000", nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		if response.Code != http.StatusBadRequest {
+			t.Errorf("want %v, got %v", http.StatusBadRequest, response.Code)
+		}
+	})
+
+	t.Run("refuse without a base url", func(t *testing.T) {
+		server := MustCreateServer(t)
+		id := rona.NewQuickTestID()
+
+		request, _ := http.NewRequest(http.MethodGet, "/tests/"+string(id)+"/qr.svg", nil)
+		request.Host = "attacker.example.com"
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		if response.Code != http.StatusNotFound {
+			t.Errorf("want %v, got %v", http.StatusNotFound, response.Code)
+		}
+	})
+}
+
+func TestPOSTLabels(t *testing.T) {
+	t.Run("render a label per test from the batch output", func(t *testing.T) {
+		server := MustCreateServer(t)
+		server.BaseURL = "https://rona.example.com"
+		server.QuickTestService.FindQuickTestByIDFn = findStoredCode
+		a, b := rona.NewQuickTestID(), rona.NewQuickTestID()
+
+		body := `{"tests":[{"id":"` + string(a) + `","code":"FORGED"},{"id":"` + string(b) + `"}]}`
+		request, _ := http.NewRequest(http.MethodPost, "/tests/labels", strings.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set("Accept", "text/html")
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		if response.Code != http.StatusOK {
+			t.Fatalf("want %v, got %v: %s", http.StatusOK, response.Code, response.Body.String())
+		}
+		if n := strings.Count(response.Body.String(), "<svg"); n != 2 {
+			t.Errorf("want 2 labels, got %d", n)
+		}
+		if !strings.Contains(response.Body.String(), string(b)) {
+			t.Errorf("expected label for %v", b)
+		}
+		if strings.Contains(response.Body.String(), "FORGED") {
+			t.Errorf("expected the stored code instead of the sent one")
+		}
+		if n := strings.Count(response.Body.String(), "<strong>"+storedCode.String()+"</strong>"); n != 2 {
+			t.Errorf("want 2 stored codes, got %d", n)
+		}
+	})
+
+	t.Run("render labels from a form", func(t *testing.T) {
+		server := MustCreateServer(t)
+		server.BaseURL = "https://rona.example.com"
+		server.QuickTestService.FindQuickTestByIDFn = findStoredCode
+		a, b := rona.NewQuickTestID(), rona.NewQuickTestID()
+
+		response := postForm(server, "/tests/labels", url.Values{"ids": {string(a) + "\n" + string(b)}})
+
+		if n := strings.Count(response.Body.String(), "<svg"); n != 2 {
+			t.Errorf("want 2 labels, got %d", n)
+		}
+		if n := strings.Count(response.Body.String(), "<strong>"+storedCode.String()+"</strong>"); n != 2 {
+			t.Errorf("want 2 stored codes, got %d", n)
+		}
+	})
+
+	t.Run("reject unknown tests", func(t *testing.T) {
+		server := MustCreateServer(t)
+		server.BaseURL = "https://rona.example.com"
+		server.QuickTestService.FindQuickTestByIDFn = func(ctx context.Context, id rona.QuickTestID) (*rona.QuickTest, error) {
+			return nil, rona.Errorf(rona.ENOTFOUND, "quick test not found")
+		}
+
+		response := postForm(server, "/tests/labels", url.Values{"ids": {string(rona.NewQuickTestID())}})
+
+		if response.Code != http.StatusNotFound {
+			t.Errorf("want %v, got %v", http.StatusNotFound, response.Code)
+		}
+	})
+
+	t.Run("refuse without a base url", func(t *testing.T) {
+		server := MustCreateServer(t)
+
+		response := postForm(server, "/tests/labels", url.Values{"ids": {string(rona.NewQuickTestID())}})
+
+		if response.Code != http.StatusNotFound {
+			t.Errorf("want %v, got %v", http.StatusNotFound, response.Code)
+		}
+	})
+
+	t.Run("reject an empty sheet", func(t *testing.T) {
+		server := MustCreateServer(t)
+		server.BaseURL = "https://rona.example.com"
+
+		response := postForm(server, "/tests/labels", url.Values{})
+
+		if response.Code != http.StatusBadRequest {
+			t.Errorf("want %v, got %v", http.StatusBadRequest, response.Code)
+		}
+	})
+}
+
+// storedCode is the code of every test found by findStoredCode.
+var storedCode = rona.NewQuickTestCode()
+
+func findStoredCode(ctx context.Context, id rona.QuickTestID) (*rona.QuickTest, error) {
+	return &rona.QuickTest{ID: id, Code: storedCode}, nil
+}
@@ -0,0 +1,350 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+	"github.com/richardmarbach/rona"
+)
+
+// registerQuickTestRoutes mounts the quick test routes on r.
+func (s *Server) registerQuickTestRoutes(r chi.Router) {
+	r.Get("/", s.showTestForm)
+	r.Post("/", s.createTest)
+	r.Post("/batch", s.createManyTests)
+	r.Post("/register", s.submitTestForm)
+	r.Get("/{testID}", s.getTest)
+	r.Get("/{testID}/register", s.showRegisterForm)
+	r.Post("/{testID}/register", s.registerTest)
+	r.Post("/{testID}/expire", s.expireTest)
+	r.Get("/{testID}/result", s.showResultForm)
+	r.Post("/{testID}/result", s.recordResult)
+
+	s.registerQRCodeRoutes(r)
+}
+
+// createTestRequest is the JSON body for creating a single test. A new ID
+// is generated when ID is empty.
+type createTestRequest struct {
+	ID rona.QuickTestID `json:"id"`
+}
+
+// maxBatchSize is the largest number of tests created in one batch.
+const maxBatchSize = 10000
+
+// createManyTestsRequest is the JSON body for creating a batch of tests.
+type createManyTestsRequest struct {
+	IDs []rona.QuickTestID `json:"ids"`
+}
+
+// quickTestsResponse is the JSON body returned for a batch of tests.
+type quickTestsResponse struct {
+	QuickTests []*rona.QuickTest `json:"tests"`
+}
+
+// registerTestRequest is the JSON body for registering a test.
+type registerTestRequest struct {
+	Person string `json:"person"`
+}
+
+// recordResultRequest is the JSON body for recording a test result.
+type recordResultRequest struct {
+	Result rona.QuickTestResult `json:"result"`
+}
+
+// registerFormData is passed to the get-test template.
+type registerFormData struct {
+	ID     rona.QuickTestID
+	Person string
+	Error  string
+}
+
+// testPageData is passed to the test template.
+type testPageData struct {
+	QuickTest *rona.QuickTest
+	Flash     string
+}
+
+// registerErrorMessages are shown to people whose registration failed.
+var registerErrorMessages = map[string]string{
+	rona.ENOTFOUND: "We couldn't find a test with this ID. Please check the ID printed on your test kit.",
+	rona.ECONFLICT: "This test has already been registered. Each test can only be registered once.",
+	rona.EEXPIRED:  "This test has expired and can no longer be registered.",
+}
+
+// showTestForm shows the registration form. The test ID is prefilled when
+// the id query parameter is set.
+func (s *Server) showTestForm(w http.ResponseWriter, r *http.Request) {
+	s.renderRegisterForm(w, r, http.StatusOK, &registerFormData{
+		ID: rona.QuickTestID(r.URL.Query().Get("id")),
+	})
+}
+
+// showRegisterForm shows the registration form for the test in the URL.
+func (s *Server) showRegisterForm(w http.ResponseWriter, r *http.Request) {
+	s.renderRegisterForm(w, r, http.StatusOK, &registerFormData{
+		ID: rona.QuickTestID(chi.URLParam(r, "testID")),
+	})
+}
+
+// submitTestForm registers the test entered in the registration form.
+func (s *Server) submitTestForm(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		s.writeError(w, r, rona.Errorf(rona.EINVALID, "invalid form: %v", err))
+		return
+	}
+
+	data := &registerFormData{
+		ID:     rona.QuickTestID(strings.TrimSpace(r.PostForm.Get("id"))),
+		Person: strings.TrimSpace(r.PostForm.Get("person")),
+	}
+
+	id, err := s.resolveQuickTestID(r.Context(), string(data.ID))
+	if err == nil {
+		_, err = s.QuickTestService.RegisterQuickTest(r.Context(), &rona.QuickTestRegister{
+			ID:     id,
+			Person: data.Person,
+		})
+	}
+
+	code := rona.ErrorCode(err)
+	switch code {
+	case "":
+		setFlash(w, "Your test has been registered.")
+		http.Redirect(w, r, "/tests/"+string(id), http.StatusSeeOther)
+		return
+	case rona.EINTERNAL:
+		s.writeError(w, r, err)
+		return
+	}
+
+	if message, ok := registerErrorMessages[code]; ok {
+		data.Error = message
+	} else {
+		data.Error = rona.ErrorMessage(err)
+	}
+	s.renderRegisterForm(w, r, ErrorStatusCode(code), data)
+}
+
+func (s *Server) renderRegisterForm(w http.ResponseWriter, r *http.Request, status int, data *registerFormData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+
+	if err := s.TC.Render(w, "get-test", data); err != nil {
+		logError(r, err)
+	}
+}
+
+func (s *Server) createTest(w http.ResponseWriter, r *http.Request) {
+	var req createTestRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	if req.ID == "" {
+		req.ID = rona.NewQuickTestID()
+	}
+
+	qt, err := s.QuickTestService.CreateQuickTest(r.Context(), req.ID)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	encodeJSON(w, http.StatusCreated, qt)
+}
+
+func (s *Server) createManyTests(w http.ResponseWriter, r *http.Request) {
+	var req createManyTestsRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	if len(req.IDs) > maxBatchSize {
+		s.writeError(w, r, rona.Errorf(rona.EINVALID, "at most %d tests can be created at once", maxBatchSize))
+		return
+	}
+
+	qts, err := s.QuickTestService.CreateManyQuickTests(r.Context(), req.IDs)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	encodeJSON(w, http.StatusCreated, &quickTestsResponse{QuickTests: qts})
+}
+
+// resolveQuickTestID returns the ID of the test referenced by ref, which is
+// either a test ID or a short code.
+func (s *Server) resolveQuickTestID(ctx context.Context, ref string) (rona.QuickTestID, error) {
+	if id := rona.QuickTestID(ref); id == "" || id.Validate() == nil {
+		return id, nil
+	}
+
+	code, err := rona.ParseQuickTestCode(ref)
+	if err != nil {
+		return "", err
+	}
+
+	qt, err := s.QuickTestService.FindQuickTestByCode(ctx, code)
+	if err != nil {
+		return "", err
+	}
+	return qt.ID, nil
+}
+
+// findQuickTest retrieves the test referenced by ref, which is either a
+// test ID or a short code.
+func (s *Server) findQuickTest(ctx context.Context, ref string) (*rona.QuickTest, error) {
+	if id := rona.QuickTestID(ref); id.Validate() == nil {
+		return s.QuickTestService.FindQuickTestByID(ctx, id)
+	}
+
+	code, err := rona.ParseQuickTestCode(ref)
+	if err != nil {
+		return nil, err
+	}
+	return s.QuickTestService.FindQuickTestByCode(ctx, code)
+}
+
+func (s *Server) getTest(w http.ResponseWriter, r *http.Request) {
+	testID := chi.URLParam(r, "testID")
+
+	qt, err := s.findQuickTest(r.Context(), testID)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	// Anybody holding the test ID or code can look the test up, but only
+	// test center staff may see who registered it.
+	if !rona.UserFromContext(r.Context()).HasRole(rona.RoleStaff) {
+		qt.Person = ""
+	}
+
+	if acceptsJSON(r) {
+		encodeJSON(w, http.StatusOK, qt)
+		return
+	}
+
+	if err := s.TC.Render(w, "test", &testPageData{
+		QuickTest: qt,
+		Flash:     rona.FlashFromContext(r.Context()),
+	}); err != nil {
+		logError(r, err)
+	}
+}
+
+func (s *Server) registerTest(w http.ResponseWriter, r *http.Request) {
+	testID := chi.URLParam(r, "testID")
+
+	var req registerTestRequest
+	if err := decodeJSON(w, r, &req); err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	qt, err := s.QuickTestService.RegisterQuickTest(r.Context(), &rona.QuickTestRegister{
+		ID:     rona.QuickTestID(testID),
+		Person: req.Person,
+	})
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	encodeJSON(w, http.StatusOK, qt)
+}
+
+func (s *Server) expireTest(w http.ResponseWriter, r *http.Request) {
+	testID := chi.URLParam(r, "testID")
+
+	if err := s.QuickTestService.ExpireQuickTest(r.Context(), rona.QuickTestID(testID)); err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// resultFormData is passed to the record-result template.
+type resultFormData struct {
+	QuickTest *rona.QuickTest
+	Results   []rona.QuickTestResult
+	Error     string
+}
+
+func (s *Server) showResultForm(w http.ResponseWriter, r *http.Request) {
+	testID := chi.URLParam(r, "testID")
+
+	qt, err := s.QuickTestService.FindQuickTestByID(r.Context(), rona.QuickTestID(testID))
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	s.renderResultForm(w, r, http.StatusOK, qt, "")
+}
+
+func (s *Server) recordResult(w http.ResponseWriter, r *http.Request) {
+	testID := chi.URLParam(r, "testID")
+
+	if sentJSON(r) {
+		var req recordResultRequest
+		if err := decodeJSON(w, r, &req); err != nil {
+			s.writeError(w, r, err)
+			return
+		}
+
+		qt, err := s.QuickTestService.RecordQuickTestResult(r.Context(), &rona.QuickTestRecordResult{
+			ID:     rona.QuickTestID(testID),
+			Result: req.Result,
+		})
+		if err != nil {
+			s.writeError(w, r, err)
+			return
+		}
+
+		encodeJSON(w, http.StatusOK, qt)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		s.writeError(w, r, rona.Errorf(rona.EINVALID, "invalid form: %v", err))
+		return
+	}
+
+	_, err := s.QuickTestService.RecordQuickTestResult(r.Context(), &rona.QuickTestRecordResult{
+		ID:     rona.QuickTestID(testID),
+		Result: rona.QuickTestResult(r.PostForm.Get("result")),
+	})
+
+	switch rona.ErrorCode(err) {
+	case "":
+		http.Redirect(w, r, "/tests/"+testID, http.StatusSeeOther)
+	case rona.ENOTFOUND, rona.EINTERNAL:
+		s.writeError(w, r, err)
+	default:
+		s.renderResultForm(w, r, http.StatusUnprocessableEntity, &rona.QuickTest{ID: rona.QuickTestID(testID)}, rona.ErrorMessage(err))
+	}
+}
+
+func (s *Server) renderResultForm(w http.ResponseWriter, r *http.Request, status int, qt *rona.QuickTest, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+
+	if err := s.TC.Render(w, "record-result", &resultFormData{
+		QuickTest: qt,
+		Results: []rona.QuickTestResult{
+			rona.QuickTestResultNegative,
+			rona.QuickTestResultPositive,
+			rona.QuickTestResultInvalid,
+		},
+		Error: message,
+	}); err != nil {
+		logError(r, err)
+	}
+}
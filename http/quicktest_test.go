@@ -0,0 +1,592 @@
+package http_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/richardmarbach/rona"
+)
+
+func TestGETQuickTest(t *testing.T) {
+	// t.Run("get test a's owner", func(t *testing.T) {
+	// 	server := MustCreateServer(t)
+
+	// 	server.QuickTestService.FindQuickTestByIDFn = func(ctx context.Context, id rona.QuickTestID) (*rona.QuickTest, error) {
+	// 		return &rona.QuickTest{ID: id, Person: "Steve"}, nil
+	// 	}
+
+	// 	request, _ := http.NewRequest(http.MethodGet, "/tests/a", nil)
+	// 	response := httptest.NewRecorder()
+
+	// 	server.ServeHTTP(response, request)
+
+	// 	got := response.Body.String()
+	// 	want := "Steve"
+
+	// 	if got != want {
+	// 		t.Errorf("want %v, got %v", got, want)
+	// 	}
+	// })
+
+	// t.Run("get test b's owner", func(t *testing.T) {
+	// 	server := MustCreateServer(t)
+
+	// 	server.QuickTestService.FindQuickTestByIDFn = func(ctx context.Context, id rona.QuickTestID) (*rona.QuickTest, error) {
+	// 		if id == "b" {
+	// 			return &rona.QuickTest{ID: id, Person: "Bob"}, nil
+	// 		}
+	// 		return nil, nil
+	// 	}
+
+	// 	request, _ := http.NewRequest(http.MethodGet, "/tests/b", nil)
+	// 	response := httptest.NewRecorder()
+
+	// 	server.ServeHTTP(response, request)
+
+	// 	got := response.Body.String()
+	// 	want := "Bob"
+
+	// 	if got != want {
+	// 		t.Errorf("want %v, got %v", got, want)
+	// 	}
+	// })
+
+	t.Run("return 404 when no quick test is present", func(t *testing.T) {
+		server := MustCreateServer(t)
+
+		server.QuickTestService.FindQuickTestByIDFn = func(ctx context.Context, id rona.QuickTestID) (*rona.QuickTest, error) {
+			return nil, rona.Errorf(rona.ENOTFOUND, "No id found")
+		}
+
+		request, _ := http.NewRequest(http.MethodGet, "/tests/"+string(testID), nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		got := response.Code
+		want := http.StatusNotFound
+
+		if got != want {
+			t.Errorf("want %v, got %v", got, want)
+		}
+	})
+}
+
+func TestPOSTQuickTestResult(t *testing.T) {
+	t.Run("record the result and redirect to the test", func(t *testing.T) {
+		server := MustCreateServer(t)
+
+		var got *rona.QuickTestRecordResult
+		server.QuickTestService.RecordQuickTestResultFn = func(ctx context.Context, rec *rona.QuickTestRecordResult) (*rona.QuickTest, error) {
+			got = rec
+			return &rona.QuickTest{ID: rec.ID, Result: rec.Result}, nil
+		}
+
+		form := url.Values{"result": {"negative"}}
+		request, _ := http.NewRequest(http.MethodPost, "/tests/a/result", strings.NewReader(form.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		if response.Code != http.StatusSeeOther {
+			t.Errorf("want %v, got %v", http.StatusSeeOther, response.Code)
+		}
+		if got == nil || got.ID != "a" || got.Result != rona.QuickTestResultNegative {
+			t.Errorf("unexpected record: %#v", got)
+		}
+	})
+
+	t.Run("rerender the form when the result is rejected", func(t *testing.T) {
+		server := MustCreateServer(t)
+
+		server.QuickTestService.RecordQuickTestResultFn = func(ctx context.Context, rec *rona.QuickTestRecordResult) (*rona.QuickTest, error) {
+			return nil, rona.Errorf(rona.EEXPIRED, "test has already expired")
+		}
+
+		form := url.Values{"result": {"negative"}}
+		request, _ := http.NewRequest(http.MethodPost, "/tests/a/result", strings.NewReader(form.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		if response.Code != http.StatusUnprocessableEntity {
+			t.Errorf("want %v, got %v", http.StatusUnprocessableEntity, response.Code)
+		}
+		if !strings.Contains(response.Body.String(), "test has already expired") {
+			t.Errorf("expected error message in body: %s", response.Body.String())
+		}
+	})
+}
+
+func TestGETQuickTestJSON(t *testing.T) {
+	t.Run("return the quick test as json", func(t *testing.T) {
+		server := MustCreateServer(t)
+
+		server.QuickTestService.FindQuickTestByIDFn = func(ctx context.Context, id rona.QuickTestID) (*rona.QuickTest, error) {
+			return &rona.QuickTest{ID: id, Result: rona.QuickTestResultPending}, nil
+		}
+
+		request, _ := http.NewRequest(http.MethodGet, "/tests/"+string(testID), nil)
+		request.Header.Set("Accept", "application/json")
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		if response.Code != http.StatusOK {
+			t.Fatalf("want %v, got %v", http.StatusOK, response.Code)
+		}
+
+		var qt rona.QuickTest
+		MustDecodeJSON(t, response, &qt)
+		if qt.ID != testID {
+			t.Errorf("want id %v, got %v", testID, qt.ID)
+		}
+	})
+
+	t.Run("hide the person from anonymous callers", func(t *testing.T) {
+		server := MustCreateServer(t)
+
+		server.QuickTestService.FindQuickTestByIDFn = func(ctx context.Context, id rona.QuickTestID) (*rona.QuickTest, error) {
+			return &rona.QuickTest{ID: id, Person: "Steve Tester", RegisteredAt: time.Now()}, nil
+		}
+
+		request, _ := http.NewRequest(http.MethodGet, "/tests/"+string(testID), nil)
+		request.Header.Set("Accept", "application/json")
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		if response.Code != http.StatusOK {
+			t.Fatalf("want %v, got %v", http.StatusOK, response.Code)
+		}
+		if strings.Contains(response.Body.String(), "Steve Tester") {
+			t.Errorf("expected the person to be hidden, got %s", response.Body)
+		}
+	})
+
+	t.Run("show the person to staff", func(t *testing.T) {
+		server := MustCreateServer(t)
+
+		server.UserService.AuthenticateAPIKeyFn = func(ctx context.Context, key string) (*rona.User, error) {
+			return &rona.User{ID: 1, Role: rona.RoleStaff}, nil
+		}
+		server.QuickTestService.FindQuickTestByIDFn = func(ctx context.Context, id rona.QuickTestID) (*rona.QuickTest, error) {
+			return &rona.QuickTest{ID: id, Person: "Steve Tester", RegisteredAt: time.Now()}, nil
+		}
+
+		request, _ := http.NewRequest(http.MethodGet, "/tests/"+string(testID), nil)
+		request.Header.Set("Accept", "application/json")
+		request.Header.Set("Authorization", "Bearer rona_secret")
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		var qt rona.QuickTest
+		MustDecodeJSON(t, response, &qt)
+		if qt.Person != "Steve Tester" {
+			t.Errorf("want person Steve Tester, got %q", qt.Person)
+		}
+	})
+
+	t.Run("return a json error when no quick test is present", func(t *testing.T) {
+		server := MustCreateServer(t)
+
+		server.QuickTestService.FindQuickTestByIDFn = func(ctx context.Context, id rona.QuickTestID) (*rona.QuickTest, error) {
+			return nil, rona.Errorf(rona.ENOTFOUND, "No id found")
+		}
+
+		request, _ := http.NewRequest(http.MethodGet, "/tests/"+string(testID), nil)
+		request.Header.Set("Accept", "application/json")
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		if response.Code != http.StatusNotFound {
+			t.Errorf("want %v, got %v", http.StatusNotFound, response.Code)
+		}
+
+		var body map[string]string
+		MustDecodeJSON(t, response, &body)
+		if body["code"] != rona.ENOTFOUND {
+			t.Errorf("want code %v, got %v", rona.ENOTFOUND, body["code"])
+		}
+	})
+}
+
+func TestGETQuickTestByCode(t *testing.T) {
+	t.Run("find the quick test by its short code", func(t *testing.T) {
+		server := MustCreateServer(t)
+		code := rona.NewQuickTestCode()
+
+		server.QuickTestService.FindQuickTestByCodeFn = func(ctx context.Context, c rona.QuickTestCode) (*rona.QuickTest, error) {
+			if c != code {
+				t.Errorf("want code %v, got %v", code, c)
+			}
+			return &rona.QuickTest{ID: testID, Code: c}, nil
+		}
+
+		request, _ := http.NewRequest(http.MethodGet, "/tests/"+strings.ToLower(code.String()), nil)
+		request.Header.Set("Accept", "application/json")
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		if response.Code != http.StatusOK {
+			t.Fatalf("want %v, got %v", http.StatusOK, response.Code)
+		}
+	})
+
+	t.Run("return 400 for a mistyped code", func(t *testing.T) {
+		server := MustCreateServer(t)
+
+		request, _ := http.NewRequest(http.MethodGet, "/tests/00000-00001", nil)
+		request.Header.Set("Accept", "application/json")
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		if response.Code != http.StatusBadRequest {
+			t.Errorf("want %v, got %v", http.StatusBadRequest, response.Code)
+		}
+	})
+}
+
+func TestPOSTQuickTest(t *testing.T) {
+	t.Run("create a quick test with the given id", func(t *testing.T) {
+		server := MustCreateServer(t)
+		id := rona.NewQuickTestID()
+
+		server.QuickTestService.CreateQuickTestFn = func(ctx context.Context, id rona.QuickTestID) (*rona.QuickTest, error) {
+			return &rona.QuickTest{ID: id}, nil
+		}
+
+		request, _ := http.NewRequest(http.MethodPost, "/tests", strings.NewReader(`{"id":"`+string(id)+`"}`))
+		request.Header.Set("Content-Type", "application/json")
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		if response.Code != http.StatusCreated {
+			t.Fatalf("want %v, got %v", http.StatusCreated, response.Code)
+		}
+
+		var qt rona.QuickTest
+		MustDecodeJSON(t, response, &qt)
+		if qt.ID != id {
+			t.Errorf("want id %v, got %v", id, qt.ID)
+		}
+	})
+
+	t.Run("generate an id when none is given", func(t *testing.T) {
+		server := MustCreateServer(t)
+
+		server.QuickTestService.CreateQuickTestFn = func(ctx context.Context, id rona.QuickTestID) (*rona.QuickTest, error) {
+			return &rona.QuickTest{ID: id}, nil
+		}
+
+		request, _ := http.NewRequest(http.MethodPost, "/tests", strings.NewReader(`{}`))
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		var qt rona.QuickTest
+		MustDecodeJSON(t, response, &qt)
+		if err := qt.ID.Validate(); err != nil {
+			t.Errorf("expected a generated id: %v", err)
+		}
+	})
+
+	t.Run("return 409 on duplicates", func(t *testing.T) {
+		server := MustCreateServer(t)
+
+		server.QuickTestService.CreateQuickTestFn = func(ctx context.Context, id rona.QuickTestID) (*rona.QuickTest, error) {
+			return nil, rona.Errorf(rona.ECONFLICT, "duplicate record")
+		}
+
+		request, _ := http.NewRequest(http.MethodPost, "/tests", strings.NewReader(`{}`))
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		if response.Code != http.StatusConflict {
+			t.Errorf("want %v, got %v", http.StatusConflict, response.Code)
+		}
+	})
+}
+
+func TestPOSTQuickTestBatch(t *testing.T) {
+	t.Run("create a batch of quick tests", func(t *testing.T) {
+		server := MustCreateServer(t)
+
+		server.QuickTestService.CreateManyQuickTestsFn = func(ctx context.Context, ids []rona.QuickTestID) ([]*rona.QuickTest, error) {
+			qts := make([]*rona.QuickTest, 0, len(ids))
+			for _, id := range ids {
+				qts = append(qts, &rona.QuickTest{ID: id})
+			}
+			return qts, nil
+		}
+
+		request, _ := http.NewRequest(http.MethodPost, "/tests/batch", strings.NewReader(`{"ids":["a","b"]}`))
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		if response.Code != http.StatusCreated {
+			t.Fatalf("want %v, got %v", http.StatusCreated, response.Code)
+		}
+
+		var body struct {
+			QuickTests []*rona.QuickTest `json:"tests"`
+		}
+		MustDecodeJSON(t, response, &body)
+		if len(body.QuickTests) != 2 {
+			t.Errorf("want 2 tests, got %d", len(body.QuickTests))
+		}
+	})
+
+	t.Run("reject batches with too many tests", func(t *testing.T) {
+		server := MustCreateServer(t)
+
+		server.QuickTestService.CreateManyQuickTestsFn = func(ctx context.Context, ids []rona.QuickTestID) ([]*rona.QuickTest, error) {
+			t.Error("expected the batch to be rejected")
+			return nil, nil
+		}
+
+		ids := make([]string, 10001)
+		for i := range ids {
+			ids[i] = `"` + string(rona.NewQuickTestID()) + `"`
+		}
+		body := `{"ids":[` + strings.Join(ids, ",") + `]}`
+		request, _ := http.NewRequest(http.MethodPost, "/tests/batch", strings.NewReader(body))
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		if response.Code != http.StatusBadRequest {
+			t.Errorf("want %v, got %v", http.StatusBadRequest, response.Code)
+		}
+	})
+
+	t.Run("reject bodies that are too large", func(t *testing.T) {
+		server := MustCreateServer(t)
+
+		server.QuickTestService.CreateManyQuickTestsFn = func(ctx context.Context, ids []rona.QuickTestID) ([]*rona.QuickTest, error) {
+			t.Error("expected the body to be rejected")
+			return nil, nil
+		}
+
+		body := `{"ids":["` + strings.Repeat("a", 2<<20) + `"]}`
+		request, _ := http.NewRequest(http.MethodPost, "/tests/batch", strings.NewReader(body))
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		if response.Code != http.StatusBadRequest {
+			t.Errorf("want %v, got %v", http.StatusBadRequest, response.Code)
+		}
+	})
+}
+
+func TestPOSTQuickTestRegister(t *testing.T) {
+	t.Run("register the quick test", func(t *testing.T) {
+		server := MustCreateServer(t)
+
+		var got *rona.QuickTestRegister
+		server.QuickTestService.RegisterQuickTestFn = func(ctx context.Context, reg *rona.QuickTestRegister) (*rona.QuickTest, error) {
+			got = reg
+			return &rona.QuickTest{ID: reg.ID, Person: reg.Person}, nil
+		}
+
+		request, _ := http.NewRequest(http.MethodPost, "/tests/a/register", strings.NewReader(`{"person":"Steve"}`))
+		request.Header.Set("Content-Type", "application/json")
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		if response.Code != http.StatusOK {
+			t.Errorf("want %v, got %v", http.StatusOK, response.Code)
+		}
+		if got == nil || got.ID != "a" || got.Person != "Steve" {
+			t.Errorf("unexpected registration: %#v", got)
+		}
+	})
+
+	t.Run("return 410 when the test has expired", func(t *testing.T) {
+		server := MustCreateServer(t)
+
+		server.QuickTestService.RegisterQuickTestFn = func(ctx context.Context, reg *rona.QuickTestRegister) (*rona.QuickTest, error) {
+			return nil, rona.Errorf(rona.EEXPIRED, "test has already expired")
+		}
+
+		request, _ := http.NewRequest(http.MethodPost, "/tests/a/register", strings.NewReader(`{"person":"Steve"}`))
+		request.Header.Set("Content-Type", "application/json")
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		if response.Code != http.StatusGone {
+			t.Errorf("want %v, got %v", http.StatusGone, response.Code)
+		}
+	})
+}
+
+func TestPOSTQuickTestExpire(t *testing.T) {
+	t.Run("expire the quick test", func(t *testing.T) {
+		server := MustCreateServer(t)
+
+		var got rona.QuickTestID
+		server.QuickTestService.ExpireQuickTestFn = func(ctx context.Context, id rona.QuickTestID) error {
+			got = id
+			return nil
+		}
+
+		request, _ := http.NewRequest(http.MethodPost, "/tests/a/expire", nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		if response.Code != http.StatusNoContent {
+			t.Errorf("want %v, got %v", http.StatusNoContent, response.Code)
+		}
+		if got != "a" {
+			t.Errorf("want id %v, got %v", "a", got)
+		}
+	})
+}
+
+func TestRegistrationForm(t *testing.T) {
+	t.Run("prefill the test id from the link", func(t *testing.T) {
+		server := MustCreateServer(t)
+
+		request, _ := http.NewRequest(http.MethodGet, "/tests/"+string(testID)+"/register", nil)
+		response := httptest.NewRecorder()
+
+		server.ServeHTTP(response, request)
+
+		if response.Code != http.StatusOK {
+			t.Errorf("want %v, got %v", http.StatusOK, response.Code)
+		}
+		if !strings.Contains(response.Body.String(), `value="`+string(testID)+`"`) {
+			t.Errorf("expected the id to be prefilled: %s", response.Body.String())
+		}
+	})
+
+	t.Run("register and show a confirmation", func(t *testing.T) {
+		server := MustCreateServer(t)
+
+		var got *rona.QuickTestRegister
+		server.QuickTestService.RegisterQuickTestFn = func(ctx context.Context, reg *rona.QuickTestRegister) (*rona.QuickTest, error) {
+			got = reg
+			return &rona.QuickTest{ID: reg.ID, Person: reg.Person}, nil
+		}
+		server.QuickTestService.FindQuickTestByIDFn = func(ctx context.Context, id rona.QuickTestID) (*rona.QuickTest, error) {
+			return &rona.QuickTest{ID: id, RegisteredAt: time.Now()}, nil
+		}
+
+		response := postForm(server, "/tests/register", url.Values{"id": {" " + string(testID) + " "}, "person": {"Steve"}})
+
+		if response.Code != http.StatusSeeOther {
+			t.Fatalf("want %v, got %v", http.StatusSeeOther, response.Code)
+		}
+		if location := response.Header().Get("Location"); location != "/tests/"+string(testID) {
+			t.Errorf("want redirect to /tests/%v, got %v", testID, location)
+		}
+		if got == nil || got.ID != testID || got.Person != "Steve" {
+			t.Errorf("unexpected registration: %#v", got)
+		}
+
+		request, _ := http.NewRequest(http.MethodGet, "/tests/"+string(testID), nil)
+		for _, cookie := range response.Result().Cookies() {
+			request.AddCookie(cookie)
+		}
+		confirmation := httptest.NewRecorder()
+
+		server.ServeHTTP(confirmation, request)
+
+		if !strings.Contains(confirmation.Body.String(), "Your test has been registered.") {
+			t.Errorf("expected a confirmation message: %s", confirmation.Body.String())
+		}
+	})
+
+	t.Run("register by short code", func(t *testing.T) {
+		server := MustCreateServer(t)
+		code := rona.NewQuickTestCode()
+
+		server.QuickTestService.FindQuickTestByCodeFn = func(ctx context.Context, c rona.QuickTestCode) (*rona.QuickTest, error) {
+			return &rona.QuickTest{ID: testID, Code: c}, nil
+		}
+		var got *rona.QuickTestRegister
+		server.QuickTestService.RegisterQuickTestFn = func(ctx context.Context, reg *rona.QuickTestRegister) (*rona.QuickTest, error) {
+			got = reg
+			return &rona.QuickTest{ID: reg.ID, Person: reg.Person}, nil
+		}
+
+		response := postForm(server, "/tests/register", url.Values{"id": {code.String()}, "person": {"Steve"}})
+
+		if response.Code != http.StatusSeeOther {
+			t.Fatalf("want %v, got %v", http.StatusSeeOther, response.Code)
+		}
+		if got == nil || got.ID != testID {
+			t.Errorf("unexpected registration: %#v", got)
+		}
+	})
+
+	cases := []struct {
+		message string
+		err     error
+		status  int
+		body    string
+	}{
+		{"not found", rona.Errorf(rona.ENOTFOUND, "no test"), http.StatusNotFound, "couldn&#39;t find a test"},
+		{"already registered", rona.Errorf(rona.ECONFLICT, "registered"), http.StatusConflict, "already been registered"},
+		{"expired", rona.Errorf(rona.EEXPIRED, "expired"), http.StatusGone, "has expired"},
+		{"invalid", rona.Errorf(rona.EINVALID, "name is required"), http.StatusBadRequest, "name is required"},
+	}
+
+	for _, tc := range cases {
+		t.Run("show a friendly error when "+tc.message, func(t *testing.T) {
+			server := MustCreateServer(t)
+
+			server.QuickTestService.RegisterQuickTestFn = func(ctx context.Context, reg *rona.QuickTestRegister) (*rona.QuickTest, error) {
+				return nil, tc.err
+			}
+
+			response := postForm(server, "/tests/register", url.Values{"id": {string(testID)}, "person": {"Steve"}})
+
+			if response.Code != tc.status {
+				t.Errorf("want %v, got %v", tc.status, response.Code)
+			}
+			if !strings.Contains(response.Body.String(), tc.body) {
+				t.Errorf("expected %q in body: %s", tc.body, response.Body.String())
+			}
+			if !strings.Contains(response.Body.String(), `value="Steve"`) {
+				t.Errorf("expected the form to keep its values: %s", response.Body.String())
+			}
+		})
+	}
+}
+
+func postForm(server *Server, path string, form url.Values) *httptest.ResponseRecorder {
+	request, _ := http.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	response := httptest.NewRecorder()
+
+	server.ServeHTTP(response, request)
+	return response
+}
+
+func MustDecodeJSON(tb testing.TB, response *httptest.ResponseRecorder, v interface{}) {
+	tb.Helper()
+
+	if err := json.NewDecoder(response.Body).Decode(v); err != nil {
+		tb.Fatalf("failed to decode json response %q: %v", response.Body.String(), err)
+	}
+}
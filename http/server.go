@@ -1,8 +1,13 @@
 package http
 
 import (
-	"fmt"
+	"context"
+	"encoding/json"
+	"log"
+	"mime"
+	"net"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/go-chi/chi"
@@ -12,30 +17,61 @@ import (
 
 // Server is the applications http server
 type Server struct {
+	server *http.Server
+	ln     net.Listener
+
 	QuickTestService rona.QuickTestService
+	UserService      rona.UserService
+	AuditService     rona.AuditService
 	Router           http.Handler
 	TC               TemplateCache
+
+	// Addr is the address the server listens on.
+	Addr string
+
+	// BaseURL is the public URL of the server, used in QR codes. The QR
+	// code and label endpoints are disabled when empty.
+	BaseURL string
+
+	// CertFile and KeyFile enable TLS when both are set.
+	CertFile string
+	KeyFile  string
+
+	// LogRequests enables request logging.
+	LogRequests bool
+
+	// SessionKey signs the session cookies.
+	SessionKey []byte
+
+	// Clock tells the time sessions expire at. Defaults to the system clock.
+	Clock rona.Clock
 }
 
 // NewServer creates a new http server
 func NewServer(quickTestService rona.QuickTestService) (*Server, error) {
 	s := &Server{
 		QuickTestService: quickTestService,
+		Addr:             ":8080",
+		LogRequests:      true,
+		SessionKey:       NewSessionKey(),
+		Clock:            rona.SystemClock,
 	}
 	router := chi.NewRouter()
 
 	router.Use(middleware.RequestID)
+	router.Use(requestIDContext)
 	router.Use(middleware.RealIP)
-	router.Use(middleware.Logger)
+	router.Use(instrument)
+	router.Use(s.requestLogger)
 	router.Use(middleware.Recoverer)
 
 	router.Use(middleware.Timeout(30 * time.Second))
+	router.Use(loadFlash)
+	router.Use(s.authenticate)
 
-	router.Route("/tests", func(r chi.Router) {
-		r.Get("/", s.showTestForm)
-		r.Post("/", s.createTest)
-		r.Get("/{testID}", s.getTest)
-	})
+	s.registerAuthRoutes(router)
+	router.Route("/tests", s.registerQuickTestRoutes)
+	router.Route("/admin", s.registerAuditRoutes)
 
 	tc, err := NewTemplateCache()
 	if err != nil {
@@ -44,36 +80,126 @@ func NewServer(quickTestService rona.QuickTestService) (*Server, error) {
 
 	s.Router = router
 	s.TC = tc
+	s.server = &http.Server{Handler: s}
 
 	return s, nil
 }
 
-// Start the http server
-func (s *Server) Start() error {
-	return http.ListenAndServe(":8080", s.Router)
+// Open starts listening on Addr and serves requests in the background.
+func (s *Server) Open() (err error) {
+	if s.ln, err = net.Listen("tcp", s.Addr); err != nil {
+		return err
+	}
+
+	go func() {
+		var err error
+		if s.TLS() {
+			err = s.server.ServeTLS(s.ln, s.CertFile, s.KeyFile)
+		} else {
+			err = s.server.Serve(s.ln)
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Printf("http: serve error: %v", err)
+		}
+	}()
+
+	return nil
+}
+
+// Shutdown stops accepting new connections and waits for in-flight
+// requests to finish until ctx is done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
+
+// URL returns the base URL the server is listening on. Only valid after
+// the server has been opened.
+func (s *Server) URL() string {
+	scheme := "http"
+	if s.TLS() {
+		scheme = "https"
+	}
+	if s.ln == nil {
+		return scheme + "://" + s.Addr
+	}
+	return scheme + "://" + s.ln.Addr().String()
+}
+
+// TLS reports whether the server serves HTTPS.
+func (s *Server) TLS() bool {
+	return s.CertFile != "" && s.KeyFile != ""
 }
 
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	s.Router.ServeHTTP(w, r)
 }
 
-func (s *Server) showTestForm(w http.ResponseWriter, r *http.Request) {
-	if err := s.TC.Render(w, "get-test", nil); err != nil {
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-	}
+// requestLogger logs requests when request logging is enabled.
+func (s *Server) requestLogger(next http.Handler) http.Handler {
+	logged := middleware.Logger(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.LogRequests {
+			logged.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
 }
 
-func (s *Server) createTest(w http.ResponseWriter, r *http.Request) {}
+// requestIDContext adds the request ID to the application context so the
+// services can record it.
+func requestIDContext(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := rona.NewContextWithRequestID(r.Context(), middleware.GetReqID(r.Context()))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
 
-func (s *Server) getTest(w http.ResponseWriter, r *http.Request) {
-	testID := chi.URLParam(r, "testID")
+// acceptsJSON reports whether the client asked for a JSON response. The
+// first of JSON or HTML listed in the Accept header wins. Clients that ask
+// for neither get JSON when they sent JSON.
+func acceptsJSON(r *http.Request) bool {
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(accept)
+		if err != nil {
+			continue
+		}
+
+		switch mediaType {
+		case "application/json":
+			return true
+		case "text/html":
+			return false
+		}
+	}
+	return sentJSON(r)
+}
 
-	qt, err := s.QuickTestService.FindQuickTestByID(r.Context(), rona.QuickTestID(testID))
+// sentJSON reports whether the request body is JSON encoded.
+func sentJSON(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/json"
+}
 
-	if err != nil {
-		http.NotFound(w, r)
-		return
+// encodeJSON writes v as a JSON response with the given status code.
+func encodeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("http: cannot encode json response: %v", err)
 	}
+}
+
+// maxJSONBodySize is the largest JSON request body read, which fits a
+// batch of maxBatchSize test IDs.
+const maxJSONBodySize = 1 << 20
 
-	fmt.Fprintf(w, "%#v", qt)
+// decodeJSON reads the JSON request body into v. Bodies larger than
+// maxJSONBodySize are rejected.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxJSONBodySize)
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return rona.Errorf(rona.EINVALID, "invalid json body: %v", err)
+	}
+	return nil
 }
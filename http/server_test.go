@@ -3,83 +3,23 @@ package http_test
 import (
 	"context"
 	"net/http"
-	"net/http/httptest"
 	"testing"
 
 	"github.com/richardmarbach/rona"
+
 	ronahttp "github.com/richardmarbach/rona/http"
 	"github.com/richardmarbach/rona/mock"
 )
 
-func TestGETQuickTest(t *testing.T) {
-	// t.Run("get test a's owner", func(t *testing.T) {
-	// 	server := MustCreateServer(t)
-
-	// 	server.QuickTestService.FindQuickTestByIDFn = func(ctx context.Context, id rona.QuickTestID) (*rona.QuickTest, error) {
-	// 		return &rona.QuickTest{ID: id, Person: "Steve"}, nil
-	// 	}
-
-	// 	request, _ := http.NewRequest(http.MethodGet, "/tests/a", nil)
-	// 	response := httptest.NewRecorder()
-
-	// 	server.ServeHTTP(response, request)
-
-	// 	got := response.Body.String()
-	// 	want := "Steve"
-
-	// 	if got != want {
-	// 		t.Errorf("want %v, got %v", got, want)
-	// 	}
-	// })
-
-	// t.Run("get test b's owner", func(t *testing.T) {
-	// 	server := MustCreateServer(t)
-
-	// 	server.QuickTestService.FindQuickTestByIDFn = func(ctx context.Context, id rona.QuickTestID) (*rona.QuickTest, error) {
-	// 		if id == "b" {
-	// 			return &rona.QuickTest{ID: id, Person: "Bob"}, nil
-	// 		}
-	// 		return nil, nil
-	// 	}
-
-	// 	request, _ := http.NewRequest(http.MethodGet, "/tests/b", nil)
-	// 	response := httptest.NewRecorder()
-
-	// 	server.ServeHTTP(response, request)
-
-	// 	got := response.Body.String()
-	// 	want := "Bob"
-
-	// 	if got != want {
-	// 		t.Errorf("want %v, got %v", got, want)
-	// 	}
-	// })
-
-	t.Run("return 404 when no quick test is present", func(t *testing.T) {
-		server := MustCreateServer(t)
-
-		server.QuickTestService.FindQuickTestByIDFn = func(ctx context.Context, id rona.QuickTestID) (*rona.QuickTest, error) {
-			return nil, rona.Errorf(rona.ENOTFOUND, "No id found")
-		}
-
-		request, _ := http.NewRequest(http.MethodGet, "/tests/b", nil)
-		response := httptest.NewRecorder()
-
-		server.ServeHTTP(response, request)
-
-		got := response.Code
-		want := http.StatusNotFound
-
-		if got != want {
-			t.Errorf("want %v, got %v", got, want)
-		}
-	})
-}
+// testID is a valid quick test ID for requests against mocked services.
+var testID = rona.NewQuickTestID()
 
 type Server struct {
 	*ronahttp.Server
 
 	QuickTestService mock.QuickTestService
+	UserService      mock.UserService
+	AuditService     mock.AuditService
 }
 
 func MustCreateServer(tb testing.TB) *Server {
@@ -90,7 +30,62 @@ func MustCreateServer(tb testing.TB) *Server {
 		tb.Fatal(err)
 	} else {
 		s.Server = server
+		s.Server.UserService = &s.UserService
+		s.Server.AuditService = &s.AuditService
 	}
 
 	return s
 }
+
+func TestServer_Shutdown(t *testing.T) {
+	t.Run("drain in-flight requests", func(t *testing.T) {
+		server := MustCreateServer(t)
+		server.Addr = "127.0.0.1:0"
+
+		started := make(chan struct{})
+		release := make(chan struct{})
+		server.QuickTestService.FindQuickTestByIDFn = func(ctx context.Context, id rona.QuickTestID) (*rona.QuickTest, error) {
+			close(started)
+			<-release
+			return &rona.QuickTest{ID: id}, nil
+		}
+
+		if err := server.Open(); err != nil {
+			t.Fatal(err)
+		}
+
+		responses := make(chan *http.Response, 1)
+		go func() {
+			request, _ := http.NewRequest(http.MethodGet, server.URL()+"/tests/"+string(testID), nil)
+			request.Header.Set("Accept", "application/json")
+			response, err := http.DefaultClient.Do(request)
+			if err != nil {
+				t.Errorf("request failed: %v", err)
+				close(responses)
+				return
+			}
+			responses <- response
+		}()
+		<-started
+
+		shutdown := make(chan error, 1)
+		go func() {
+			shutdown <- server.Shutdown(context.Background())
+		}()
+
+		close(release)
+
+		if err := <-shutdown; err != nil {
+			t.Errorf("expected no error, got %v", err)
+		}
+
+		response, ok := <-responses
+		if !ok {
+			return
+		}
+		defer response.Body.Close()
+		if response.StatusCode != http.StatusOK {
+			t.Errorf("want %v, got %v", http.StatusOK, response.StatusCode)
+		}
+	})
+}
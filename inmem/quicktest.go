@@ -0,0 +1,251 @@
+// Package inmem implements the rona services in memory. Nothing is
+// persisted, so all data is lost when the process exits. It needs no
+// database and suits tests and demos.
+package inmem
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/richardmarbach/rona"
+)
+
+var _ rona.QuickTestService = &QuickTestService{}
+
+// QuickTestService manages quick tests in memory.
+type QuickTestService struct {
+	mu sync.Mutex
+
+	// tests are keyed by their canonical ID.
+	tests map[rona.QuickTestID]*rona.QuickTest
+	codes map[rona.QuickTestCode]rona.QuickTestID
+
+	// Clock tells the time of state changes. Defaults to the system clock.
+	Clock rona.Clock
+}
+
+// NewQuickTestService creates a new, empty QuickTestService.
+func NewQuickTestService() *QuickTestService {
+	return &QuickTestService{
+		tests: make(map[rona.QuickTestID]*rona.QuickTest),
+		codes: make(map[rona.QuickTestCode]rona.QuickTestID),
+		Clock: rona.SystemClock,
+	}
+}
+
+// FindQuickTestByID retrieves a quicktest by id.
+func (s *QuickTestService) FindQuickTestByID(ctx context.Context, id rona.QuickTestID) (*rona.QuickTest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	quicktest, err := s.findQuickTestByID(id)
+	if err != nil {
+		return nil, err
+	}
+	return copyQuickTest(quicktest), nil
+}
+
+// FindQuickTestByCode retrieves a quicktest by its short code.
+func (s *QuickTestService) FindQuickTestByCode(ctx context.Context, code rona.QuickTestCode) (*rona.QuickTest, error) {
+	if err := code.Validate(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	id, ok := s.codes[code]
+	if !ok {
+		return nil, rona.Errorf(rona.ENOTFOUND, "No quick test found for %v", code)
+	}
+	return copyQuickTest(s.tests[id]), nil
+}
+
+// findQuickTestByID returns the stored quicktest. The caller must hold mu.
+func (s *QuickTestService) findQuickTestByID(id rona.QuickTestID) (*rona.QuickTest, error) {
+	quicktest, ok := s.tests[canonicalID(id)]
+	if !ok {
+		return nil, rona.Errorf(rona.ENOTFOUND, "No quick test found for %v", id)
+	}
+	return quicktest, nil
+}
+
+// CreateQuickTest creates a new quicktest
+func (s *QuickTestService) CreateQuickTest(ctx context.Context, id rona.QuickTestID) (*rona.QuickTest, error) {
+	quicktests, err := s.CreateManyQuickTests(ctx, []rona.QuickTestID{id})
+	if err != nil {
+		return nil, err
+	}
+	return quicktests[0], nil
+}
+
+// CreateManyQuickTests creates all quick tests or none of them. Only
+// manufacturers can create quick tests.
+func (s *QuickTestService) CreateManyQuickTests(ctx context.Context, ids []rona.QuickTestID) ([]*rona.QuickTest, error) {
+	if err := rona.RequireRole(ctx, rona.RoleManufacturer); err != nil {
+		return nil, err
+	}
+
+	for _, id := range ids {
+		if err := id.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Check every ID before storing anything, so a conflict leaves the
+	// store unchanged.
+	seen := make(map[rona.QuickTestID]bool, len(ids))
+	for _, id := range ids {
+		id = canonicalID(id)
+		if _, ok := s.tests[id]; ok || seen[id] {
+			return nil, rona.Errorf(rona.ECONFLICT, "duplicate record")
+		}
+		seen[id] = true
+	}
+
+	now := s.Clock.Now()
+	quicktests := make([]*rona.QuickTest, 0, len(ids))
+	for _, id := range ids {
+		id = canonicalID(id)
+		quicktest := &rona.QuickTest{
+			ID:        id,
+			Code:      s.newQuickTestCode(),
+			Result:    rona.QuickTestResultPending,
+			CreatedAt: now,
+		}
+		s.tests[id] = quicktest
+		s.codes[quicktest.Code] = id
+		quicktests = append(quicktests, copyQuickTest(quicktest))
+	}
+	return quicktests, nil
+}
+
+// newQuickTestCode returns a short code no other quicktest uses. The
+// caller must hold mu.
+func (s *QuickTestService) newQuickTestCode() rona.QuickTestCode {
+	for {
+		code := rona.NewQuickTestCode()
+		if _, ok := s.codes[code]; !ok {
+			return code
+		}
+	}
+}
+
+// RegisterQuickTest registers a new QuickTest
+func (s *QuickTestService) RegisterQuickTest(ctx context.Context, reg *rona.QuickTestRegister) (*rona.QuickTest, error) {
+	if err := reg.Validate(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	quicktest, err := s.findQuickTestByID(reg.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if quicktest.Registered() {
+		return nil, rona.Errorf(rona.ECONFLICT, "test has already been registered")
+	} else if quicktest.Expired {
+		return nil, rona.Errorf(rona.EEXPIRED, "test has already expired")
+	}
+
+	quicktest.Person = reg.Person
+	quicktest.RegisteredAt = s.Clock.Now()
+	return copyQuickTest(quicktest), nil
+}
+
+// RecordQuickTestResult records the result of a registered QuickTest. Only
+// test center staff can record results.
+func (s *QuickTestService) RecordQuickTestResult(ctx context.Context, rec *rona.QuickTestRecordResult) (*rona.QuickTest, error) {
+	if err := rona.RequireRole(ctx, rona.RoleStaff); err != nil {
+		return nil, err
+	} else if err := rec.Validate(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	quicktest, err := s.findQuickTestByID(rec.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if quicktest.Expired {
+		return nil, rona.Errorf(rona.EEXPIRED, "test has already expired")
+	} else if !quicktest.Registered() {
+		return nil, rona.Errorf(rona.EINVALID, "test has not been registered")
+	} else if quicktest.Resulted() {
+		return nil, rona.Errorf(rona.ECONFLICT, "test result has already been recorded")
+	}
+
+	quicktest.Result = rec.Result
+	quicktest.ResultedAt = s.Clock.Now()
+	return copyQuickTest(quicktest), nil
+}
+
+// ExpireQuickTest by ID. An expired quicktest removes PII. Only test
+// center staff can expire quick tests.
+func (s *QuickTestService) ExpireQuickTest(ctx context.Context, id rona.QuickTestID) error {
+	if err := rona.RequireRole(ctx, rona.RoleStaff); err != nil {
+		return err
+	} else if err := id.Validate(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	quicktest, ok := s.tests[canonicalID(id)]
+	if !ok {
+		return rona.Errorf(rona.ENOTFOUND, "quick test does not exist: %v", id)
+	}
+	expire(quicktest)
+	return nil
+}
+
+// ExpireOutdatedQuickTests expires all quick tests registered after the given duration.
+func (s *QuickTestService) ExpireOutdatedQuickTests(ctx context.Context, d time.Duration) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.Clock.Now()
+
+	var n int
+	for _, quicktest := range s.tests {
+		if !quicktest.Expired && quicktest.ShouldExpire(now, d) {
+			expire(quicktest)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// expire marks the quicktest as expired and removes its PII.
+func expire(quicktest *rona.QuickTest) {
+	quicktest.Expired = true
+	quicktest.Person = ""
+}
+
+// copyQuickTest returns a copy of the stored quicktest, so callers can't
+// change the store without holding the lock.
+func copyQuickTest(quicktest *rona.QuickTest) *rona.QuickTest {
+	other := *quicktest
+	return &other
+}
+
+// canonicalID returns the quick test ID in the form of the database
+// stores, so IDs that only differ in case or format find the same test.
+func canonicalID(id rona.QuickTestID) rona.QuickTestID {
+	if u, err := uuid.Parse(string(id)); err == nil {
+		return rona.QuickTestID(u.String())
+	}
+	return id
+}
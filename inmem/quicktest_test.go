@@ -0,0 +1,17 @@
+package inmem_test
+
+import (
+	"testing"
+
+	"github.com/richardmarbach/rona"
+	"github.com/richardmarbach/rona/inmem"
+	"github.com/richardmarbach/rona/ronatest"
+)
+
+func TestQuickTestService(t *testing.T) {
+	ronatest.TestQuickTestService(t, func(tb testing.TB, clock *ronatest.Clock) rona.QuickTestService {
+		s := inmem.NewQuickTestService()
+		s.Clock = clock
+		return s
+	})
+}
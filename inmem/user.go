@@ -0,0 +1,182 @@
+package inmem
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"sync"
+
+	"github.com/richardmarbach/rona"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var _ rona.UserService = &UserService{}
+
+// apiKeyPrefix makes API keys recognizable, e.g. in secret scanners. It
+// matches the prefix of the database stores.
+const apiKeyPrefix = "rona_"
+
+// UserService manages users in memory.
+type UserService struct {
+	mu      sync.Mutex
+	users   map[int]*user
+	emails  map[string]int
+	apiKeys map[string]int
+	nextID  int
+
+	// Clock tells the time users are created and changed. Defaults to
+	// the system clock.
+	Clock rona.Clock
+}
+
+// user is a stored user with its credentials.
+type user struct {
+	rona.User
+	passwordHash []byte
+	apiKeyHash   string
+}
+
+// NewUserService creates a new UserService without users.
+func NewUserService() *UserService {
+	return &UserService{
+		users:   make(map[int]*user),
+		emails:  make(map[string]int),
+		apiKeys: make(map[string]int),
+		nextID:  1,
+		Clock:   rona.SystemClock,
+	}
+}
+
+// FindUserByID retrieves a user by id.
+func (s *UserService) FindUserByID(ctx context.Context, id int) (*rona.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[id]
+	if !ok {
+		return nil, rona.Errorf(rona.ENOTFOUND, "user not found")
+	}
+	return copyUser(u), nil
+}
+
+// Authenticate a user by email and password.
+func (s *UserService) Authenticate(ctx context.Context, email, password string) (*rona.User, error) {
+	// Compare the password without holding the lock, bcrypt is slow.
+	s.mu.Lock()
+	u, ok := s.users[s.emails[strings.ToLower(email)]]
+	var found *rona.User
+	var passwordHash []byte
+	if ok {
+		found, passwordHash = copyUser(u), u.passwordHash
+	}
+	s.mu.Unlock()
+
+	if !ok {
+		return nil, rona.Errorf(rona.EUNAUTHORIZED, "invalid email or password")
+	} else if err := bcrypt.CompareHashAndPassword(passwordHash, []byte(password)); err != nil {
+		return nil, rona.Errorf(rona.EUNAUTHORIZED, "invalid email or password")
+	}
+	return found, nil
+}
+
+// AuthenticateAPIKey finds the user owning the API key.
+func (s *UserService) AuthenticateAPIKey(ctx context.Context, key string) (*rona.User, error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return nil, rona.Errorf(rona.EUNAUTHORIZED, "invalid api key")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[s.apiKeys[hashAPIKey(key)]]
+	if !ok {
+		return nil, rona.Errorf(rona.EUNAUTHORIZED, "invalid api key")
+	}
+	return copyUser(u), nil
+}
+
+// CreateUser creates a new user with a hashed password.
+func (s *UserService) CreateUser(ctx context.Context, create *rona.UserCreate) (*rona.User, error) {
+	if err := rona.RequireRole(ctx, rona.RoleAdmin); err != nil {
+		return nil, err
+	} else if err := create.Validate(); err != nil {
+		return nil, err
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(create.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	email := strings.ToLower(create.Email)
+	if _, ok := s.emails[email]; ok {
+		return nil, rona.Errorf(rona.ECONFLICT, "duplicate record")
+	}
+
+	now := s.Clock.Now()
+	u := &user{
+		User: rona.User{
+			ID:        s.nextID,
+			Name:      create.Name,
+			Email:     email,
+			Role:      create.Role,
+			CreatedAt: now,
+			UpdatedAt: now,
+		},
+		passwordHash: passwordHash,
+	}
+	s.nextID++
+	s.users[u.ID] = u
+	s.emails[email] = u.ID
+
+	return copyUser(u), nil
+}
+
+// GenerateAPIKey creates a new API key for the user. Only a hash of the key
+// is kept.
+func (s *UserService) GenerateAPIKey(ctx context.Context, id int) (string, error) {
+	if user := rona.UserFromContext(ctx); user == nil || (user.ID != id && !user.HasRole(rona.RoleAdmin)) {
+		return "", rona.Errorf(rona.EUNAUTHORIZED, "you are not allowed to generate api keys for this user")
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	key := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(buf)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[id]
+	if !ok {
+		return "", rona.Errorf(rona.ENOTFOUND, "user does not exist: %d", id)
+	}
+
+	delete(s.apiKeys, u.apiKeyHash)
+	u.apiKeyHash = hashAPIKey(key)
+	u.UpdatedAt = s.Clock.Now()
+	s.apiKeys[u.apiKeyHash] = id
+
+	return key, nil
+}
+
+// copyUser returns a copy of the user without credentials, so callers
+// can't change the stored user.
+func copyUser(u *user) *rona.User {
+	other := u.User
+	return &other
+}
+
+// hashAPIKey hashes the API key for lookups. API keys have enough entropy
+// that a fast hash is sufficient.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
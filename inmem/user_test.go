@@ -0,0 +1,15 @@
+package inmem_test
+
+import (
+	"testing"
+
+	"github.com/richardmarbach/rona"
+	"github.com/richardmarbach/rona/inmem"
+	"github.com/richardmarbach/rona/ronatest"
+)
+
+func TestUserService(t *testing.T) {
+	ronatest.TestUserService(t, func(tb testing.TB) rona.UserService {
+		return inmem.NewUserService()
+	})
+}
@@ -0,0 +1,16 @@
+package mock
+
+import (
+	"context"
+
+	"github.com/richardmarbach/rona"
+)
+
+// AuditService mock
+type AuditService struct {
+	FindAuditEventsFn func(ctx context.Context, filter rona.AuditEventFilter) ([]*rona.AuditEvent, int, error)
+}
+
+func (s *AuditService) FindAuditEvents(ctx context.Context, filter rona.AuditEventFilter) ([]*rona.AuditEvent, int, error) {
+	return s.FindAuditEventsFn(ctx, filter)
+}
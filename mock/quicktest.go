@@ -10,21 +10,31 @@ import (
 // QuickTestService mock
 type QuickTestService struct {
 	FindQuickTestByIDFn        func(ctx context.Context, id rona.QuickTestID) (*rona.QuickTest, error)
+	FindQuickTestByCodeFn      func(ctx context.Context, code rona.QuickTestCode) (*rona.QuickTest, error)
 	RegisterQuickTestFn        func(ctx context.Context, reg *rona.QuickTestRegister) (*rona.QuickTest, error)
+	RecordQuickTestResultFn    func(ctx context.Context, rec *rona.QuickTestRecordResult) (*rona.QuickTest, error)
 	CreateQuickTestFn          func(ctx context.Context, id rona.QuickTestID) (*rona.QuickTest, error)
 	CreateManyQuickTestsFn     func(ctx context.Context, ids []rona.QuickTestID) ([]*rona.QuickTest, error)
 	ExpireQuickTestFn          func(ctx context.Context, id rona.QuickTestID) error
-	ExpireOutdatedQuickTestsFn func(ctx context.Context, d time.Duration) error
+	ExpireOutdatedQuickTestsFn func(ctx context.Context, d time.Duration) (int, error)
 }
 
 func (s *QuickTestService) FindQuickTestByID(ctx context.Context, id rona.QuickTestID) (*rona.QuickTest, error) {
 	return s.FindQuickTestByIDFn(ctx, id)
 }
 
+func (s *QuickTestService) FindQuickTestByCode(ctx context.Context, code rona.QuickTestCode) (*rona.QuickTest, error) {
+	return s.FindQuickTestByCodeFn(ctx, code)
+}
+
 func (s *QuickTestService) RegisterQuickTest(ctx context.Context, reg *rona.QuickTestRegister) (*rona.QuickTest, error) {
 	return s.RegisterQuickTestFn(ctx, reg)
 }
 
+func (s *QuickTestService) RecordQuickTestResult(ctx context.Context, rec *rona.QuickTestRecordResult) (*rona.QuickTest, error) {
+	return s.RecordQuickTestResultFn(ctx, rec)
+}
+
 func (s *QuickTestService) CreateQuickTest(ctx context.Context, id rona.QuickTestID) (*rona.QuickTest, error) {
 	return s.CreateQuickTestFn(ctx, id)
 }
@@ -37,6 +47,6 @@ func (s *QuickTestService) ExpireQuickTest(ctx context.Context, id rona.QuickTes
 	return s.ExpireQuickTestFn(ctx, id)
 }
 
-func (s *QuickTestService) ExpireOutdatedQuickTests(ctx context.Context, d time.Duration) error {
+func (s *QuickTestService) ExpireOutdatedQuickTests(ctx context.Context, d time.Duration) (int, error) {
 	return s.ExpireOutdatedQuickTestsFn(ctx, d)
 }
@@ -0,0 +1,36 @@
+package mock
+
+import (
+	"context"
+
+	"github.com/richardmarbach/rona"
+)
+
+// UserService mock
+type UserService struct {
+	FindUserByIDFn       func(ctx context.Context, id int) (*rona.User, error)
+	AuthenticateFn       func(ctx context.Context, email, password string) (*rona.User, error)
+	AuthenticateAPIKeyFn func(ctx context.Context, key string) (*rona.User, error)
+	CreateUserFn         func(ctx context.Context, create *rona.UserCreate) (*rona.User, error)
+	GenerateAPIKeyFn     func(ctx context.Context, id int) (string, error)
+}
+
+func (s *UserService) FindUserByID(ctx context.Context, id int) (*rona.User, error) {
+	return s.FindUserByIDFn(ctx, id)
+}
+
+func (s *UserService) Authenticate(ctx context.Context, email, password string) (*rona.User, error) {
+	return s.AuthenticateFn(ctx, email, password)
+}
+
+func (s *UserService) AuthenticateAPIKey(ctx context.Context, key string) (*rona.User, error) {
+	return s.AuthenticateAPIKeyFn(ctx, key)
+}
+
+func (s *UserService) CreateUser(ctx context.Context, create *rona.UserCreate) (*rona.User, error) {
+	return s.CreateUserFn(ctx, create)
+}
+
+func (s *UserService) GenerateAPIKey(ctx context.Context, id int) (string, error) {
+	return s.GenerateAPIKeyFn(ctx, id)
+}
@@ -0,0 +1,188 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/richardmarbach/rona"
+)
+
+var _ rona.AuditService = &AuditService{}
+
+// AuditService reads the audit log from the postgres database.
+type AuditService struct {
+	db *DB
+}
+
+// NewAuditService creates a new AuditService
+func NewAuditService(db *DB) *AuditService {
+	return &AuditService{db: db}
+}
+
+// FindAuditEvents retrieves audit events matching the filter, newest first.
+// Only admins can read the audit log.
+func (s *AuditService) FindAuditEvents(ctx context.Context, filter rona.AuditEventFilter) ([]*rona.AuditEvent, int, error) {
+	if err := rona.RequireRole(ctx, rona.RoleAdmin); err != nil {
+		return nil, 0, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+
+	where, args := []string{"TRUE"}, []interface{}{}
+	if v := filter.QuickTestID; v != nil {
+		args = append(args, canonicalID(*v))
+		where = append(where, fmt.Sprintf("quick_test_id = $%d", len(args)))
+	}
+	if v := filter.Action; v != nil {
+		args = append(args, *v)
+		where = append(where, fmt.Sprintf("action = $%d", len(args)))
+	}
+	if v := filter.ActorID; v != nil {
+		args = append(args, *v)
+		where = append(where, fmt.Sprintf("actor_id = $%d", len(args)))
+	}
+
+	var n int
+	if err := tx.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM audit_events WHERE `+strings.Join(where, " AND "),
+		args...,
+	).Scan(&n); err != nil {
+		return nil, 0, FormatError(err)
+	}
+
+	limit := filter.Limit
+	if limit <= 0 || limit > rona.AuditEventMaxLimit {
+		limit = rona.AuditEventMaxLimit
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT
+			id,
+			action,
+			quick_test_id,
+			actor_id,
+			actor_role,
+			request_id,
+			outcome,
+			created_at
+		FROM audit_events
+		WHERE `+strings.Join(where, " AND ")+fmt.Sprintf(`
+		ORDER BY id DESC
+		LIMIT $%d OFFSET $%d
+	`, len(args)+1, len(args)+2), append(args, limit, filter.Offset)...)
+	if err != nil {
+		return nil, 0, FormatError(err)
+	}
+	defer rows.Close()
+
+	events := make([]*rona.AuditEvent, 0)
+	for rows.Next() {
+		var event rona.AuditEvent
+		if err := rows.Scan(
+			&event.ID,
+			&event.Action,
+			&event.QuickTestID,
+			(*NullInt)(&event.ActorID),
+			(*NullString)(&event.ActorRole),
+			(*NullString)(&event.RequestID),
+			&event.Outcome,
+			(*NullTime)(&event.CreatedAt),
+		); err != nil {
+			return nil, 0, FormatError(err)
+		}
+		events = append(events, &event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, FormatError(err)
+	}
+
+	return events, n, nil
+}
+
+// insertAuditEvents appends events to the audit log within the given
+// transaction, so that they are only recorded if the change is committed.
+func insertAuditEvents(ctx context.Context, tx *Tx, events ...*rona.AuditEvent) error {
+	for len(events) > 0 {
+		n := len(events)
+		if n > maxInsertRows {
+			n = maxInsertRows
+		}
+		if err := insertAuditEventRows(ctx, tx, events[:n]); err != nil {
+			return err
+		}
+		events = events[n:]
+	}
+	return nil
+}
+
+// insertAuditEventRows appends events to the audit log in one statement.
+func insertAuditEventRows(ctx context.Context, tx *Tx, events []*rona.AuditEvent) error {
+	const columns = 7
+	valueStrings := make([]string, 0, len(events))
+	valueArgs := make([]interface{}, 0, len(events)*columns)
+	for i, event := range events {
+		event.CreatedAt = tx.Now
+
+		placeholders := make([]string, columns)
+		for j := range placeholders {
+			placeholders[j] = fmt.Sprintf("$%d", i*columns+j+1)
+		}
+		valueStrings = append(valueStrings, "("+strings.Join(placeholders, ", ")+")")
+		valueArgs = append(valueArgs,
+			event.Action,
+			event.QuickTestID,
+			(*NullInt)(&event.ActorID),
+			(*NullString)(&event.ActorRole),
+			(*NullString)(&event.RequestID),
+			event.Outcome,
+			(*NullTime)(&event.CreatedAt),
+		)
+	}
+
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO audit_events (action, quick_test_id, actor_id, actor_role, request_id, outcome, created_at)
+		VALUES `+strings.Join(valueStrings, ","),
+		valueArgs...,
+	)
+	return FormatError(err)
+}
+
+// recordFailure appends a failed action to the audit log. The change itself
+// was rolled back, so the event is written in its own transaction. Errors
+// are logged as they must not hide the original failure.
+//
+// A failed batch is recorded as one event without a quick test, and so are
+// callers who weren't allowed to act, which keeps them from flooding the
+// audit log.
+func (s *QuickTestService) recordFailure(ctx context.Context, action rona.AuditAction, ids []rona.QuickTestID, failure error) {
+	// Invalid IDs are user input that may contain anything.
+	var id rona.QuickTestID
+	if len(ids) == 1 && ids[0].Validate() == nil && rona.ErrorCode(failure) != rona.EUNAUTHORIZED {
+		id = canonicalID(ids[0])
+	}
+	event := rona.NewAuditEvent(ctx, action, id, failure)
+
+	// The request may have failed because it was cancelled, so don't
+	// let its context prevent recording the failure.
+	auditCtx := context.Background()
+	tx, err := s.db.BeginTx(auditCtx, nil)
+	if err != nil {
+		log.Printf("audit error: %v", err)
+		return
+	}
+	defer tx.Rollback()
+
+	if err := insertAuditEvents(auditCtx, tx, event); err != nil {
+		log.Printf("audit error: %v", err)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("audit error: %v", err)
+	}
+}
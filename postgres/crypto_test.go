@@ -0,0 +1,143 @@
+package postgres_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/richardmarbach/rona"
+	"github.com/richardmarbach/rona/envelope"
+	"github.com/richardmarbach/rona/postgres"
+	"github.com/richardmarbach/rona/ronatest"
+)
+
+func TestQuickTestService_Encryption(t *testing.T) {
+	t.Run("encrypt the person at rest", func(t *testing.T) {
+		db := MustOpenDB(t)
+		s := postgres.NewQuickTestService(db)
+		raw := MustOpenRawDB(t, db)
+
+		quicktest := ronatest.MustCreateRegisteredQuickTest(t, s, "Tim Tester")
+
+		var person []byte
+		var keyID string
+		if err := raw.QueryRow(`SELECT person, person_key_id FROM quick_tests WHERE id = $1`, quicktest.ID).Scan(&person, &keyID); err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(person, []byte("Tim Tester")) {
+			t.Errorf("expected the person to be encrypted")
+		}
+		if keyID != "test" {
+			t.Errorf("want key id test, got %v", keyID)
+		}
+
+		if found := ronatest.MustFindQuickTest(t, s, quicktest.ID); found.Person != "Tim Tester" {
+			t.Errorf("want person Tim Tester, got %q", found.Person)
+		}
+	})
+
+	t.Run("reject ciphertexts moved between tests", func(t *testing.T) {
+		db := MustOpenDB(t)
+		s := postgres.NewQuickTestService(db)
+		raw := MustOpenRawDB(t, db)
+
+		tim := ronatest.MustCreateRegisteredQuickTest(t, s, "Tim")
+		jim := ronatest.MustCreateRegisteredQuickTest(t, s, "Jim")
+
+		if _, err := raw.Exec(`
+			UPDATE quick_tests
+			SET (person, person_key_id, person_dek) = (SELECT person, person_key_id, person_dek FROM quick_tests WHERE id = $1)
+			WHERE id = $2
+		`, tim.ID, jim.ID); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := s.FindQuickTestByID(context.Background(), jim.ID); err == nil {
+			t.Errorf("expected an error but didn't get one")
+		}
+	})
+
+	t.Run("scrub the encrypted person on expiry", func(t *testing.T) {
+		db := MustOpenDB(t)
+		s := postgres.NewQuickTestService(db)
+		raw := MustOpenRawDB(t, db)
+
+		quicktest := ronatest.MustCreateRegisteredQuickTest(t, s, "Tim")
+		if err := s.ExpireQuickTest(ronatest.StaffContext(), quicktest.ID); err != nil {
+			t.Fatal(err)
+		}
+
+		var n int
+		if err := raw.QueryRow(`SELECT COUNT(*) FROM quick_tests WHERE person IS NOT NULL OR person_key_id IS NOT NULL OR person_dek IS NOT NULL`).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if n != 0 {
+			t.Errorf("expected the person and data key to be removed")
+		}
+	})
+}
+
+func TestDB_RotateKeys(t *testing.T) {
+	db := MustOpenDB(t)
+	s := postgres.NewQuickTestService(db)
+	raw := MustOpenRawDB(t, db)
+
+	tim := ronatest.MustCreateRegisteredQuickTest(t, s, "Tim")
+	jim := ronatest.MustCreateRegisteredQuickTest(t, s, "Jim")
+	legacy := ronatest.MustCreateRegisteredQuickTest(t, s, "Kim")
+	if _, err := raw.Exec(`
+		UPDATE quick_tests
+		SET person = convert_to('Kim', 'UTF8'), person_key_id = NULL, person_dek = NULL
+		WHERE id = $1
+	`, legacy.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	db.Keyring = MustNewKeyring(t, "rotated", "test")
+
+	ctx := context.Background()
+	n, err := db.RotateKeys(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	} else if n != 3 {
+		t.Errorf("want 3 rotated rows, got %d", n)
+	}
+
+	// The old key is no longer needed.
+	db.Keyring = MustNewKeyring(t, "rotated")
+	for _, quicktest := range []*rona.QuickTest{tim, jim, legacy} {
+		if found := ronatest.MustFindQuickTest(t, s, quicktest.ID); found.Person != quicktest.Person {
+			t.Errorf("want person %v, got %q", quicktest.Person, found.Person)
+		}
+	}
+
+	if n, err := db.RotateKeys(ctx, 2); err != nil {
+		t.Fatal(err)
+	} else if n != 0 {
+		t.Errorf("want nothing left to rotate, got %d", n)
+	}
+}
+
+// testKeys are the keys handed out by MustNewKeyring, so keyrings created
+// by different tests can decrypt each other's data.
+var testKeys = map[string][]byte{}
+
+// MustNewKeyring returns a keyring with the named keys. The first key is
+// the primary key.
+func MustNewKeyring(tb testing.TB, ids ...string) *envelope.Keyring {
+	tb.Helper()
+
+	keys := make(map[string][]byte)
+	for _, id := range ids {
+		if _, ok := testKeys[id]; !ok {
+			testKeys[id] = envelope.NewKey()
+		}
+		keys[id] = testKeys[id]
+	}
+
+	keyring, err := envelope.NewKeyring(ids[0], keys)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return keyring
+}
@@ -0,0 +1,37 @@
+CREATE TABLE quick_tests (
+  id UUID PRIMARY KEY,
+  code TEXT UNIQUE,
+  person TEXT,
+  expired BOOLEAN NOT NULL DEFAULT FALSE,
+  result TEXT NOT NULL DEFAULT 'pending',
+  created_at TIMESTAMPTZ NOT NULL,
+  registered_at TIMESTAMPTZ,
+  resulted_at TIMESTAMPTZ
+);
+
+-- Only index valid registered tests for quick bulk expiration.
+CREATE INDEX po_quick_tests_expired ON quick_tests (registered_at)
+WHERE NOT expired AND registered_at IS NOT NULL;
+
+CREATE TABLE audit_events (
+  id BIGSERIAL PRIMARY KEY,
+  action TEXT NOT NULL,
+  quick_test_id TEXT NOT NULL,
+  actor_id INTEGER,
+  actor_role TEXT,
+  request_id TEXT,
+  outcome TEXT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_audit_events_quick_test_id ON audit_events (quick_test_id, id);
+
+-- The audit log is append-only.
+CREATE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+BEGIN
+  RAISE EXCEPTION 'audit events are append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER audit_events_append_only BEFORE UPDATE OR DELETE ON audit_events
+FOR EACH ROW EXECUTE PROCEDURE audit_events_append_only();
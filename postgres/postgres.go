@@ -0,0 +1,274 @@
+// Package postgres implements the rona services on PostgreSQL. Unlike
+// sqlite, several application instances can share one database.
+//
+// Set RONA_POSTGRES_TEST_DSN to run the tests against a server, e.g.
+// "postgres://localhost/rona_test?sslmode=disable".
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/richardmarbach/rona"
+	"github.com/richardmarbach/rona/envelope"
+)
+
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+// migrationLockID identifies the advisory lock that serializes migrations
+// of instances starting at the same time.
+const migrationLockID = 7_240_521
+
+// DB is the PostgreSQL database.
+type DB struct {
+	db *sql.DB
+
+	DSN string
+
+	// Keyring encrypts the personal data of quick tests.
+	Keyring *envelope.Keyring
+
+	// Clock tells the time of transactions. Defaults to the system clock.
+	Clock rona.Clock
+}
+
+// NewDB creates a new database connection
+func NewDB(dsn string) *DB {
+	return &DB{DSN: dsn, Clock: rona.SystemClock}
+}
+
+// Open a new database connection and run the migrations.
+func (db *DB) Open() (err error) {
+	if db.DSN == "" {
+		return fmt.Errorf("postgres: DSN is required")
+	}
+
+	if db.db, err = sql.Open("postgres", db.DSN); err != nil {
+		return err
+	}
+
+	if err := db.db.Ping(); err != nil {
+		db.db.Close()
+		return err
+	}
+
+	if err := db.migrate(); err != nil {
+		db.db.Close()
+		return fmt.Errorf("migrate: %w", err)
+	}
+	return nil
+}
+
+// migrate runs the migrations that haven't been run yet. Instances
+// starting at the same time take turns through an advisory lock.
+func (db *DB) migrate() error {
+	names, err := fs.Glob(migrationsFS, "migrations/*.sql")
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := db.migrateFile(name); err != nil {
+			return fmt.Errorf("migration error: name=%q error=%v", name, err)
+		}
+	}
+
+	return nil
+}
+
+func (db *DB) migrateFile(name string) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, migrationLockID); err != nil {
+		return err
+	} else if _, err := tx.Exec(`CREATE TABLE IF NOT EXISTS migrations (name TEXT PRIMARY KEY);`); err != nil {
+		return err
+	}
+
+	var n int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM migrations WHERE name = $1`, name).Scan(&n); err != nil {
+		return err
+	} else if n != 0 {
+		// migration already run
+		return nil
+	}
+
+	// Run the migration
+	if buf, err := fs.ReadFile(migrationsFS, name); err != nil {
+		return err
+	} else if _, err := tx.Exec(string(buf)); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`INSERT INTO migrations VALUES ($1)`, name); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Close the database connection.
+func (db *DB) Close() error {
+	if db.db == nil {
+		return nil
+	}
+	return db.db.Close()
+}
+
+// Ping checks that the database is reachable.
+func (db *DB) Ping(ctx context.Context) error {
+	return FormatError(db.db.PingContext(ctx))
+}
+
+// BeginTx starts a new transaction
+func (db *DB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*Tx, error) {
+	tx, err := db.db.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, FormatError(err)
+	}
+
+	return &Tx{
+		Tx:  tx,
+		db:  db,
+		Now: db.Clock.Now().UTC().Truncate(time.Microsecond),
+	}, nil
+}
+
+// Tx wraps sql.Tx and tracks transaction start time
+type Tx struct {
+	*sql.Tx
+	db  *DB
+	Now time.Time
+}
+
+// uniqueViolation is the PostgreSQL error code of unique constraint
+// violations.
+const uniqueViolation = "23505"
+
+// FormatError wraps the postgres error as an application error. Errors
+// that don't map to an application error code are internal errors.
+func FormatError(err error) error {
+	var e *rona.Error
+	if err == nil {
+		return nil
+	} else if errors.As(err, &e) {
+		return err
+	} else if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return rona.WrapError(rona.ECANCELED, err, "operation canceled")
+	} else if errors.Is(err, driver.ErrBadConn) {
+		return rona.WrapError(rona.EUNAVAILABLE, err, "database is unavailable, try again")
+	}
+
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return rona.WrapError(rona.EINTERNAL, err, "database error")
+	}
+
+	switch code := pqErr.Code; {
+	case code == uniqueViolation:
+		return rona.WrapError(rona.ECONFLICT, err, "duplicate record")
+	case code == "57014": // query_canceled
+		return rona.WrapError(rona.ECANCELED, err, "operation canceled")
+	case code.Class() == "22", code.Class() == "23": // data exception, integrity constraint violation
+		return rona.WrapError(rona.EINVALID, err, "invalid record")
+	case code == "40001", code == "40P01", code == "55P03": // serialization failure, deadlock, lock not available
+		return rona.WrapError(rona.EUNAVAILABLE, err, "database is busy, try again")
+	case code.Class() == "08", code.Class() == "53", code == "57P01", code == "57P02", code == "57P03": // connection exception, insufficient resources, shutdown
+		return rona.WrapError(rona.EUNAVAILABLE, err, "database is unavailable, try again")
+	}
+	return rona.WrapError(rona.EINTERNAL, err, "database error")
+}
+
+// isConstraintViolation reports whether err is a unique violation of the
+// named constraint.
+func isConstraintViolation(err error, constraint string) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == uniqueViolation && pqErr.Constraint == constraint
+}
+
+// canonicalID returns the quick test ID in the form it reads back from the
+// database.
+func canonicalID(id rona.QuickTestID) rona.QuickTestID {
+	if u, err := uuid.Parse(string(id)); err == nil {
+		return rona.QuickTestID(u.String())
+	}
+	return id
+}
+
+// NullString maps empty string to nil
+type NullString string
+
+// Scan reads a string value from the database
+func (s *NullString) Scan(value interface{}) error {
+	var v sql.NullString
+	if err := v.Scan(value); err != nil {
+		return fmt.Errorf("NullString: %w", err)
+	}
+	*(*string)(s) = v.String
+	return nil
+}
+
+// Value formats the string for the database.
+func (s *NullString) Value() (driver.Value, error) {
+	if string(*s) == "" {
+		return nil, nil
+	}
+	return string(*s), nil
+}
+
+// NullTime maps the zero time to nil
+type NullTime time.Time
+
+// Scan reads a time value from the database
+func (n *NullTime) Scan(value interface{}) error {
+	var v sql.NullTime
+	if err := v.Scan(value); err != nil {
+		return fmt.Errorf("NullTime: %w", err)
+	}
+	*(*time.Time)(n) = v.Time.UTC()
+	return nil
+}
+
+// Value formats the time for the database.
+func (n *NullTime) Value() (driver.Value, error) {
+	if (*time.Time)(n).IsZero() {
+		return nil, nil
+	}
+	return (*time.Time)(n).UTC(), nil
+}
+
+// NullInt maps zero to nil
+type NullInt int
+
+// Scan reads an integer value from the database
+func (n *NullInt) Scan(value interface{}) error {
+	var v sql.NullInt64
+	if err := v.Scan(value); err != nil {
+		return fmt.Errorf("NullInt: %w", err)
+	}
+	*(*int)(n) = int(v.Int64)
+	return nil
+}
+
+// Value formats the integer for the database.
+func (n *NullInt) Value() (driver.Value, error) {
+	if int(*n) == 0 {
+		return nil, nil
+	}
+	return int64(*n), nil
+}
@@ -0,0 +1,136 @@
+package postgres_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/lib/pq"
+	"github.com/richardmarbach/rona"
+	"github.com/richardmarbach/rona/postgres"
+)
+
+// testDSNEnv names the environment variable with the DSN of the server the
+// tests run against. The tests are skipped when it isn't set.
+const testDSNEnv = "RONA_POSTGRES_TEST_DSN"
+
+func TestDB(t *testing.T) {
+	MustOpenDB(t)
+}
+
+func TestFormatError(t *testing.T) {
+	cases := []struct {
+		message string
+		err     error
+		code    string
+	}{
+		{"unique violation", &pq.Error{Code: "23505"}, rona.ECONFLICT},
+		{"not null violation", &pq.Error{Code: "23502"}, rona.EINVALID},
+		{"invalid text representation", &pq.Error{Code: "22P02"}, rona.EINVALID},
+		{"serialization failure", &pq.Error{Code: "40001"}, rona.EUNAVAILABLE},
+		{"deadlock", &pq.Error{Code: "40P01"}, rona.EUNAVAILABLE},
+		{"connection failure", &pq.Error{Code: "08006"}, rona.EUNAVAILABLE},
+		{"too many connections", &pq.Error{Code: "53300"}, rona.EUNAVAILABLE},
+		{"admin shutdown", &pq.Error{Code: "57P01"}, rona.EUNAVAILABLE},
+		{"bad connection", driver.ErrBadConn, rona.EUNAVAILABLE},
+		{"query canceled", &pq.Error{Code: "57014"}, rona.ECANCELED},
+		{"canceled context", context.Canceled, rona.ECANCELED},
+		{"deadline exceeded", fmt.Errorf("query: %w", context.DeadlineExceeded), rona.ECANCELED},
+		{"undefined table", &pq.Error{Code: "42P01"}, rona.EINTERNAL},
+		{"unknown error", errors.New("boom"), rona.EINTERNAL},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.message, func(t *testing.T) {
+			got := postgres.FormatError(tc.err)
+			if code := rona.ErrorCode(got); code != tc.code {
+				t.Errorf("want code %v, got %v", tc.code, code)
+			}
+			if !errors.Is(got, tc.err) {
+				t.Errorf("expected %v to wrap %v", got, tc.err)
+			}
+		})
+	}
+
+	t.Run("application errors", func(t *testing.T) {
+		err := rona.Errorf(rona.ENOTFOUND, "missing")
+		if got := postgres.FormatError(err); got != err {
+			t.Errorf("want %v, got %v", err, got)
+		}
+	})
+
+	t.Run("nil", func(t *testing.T) {
+		if err := postgres.FormatError(nil); err != nil {
+			t.Errorf("want nil, got %v", err)
+		}
+	})
+}
+
+// MustOpenDB opens a database in a new schema that is dropped when the
+// test ends.
+func MustOpenDB(tb testing.TB) *postgres.DB {
+	tb.Helper()
+
+	dsn := os.Getenv(testDSNEnv)
+	if dsn == "" {
+		tb.Skipf("%s is not set", testDSNEnv)
+	}
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { admin.Close() })
+
+	schema := "rona_test_" + strings.ReplaceAll(string(rona.NewQuickTestID()), "-", "")
+	if _, err := admin.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		tb.Fatalf("failed to create schema: %v", err)
+	}
+	tb.Cleanup(func() {
+		if _, err := admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`); err != nil {
+			tb.Errorf("failed to drop schema: %v", err)
+		}
+	})
+
+	db := postgres.NewDB(withSearchPath(dsn, schema))
+	db.Keyring = MustNewKeyring(tb, "test")
+	if err := db.Open(); err != nil {
+		tb.Fatalf("failed to open db: %v", err)
+	}
+	tb.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			tb.Errorf("failed to close db: %v", err)
+		}
+	})
+
+	return db
+}
+
+// MustOpenRawDB opens a plain connection to the schema of db, which
+// bypasses the services.
+func MustOpenRawDB(tb testing.TB, db *postgres.DB) *sql.DB {
+	tb.Helper()
+
+	raw, err := sql.Open("postgres", db.DSN)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { raw.Close() })
+	return raw
+}
+
+// withSearchPath adds the search_path run-time parameter to a URL or
+// key/value DSN.
+func withSearchPath(dsn, schema string) string {
+	if !strings.HasPrefix(dsn, "postgres://") && !strings.HasPrefix(dsn, "postgresql://") {
+		return fmt.Sprintf("%s search_path=%s", dsn, schema)
+	} else if strings.Contains(dsn, "?") {
+		return dsn + "&search_path=" + schema
+	}
+	return dsn + "?search_path=" + schema
+}
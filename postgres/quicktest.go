@@ -0,0 +1,453 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/richardmarbach/rona"
+	"github.com/richardmarbach/rona/envelope"
+)
+
+var _ rona.QuickTestService = &QuickTestService{}
+
+// QuickTestService manages quick tests in the postgres database.
+type QuickTestService struct {
+	db *DB
+}
+
+// NewQuickTestService creates a new QuickTestService
+func NewQuickTestService(db *DB) *QuickTestService {
+	return &QuickTestService{db: db}
+}
+
+// FindQuickTestByID retrieves a quicktest by id.
+func (s *QuickTestService) FindQuickTestByID(ctx context.Context, id rona.QuickTestID) (*rona.QuickTest, error) {
+	if err := id.Validate(); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	return findQuickTest(ctx, tx, "id", canonicalID(id), false)
+}
+
+// FindQuickTestByCode retrieves a quicktest by its short code.
+func (s *QuickTestService) FindQuickTestByCode(ctx context.Context, code rona.QuickTestCode) (*rona.QuickTest, error) {
+	if err := code.Validate(); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	return findQuickTest(ctx, tx, "code", code, false)
+}
+
+// findQuickTest retrieves the quicktest whose column matches value. column
+// must be a trusted column name. With forUpdate the row stays locked until
+// the transaction ends, which serializes concurrent changes.
+func findQuickTest(ctx context.Context, tx *Tx, column string, value interface{}, forUpdate bool) (*rona.QuickTest, error) {
+	query := `
+		SELECT
+			id,
+			code,
+			person,
+			person_key_id,
+			person_dek,
+			expired,
+			result,
+			created_at,
+			registered_at,
+			resulted_at
+		FROM quick_tests
+		WHERE ` + column + ` = $1`
+	if forUpdate {
+		query += ` FOR UPDATE`
+	}
+
+	var quicktest rona.QuickTest
+	var person envelope.Sealed
+	if err := tx.QueryRowContext(ctx, query, value).Scan(
+		&quicktest.ID,
+		(*NullString)(&quicktest.Code),
+		&person.Ciphertext,
+		(*NullString)(&person.KeyID),
+		&person.DataKey,
+		&quicktest.Expired,
+		&quicktest.Result,
+		(*NullTime)(&quicktest.CreatedAt),
+		(*NullTime)(&quicktest.RegisteredAt),
+		(*NullTime)(&quicktest.ResultedAt),
+	); err == sql.ErrNoRows {
+		return nil, rona.Errorf(rona.ENOTFOUND, "No quick test found for %v", value)
+	} else if err != nil {
+		return nil, FormatError(err)
+	}
+
+	if len(person.Ciphertext) > 0 {
+		keyring, err := tx.db.keyring()
+		if err != nil {
+			return nil, err
+		}
+		if quicktest.Person, err = keyring.Open(quicktest.ID, &person); err != nil {
+			return nil, err
+		}
+	}
+
+	return &quicktest, nil
+}
+
+// CreateQuickTest creates a new quicktest
+func (s *QuickTestService) CreateQuickTest(ctx context.Context, id rona.QuickTestID) (*rona.QuickTest, error) {
+	quicktests, err := s.CreateManyQuickTests(ctx, []rona.QuickTestID{id})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(quicktests) != 1 {
+		return nil, rona.Errorf(rona.EINTERNAL, "expected quick test to be created, but wasn't")
+	}
+	return quicktests[0], nil
+}
+
+// CreateManyQuickTests creates quick tests in batches. Only manufacturers
+// can create quick tests.
+func (s *QuickTestService) CreateManyQuickTests(ctx context.Context, ids []rona.QuickTestID) ([]*rona.QuickTest, error) {
+	quicktests, err := s.createManyQuickTests(ctx, ids)
+	if err != nil {
+		s.recordFailure(ctx, rona.AuditActionCreate, ids, err)
+	}
+	return quicktests, err
+}
+
+func (s *QuickTestService) createManyQuickTests(ctx context.Context, ids []rona.QuickTestID) ([]*rona.QuickTest, error) {
+	if err := rona.RequireRole(ctx, rona.RoleManufacturer); err != nil {
+		return nil, err
+	}
+
+	for _, id := range ids {
+		if err := id.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(ids) == 0 {
+		return []*rona.QuickTest{}, nil
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	quicktests := make([]*rona.QuickTest, 0, len(ids))
+	events := make([]*rona.AuditEvent, 0, len(ids))
+	for _, id := range ids {
+		quicktest := &rona.QuickTest{
+			ID:        canonicalID(id),
+			Result:    rona.QuickTestResultPending,
+			CreatedAt: tx.Now,
+		}
+		quicktests = append(quicktests, quicktest)
+		events = append(events, rona.NewAuditEvent(ctx, rona.AuditActionCreate, quicktest.ID, nil))
+	}
+
+	for start := 0; start < len(quicktests); start += maxInsertRows {
+		end := start + maxInsertRows
+		if end > len(quicktests) {
+			end = len(quicktests)
+		}
+
+		// Short codes are random and may collide with existing codes. A
+		// failed statement aborts the transaction, so each attempt runs
+		// in a savepoint that is rolled back on conflict.
+		for attempt := 1; ; attempt++ {
+			if _, err := tx.ExecContext(ctx, `SAVEPOINT insert_quick_tests`); err != nil {
+				return nil, FormatError(err)
+			}
+
+			err := insertQuickTests(ctx, tx, quicktests[start:end])
+			if err == nil {
+				break
+			} else if !isConstraintViolation(err, "quick_tests_code_key") || attempt == maxCodeAttempts {
+				return nil, FormatError(err)
+			}
+
+			if _, err := tx.ExecContext(ctx, `ROLLBACK TO SAVEPOINT insert_quick_tests`); err != nil {
+				return nil, FormatError(err)
+			}
+		}
+	}
+
+	if err := insertAuditEvents(ctx, tx, events...); err != nil {
+		return nil, err
+	}
+
+	return quicktests, FormatError(tx.Commit())
+}
+
+// maxInsertRows limits the rows of multi-row inserts, so their bind
+// parameters stay below the 65535 parameters postgres accepts.
+const maxInsertRows = 1000
+
+// maxCodeAttempts limits how often new short codes are generated when
+// they collide with existing codes.
+const maxCodeAttempts = 5
+
+// insertQuickTests inserts the quick tests with newly generated codes.
+func insertQuickTests(ctx context.Context, tx *Tx, quicktests []*rona.QuickTest) error {
+	valueStrings := make([]string, 0, len(quicktests))
+	valueArgs := make([]interface{}, 0, len(quicktests)*3)
+	for i, quicktest := range quicktests {
+		quicktest.Code = rona.NewQuickTestCode()
+
+		valueStrings = append(valueStrings, fmt.Sprintf("($%d, $%d, $%d)", i*3+1, i*3+2, i*3+3))
+		valueArgs = append(valueArgs, quicktest.ID)
+		valueArgs = append(valueArgs, quicktest.Code)
+		valueArgs = append(valueArgs, (*NullTime)(&quicktest.CreatedAt))
+	}
+
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO quick_tests (id, code, created_at)
+		VALUES `+strings.Join(valueStrings, ","),
+		valueArgs...,
+	)
+	return err
+}
+
+// RegisterQuickTest registers a new QuickTest
+func (s *QuickTestService) RegisterQuickTest(ctx context.Context, reg *rona.QuickTestRegister) (*rona.QuickTest, error) {
+	quicktest, err := s.registerQuickTest(ctx, reg)
+	if err != nil {
+		s.recordFailure(ctx, rona.AuditActionRegister, []rona.QuickTestID{reg.ID}, err)
+	}
+	return quicktest, err
+}
+
+func (s *QuickTestService) registerQuickTest(ctx context.Context, reg *rona.QuickTestRegister) (*rona.QuickTest, error) {
+	if err := reg.Validate(); err != nil {
+		return nil, err
+	}
+
+	keyring, err := s.db.keyring()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	quicktest, err := findQuickTest(ctx, tx, "id", canonicalID(reg.ID), true)
+	if err != nil {
+		return nil, err
+	}
+
+	if quicktest.Registered() {
+		return nil, rona.Errorf(rona.ECONFLICT, "test has already been registered")
+	} else if quicktest.Expired {
+		return nil, rona.Errorf(rona.EEXPIRED, "test has already expired")
+	}
+
+	// The id read back from the database is canonical, which keeps the
+	// additional data of the encryption independent of how it was written.
+	person, err := keyring.Seal(quicktest.ID, reg.Person)
+	if err != nil {
+		return nil, err
+	}
+
+	quicktest.Person = reg.Person
+	quicktest.RegisteredAt = tx.Now
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE quick_tests
+		SET person = $1,
+			person_key_id = $2,
+			person_dek = $3,
+			registered_at = $4
+		WHERE id = $5
+	`,
+		person.Ciphertext,
+		(*NullString)(&person.KeyID),
+		person.DataKey,
+		(*NullTime)(&quicktest.RegisteredAt),
+		quicktest.ID,
+	); err != nil {
+		return nil, FormatError(err)
+	}
+
+	if err := insertAuditEvents(ctx, tx, rona.NewAuditEvent(ctx, rona.AuditActionRegister, quicktest.ID, nil)); err != nil {
+		return nil, err
+	}
+
+	return quicktest, FormatError(tx.Commit())
+}
+
+// RecordQuickTestResult records the result of a registered QuickTest. Only
+// test center staff can record results.
+func (s *QuickTestService) RecordQuickTestResult(ctx context.Context, rec *rona.QuickTestRecordResult) (*rona.QuickTest, error) {
+	quicktest, err := s.recordQuickTestResult(ctx, rec)
+	if err != nil {
+		s.recordFailure(ctx, rona.AuditActionResult, []rona.QuickTestID{rec.ID}, err)
+	}
+	return quicktest, err
+}
+
+func (s *QuickTestService) recordQuickTestResult(ctx context.Context, rec *rona.QuickTestRecordResult) (*rona.QuickTest, error) {
+	if err := rona.RequireRole(ctx, rona.RoleStaff); err != nil {
+		return nil, err
+	} else if err := rec.Validate(); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	quicktest, err := findQuickTest(ctx, tx, "id", canonicalID(rec.ID), true)
+	if err != nil {
+		return nil, err
+	}
+
+	if quicktest.Expired {
+		return nil, rona.Errorf(rona.EEXPIRED, "test has already expired")
+	} else if !quicktest.Registered() {
+		return nil, rona.Errorf(rona.EINVALID, "test has not been registered")
+	} else if quicktest.Resulted() {
+		return nil, rona.Errorf(rona.ECONFLICT, "test result has already been recorded")
+	}
+
+	quicktest.Result = rec.Result
+	quicktest.ResultedAt = tx.Now
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE quick_tests
+		SET result = $1,
+			resulted_at = $2
+		WHERE id = $3
+	`,
+		quicktest.Result,
+		(*NullTime)(&quicktest.ResultedAt),
+		quicktest.ID,
+	); err != nil {
+		return nil, FormatError(err)
+	}
+
+	if err := insertAuditEvents(ctx, tx, rona.NewAuditEvent(ctx, rona.AuditActionResult, quicktest.ID, nil)); err != nil {
+		return nil, err
+	}
+
+	return quicktest, FormatError(tx.Commit())
+}
+
+// ExpireQuickTest by ID. An expired quicktest removes PII. Only test center
+// staff can expire quick tests.
+func (s *QuickTestService) ExpireQuickTest(ctx context.Context, id rona.QuickTestID) error {
+	err := s.expireQuickTest(ctx, id)
+	if err != nil {
+		s.recordFailure(ctx, rona.AuditActionExpire, []rona.QuickTestID{id}, err)
+	}
+	return err
+}
+
+func (s *QuickTestService) expireQuickTest(ctx context.Context, id rona.QuickTestID) error {
+	if err := rona.RequireRole(ctx, rona.RoleStaff); err != nil {
+		return err
+	} else if err := id.Validate(); err != nil {
+		return err
+	}
+	id = canonicalID(id)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE quick_tests
+		SET expired = TRUE,
+			person = NULL,
+			person_key_id = NULL,
+			person_dek = NULL
+		WHERE id = $1
+	`, id)
+	if err != nil {
+		return FormatError(err)
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return FormatError(err)
+	}
+	if rows != 1 {
+		return rona.Errorf(rona.ENOTFOUND, "quick test does not exist: %v", id)
+	}
+
+	if err := insertAuditEvents(ctx, tx, rona.NewAuditEvent(ctx, rona.AuditActionExpire, id, nil)); err != nil {
+		return err
+	}
+
+	return FormatError(tx.Commit())
+}
+
+// ExpireOutdatedQuickTests expires all quick tests registered after the given duration.
+func (s *QuickTestService) ExpireOutdatedQuickTests(ctx context.Context, d time.Duration) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	cutoff := tx.Now.Add(-d)
+	event := rona.NewAuditEvent(ctx, rona.AuditActionExpire, "", nil)
+
+	var n int
+	if err := tx.QueryRowContext(ctx, `
+		WITH expired AS (
+			UPDATE quick_tests
+			SET expired = TRUE,
+				person = NULL,
+			person_key_id = NULL,
+			person_dek = NULL
+			WHERE
+				NOT expired AND
+				registered_at IS NOT NULL AND
+				registered_at < $1
+			RETURNING id
+		), audited AS (
+			INSERT INTO audit_events (action, quick_test_id, actor_id, actor_role, request_id, outcome, created_at)
+			SELECT $2::text, id::text, $3::integer, $4::text, $5::text, $6::text, $7::timestamptz
+			FROM expired
+			RETURNING 1
+		)
+		SELECT COUNT(*) FROM audited
+	`,
+		cutoff,
+		event.Action,
+		(*NullInt)(&event.ActorID),
+		(*NullString)(&event.ActorRole),
+		(*NullString)(&event.RequestID),
+		event.Outcome,
+		tx.Now,
+	).Scan(&n); err != nil {
+		return 0, FormatError(err)
+	}
+
+	return n, FormatError(tx.Commit())
+}
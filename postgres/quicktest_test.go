@@ -0,0 +1,136 @@
+package postgres_test
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/richardmarbach/rona"
+	"github.com/richardmarbach/rona/postgres"
+	"github.com/richardmarbach/rona/ronatest"
+)
+
+func TestQuickTestService(t *testing.T) {
+	ronatest.TestQuickTestService(t, func(tb testing.TB, clock *ronatest.Clock) rona.QuickTestService {
+		db := MustOpenDB(tb)
+		db.Clock = clock
+		return postgres.NewQuickTestService(db)
+	})
+}
+
+func TestQuickTestService_ExpireOutdatedQuickTests(t *testing.T) {
+	t.Run("record an audit event per expired test", func(t *testing.T) {
+		clock := ronatest.NewClock()
+		db := MustOpenDB(t)
+		db.Clock = clock
+		s := postgres.NewQuickTestService(db)
+
+		clock.Add(-25 * time.Hour)
+		outdated := ronatest.MustCreateRegisteredQuickTest(t, s, "Tim")
+		clock.Add(25 * time.Hour)
+
+		if _, err := s.ExpireOutdatedQuickTests(context.Background(), 24*time.Hour); err != nil {
+			t.Fatal(err)
+		}
+
+		events, _, err := postgres.NewAuditService(db).FindAuditEvents(ronatest.AdminContext(), rona.AuditEventFilter{QuickTestID: &outdated.ID})
+		if err != nil {
+			t.Fatal(err)
+		} else if len(events) != 3 || events[0].Action != rona.AuditActionExpire {
+			t.Errorf("expected an expire audit event, got %+v", events)
+		}
+	})
+}
+
+func TestQuickTestService_CreateManyQuickTests(t *testing.T) {
+	t.Run("audit large batches", func(t *testing.T) {
+		db := MustOpenDB(t)
+		s := postgres.NewQuickTestService(db)
+		ids := make([]rona.QuickTestID, 10000)
+		for i := range ids {
+			ids[i] = rona.NewQuickTestID()
+		}
+
+		if _, err := s.CreateManyQuickTests(ronatest.AdminContext(), ids); err != nil {
+			t.Fatal(err)
+		}
+
+		// The duplicate at the end fails the whole batch, which is
+		// audited as one event.
+		failed := make([]rona.QuickTestID, 0, len(ids)+1)
+		for range ids {
+			failed = append(failed, rona.NewQuickTestID())
+		}
+		failed = append(failed, ids[0])
+		if _, err := s.CreateManyQuickTests(ronatest.AdminContext(), failed); rona.ErrorCode(err) != rona.ECONFLICT {
+			t.Fatalf("expected ECONFLICT, got %v", err)
+		}
+
+		action := rona.AuditActionCreate
+		_, n, err := postgres.NewAuditService(db).FindAuditEvents(ronatest.AdminContext(), rona.AuditEventFilter{Action: &action})
+		if err != nil {
+			t.Fatal(err)
+		} else if want := len(ids) + 1; n != want {
+			t.Errorf("want %d create events, got %d", want, n)
+		}
+	})
+}
+
+func TestQuickTestService_AuditCanonicalIDs(t *testing.T) {
+	db := MustOpenDB(t)
+	s := postgres.NewQuickTestService(db)
+	ctx := ronatest.AdminContext()
+
+	id := rona.NewQuickTestID()
+	upper := rona.QuickTestID(strings.ToUpper(string(id)))
+
+	if _, err := s.CreateQuickTest(ctx, upper); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateQuickTest(ctx, "urn:uuid:"+id); rona.ErrorCode(err) != rona.ECONFLICT {
+		t.Errorf("expected ECONFLICT, got %v", err)
+	}
+	if _, err := s.RegisterQuickTest(ctx, &rona.QuickTestRegister{ID: upper, Person: "Tim"}); err != nil {
+		t.Fatal(err)
+	}
+
+	events, _, err := postgres.NewAuditService(db).FindAuditEvents(ctx, rona.AuditEventFilter{QuickTestID: &upper})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(events) != 3 {
+		t.Errorf("want 3 audit events, got %d", len(events))
+	}
+}
+
+func TestQuickTestService_ConcurrentRegistration(t *testing.T) {
+	s := postgres.NewQuickTestService(MustOpenDB(t))
+	quicktest := ronatest.MustCreateQuickTest(t, s)
+
+	const n = 10
+	errs := make(chan error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := s.RegisterQuickTest(context.Background(), &rona.QuickTestRegister{ID: quicktest.ID, Person: "Tim"})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	var registered int
+	for err := range errs {
+		if err == nil {
+			registered++
+		} else if rona.ErrorCode(err) != rona.ECONFLICT {
+			t.Errorf("expected ECONFLICT, got %v", err)
+		}
+	}
+	if registered != 1 {
+		t.Errorf("want exactly 1 registration, got %d", registered)
+	}
+}
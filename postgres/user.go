@@ -0,0 +1,212 @@
+package postgres
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+
+	"github.com/richardmarbach/rona"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var _ rona.UserService = &UserService{}
+
+// apiKeyPrefix makes API keys recognizable, e.g. in secret scanners. It
+// matches the prefix of the sqlite store.
+const apiKeyPrefix = "rona_"
+
+// UserService manages users in the postgres database.
+type UserService struct {
+	db *DB
+}
+
+// NewUserService creates a new UserService
+func NewUserService(db *DB) *UserService {
+	return &UserService{db: db}
+}
+
+// FindUserByID retrieves a user by id.
+func (s *UserService) FindUserByID(ctx context.Context, id int) (*rona.User, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	user, _, err := findUser(ctx, tx, "id", id)
+	return user, err
+}
+
+// Authenticate a user by email and password.
+func (s *UserService) Authenticate(ctx context.Context, email, password string) (*rona.User, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	user, passwordHash, err := findUser(ctx, tx, "email", strings.ToLower(email))
+	if rona.ErrorCode(err) == rona.ENOTFOUND {
+		return nil, rona.Errorf(rona.EUNAUTHORIZED, "invalid email or password")
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(password)); err != nil {
+		return nil, rona.Errorf(rona.EUNAUTHORIZED, "invalid email or password")
+	}
+	return user, nil
+}
+
+// AuthenticateAPIKey finds the user owning the API key.
+func (s *UserService) AuthenticateAPIKey(ctx context.Context, key string) (*rona.User, error) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return nil, rona.Errorf(rona.EUNAUTHORIZED, "invalid api key")
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	user, _, err := findUser(ctx, tx, "api_key_hash", hashAPIKey(key))
+	if rona.ErrorCode(err) == rona.ENOTFOUND {
+		return nil, rona.Errorf(rona.EUNAUTHORIZED, "invalid api key")
+	}
+	return user, err
+}
+
+// CreateUser creates a new user with a hashed password.
+func (s *UserService) CreateUser(ctx context.Context, create *rona.UserCreate) (*rona.User, error) {
+	if err := rona.RequireRole(ctx, rona.RoleAdmin); err != nil {
+		return nil, err
+	} else if err := create.Validate(); err != nil {
+		return nil, err
+	}
+
+	passwordHash, err := bcrypt.GenerateFromPassword([]byte(create.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	user := &rona.User{
+		Name:      create.Name,
+		Email:     strings.ToLower(create.Email),
+		Role:      create.Role,
+		CreatedAt: tx.Now,
+		UpdatedAt: tx.Now,
+	}
+
+	if err := tx.QueryRowContext(ctx, `
+		INSERT INTO users (name, email, role, password_hash, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id
+	`,
+		user.Name,
+		user.Email,
+		user.Role,
+		string(passwordHash),
+		(*NullTime)(&user.CreatedAt),
+		(*NullTime)(&user.UpdatedAt),
+	).Scan(&user.ID); err != nil {
+		return nil, FormatError(err)
+	}
+
+	return user, FormatError(tx.Commit())
+}
+
+// GenerateAPIKey creates a new API key for the user. Only a hash of the key
+// is stored.
+func (s *UserService) GenerateAPIKey(ctx context.Context, id int) (string, error) {
+	if user := rona.UserFromContext(ctx); user == nil || (user.ID != id && !user.HasRole(rona.RoleAdmin)) {
+		return "", rona.Errorf(rona.EUNAUTHORIZED, "you are not allowed to generate api keys for this user")
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	key := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(buf)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE users
+		SET api_key_hash = $1,
+			updated_at = $2
+		WHERE id = $3
+	`,
+		hashAPIKey(key),
+		(*NullTime)(&tx.Now),
+		id,
+	)
+	if err != nil {
+		return "", FormatError(err)
+	}
+
+	if rows, err := res.RowsAffected(); err != nil {
+		return "", FormatError(err)
+	} else if rows != 1 {
+		return "", rona.Errorf(rona.ENOTFOUND, "user does not exist: %d", id)
+	}
+
+	return key, FormatError(tx.Commit())
+}
+
+// findUser retrieves the user whose column matches value, together with
+// the password hash. column must be a trusted column name.
+func findUser(ctx context.Context, tx *Tx, column string, value interface{}) (*rona.User, string, error) {
+	row := tx.QueryRowContext(ctx, `
+		SELECT
+			id,
+			name,
+			email,
+			role,
+			password_hash,
+			created_at,
+			updated_at
+		FROM users
+		WHERE `+column+` = $1
+		LIMIT 1
+	`, value)
+
+	var user rona.User
+	var passwordHash string
+	if err := row.Scan(
+		&user.ID,
+		&user.Name,
+		&user.Email,
+		&user.Role,
+		&passwordHash,
+		(*NullTime)(&user.CreatedAt),
+		(*NullTime)(&user.UpdatedAt),
+	); err == sql.ErrNoRows {
+		return nil, "", rona.Errorf(rona.ENOTFOUND, "user not found")
+	} else if err != nil {
+		return nil, "", FormatError(err)
+	}
+
+	return &user, passwordHash, nil
+}
+
+// hashAPIKey hashes the API key for storage. API keys have enough entropy
+// that a fast hash is sufficient.
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
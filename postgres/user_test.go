@@ -0,0 +1,15 @@
+package postgres_test
+
+import (
+	"testing"
+
+	"github.com/richardmarbach/rona"
+	"github.com/richardmarbach/rona/postgres"
+	"github.com/richardmarbach/rona/ronatest"
+)
+
+func TestUserService(t *testing.T) {
+	ronatest.TestUserService(t, func(tb testing.TB) rona.UserService {
+		return postgres.NewUserService(MustOpenDB(tb))
+	})
+}
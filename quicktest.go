@@ -12,10 +12,38 @@ const (
 	// Aparantly the offial record for the worlds longest first name is 1000
 	// characters long. Let's just multiply that by 4 and hope parents don't
 	// get it in their head that they need to break that record...
-	QuickTestMaxPersonLen     = 4000
+	QuickTestMaxPersonLen = 4000
+
+	// QuickTestValidityDuration is how long registered tests stay valid
+	// unless configured otherwise.
 	QuickTestValidityDuration = 24 * time.Hour
 )
 
+// QuickTestResult is the outcome shown by a quick test.
+type QuickTestResult string
+
+// Available quick test results.
+const (
+	// QuickTestResultPending is the result of a test until staff record
+	// what the test showed.
+	QuickTestResultPending  QuickTestResult = "pending"
+	QuickTestResultNegative QuickTestResult = "negative"
+	QuickTestResultPositive QuickTestResult = "positive"
+	QuickTestResultInvalid  QuickTestResult = "invalid"
+)
+
+// Validate the result. Only final results are valid, pending can't be
+// recorded.
+func (r QuickTestResult) Validate() error {
+	switch r {
+	case QuickTestResultNegative, QuickTestResultPositive, QuickTestResultInvalid:
+		return nil
+	case "":
+		return Errorf(EINVALID, "result is required")
+	}
+	return Errorf(EINVALID, "invalid result: %q", string(r))
+}
+
 // QuickTestID is a globally unique identifier for each quick test.
 type QuickTestID string
 
@@ -41,13 +69,22 @@ type QuickTest struct {
 	ID      QuickTestID `json:"id"`
 	Expired bool        `json:"expired"`
 
+	// Code is the short code printed next to the ID for people who need
+	// to type or read out the test.
+	Code QuickTestCode `json:"code"`
+
 	// Person is the person's full name that the test is registered to.
 	// Person is empty when the test has not been registered yet, or when
 	// the test has expired.
 	Person string `json:"person,omitempty"`
 
+	// Result is what the test showed. Result is pending until it has been
+	// recorded.
+	Result QuickTestResult `json:"result"`
+
 	CreatedAt    time.Time `json:"created_at"`
 	RegisteredAt time.Time `json:"registered_at,omitempty"`
+	ResultedAt   time.Time `json:"resulted_at,omitempty"`
 }
 
 // Registered checks if the test has been registered
@@ -55,9 +92,15 @@ func (qt *QuickTest) Registered() bool {
 	return !qt.RegisteredAt.IsZero()
 }
 
-// ShouldExpire checks if the test should expire
-func (qt *QuickTest) ShouldExpire() bool {
-	return qt.Registered() && time.Since(qt.RegisteredAt) > QuickTestValidityDuration
+// Resulted checks if a result has been recorded for the test
+func (qt *QuickTest) Resulted() bool {
+	return !qt.ResultedAt.IsZero()
+}
+
+// ShouldExpire checks if the test should expire at the given time, when
+// registered tests stay valid for validity.
+func (qt *QuickTest) ShouldExpire(now time.Time, validity time.Duration) bool {
+	return qt.Registered() && now.Sub(qt.RegisteredAt) > validity
 }
 
 // A QuickTestService interacts with a QuickTest store.
@@ -65,6 +108,10 @@ type QuickTestService interface {
 	// Retrieve a QuickTest by ID.
 	FindQuickTestByID(ctx context.Context, id QuickTestID) (*QuickTest, error)
 
+	// Retrieve a QuickTest by its short code.
+	// Returns ENOTFOUND if the quick test doesn't exist.
+	FindQuickTestByCode(ctx context.Context, code QuickTestCode) (*QuickTest, error)
+
 	// RegisterQuickTest to a given Person.
 	// Returns ENOTFOUND if the quick test doesn't exist.
 	// Returns EINVALID if the quick test fails validation.
@@ -72,23 +119,39 @@ type QuickTestService interface {
 	RegisterQuickTest(ctx context.Context, reg *QuickTestRegister) (*QuickTest, error)
 
 	// CreateQuickTest creates a new QuickTest.
+	// Only manufacturers can create quick tests.
+	// Returns EUNAUTHORIZED if the user isn't allowed to create quick tests.
 	// Returns EINVALID if the QuickTest fails to validate.
 	// Return ECONFLICT if the QuickTest already exists.
 	CreateQuickTest(ctx context.Context, id QuickTestID) (*QuickTest, error)
 
 	// CreatManyQuickTests creates a batch of new QuickTests.
+	// Only manufacturers can create quick tests.
 	//
 	// When validation fails, no QuickTests are created.
+	// Returns EUNAUTHORIZED if the user isn't allowed to create quick tests.
 	// Returns EINVALID if any QuickTest fails to validate.
 	CreateManyQuickTests(ctx context.Context, ids []QuickTestID) ([]*QuickTest, error)
 
+	// RecordQuickTestResult records what a registered QuickTest showed.
+	// Only test center staff can record results.
+	// Returns EUNAUTHORIZED if the user isn't allowed to record results.
+	// Returns ENOTFOUND if the quick test doesn't exist.
+	// Returns EINVALID if the result fails validation or the quick test
+	// hasn't been registered yet.
+	// Returns EEXPIRED if the quick test has expired.
+	// Returns ECONFLICT if a result has already been recorded.
+	RecordQuickTestResult(ctx context.Context, rec *QuickTestRecordResult) (*QuickTest, error)
+
 	// Expire a QuickTest
+	// Only test center staff can expire quick tests.
+	// Returns EUNAUTHORIZED if the user isn't allowed to expire quick tests.
 	// Returns ENOTFOUND when the quick test doesn't exist.
 	ExpireQuickTest(ctx context.Context, id QuickTestID) error
 
 	// ExpireOutdatedQuickTests expires all registered quick tests older
-	// than the given duration.
-	ExpireOutdatedQuickTests(ctx context.Context, d time.Duration) error
+	// than the given duration. Returns the number of expired quick tests.
+	ExpireOutdatedQuickTests(ctx context.Context, d time.Duration) (int, error)
 }
 
 // QuickTestRegister is the set of fields that are needed to register the test.
@@ -108,3 +171,18 @@ func (r *QuickTestRegister) Validate() error {
 	}
 	return nil
 }
+
+// QuickTestRecordResult is the set of fields that are needed to record
+// the result of a test.
+type QuickTestRecordResult struct {
+	ID     QuickTestID
+	Result QuickTestResult
+}
+
+// Validate the fields required for recording a result.
+func (r *QuickTestRecordResult) Validate() error {
+	if err := r.ID.Validate(); err != nil {
+		return err
+	}
+	return r.Result.Validate()
+}
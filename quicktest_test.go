@@ -119,19 +119,26 @@ func TestQuickTest_Registered(t *testing.T) {
 }
 
 func TestQuickTest_ShouldExpire(t *testing.T) {
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	validity := rona.QuickTestValidityDuration
+
 	cases := []struct {
 		message   string
 		qt        *rona.QuickTest
+		validity  time.Duration
 		isExpired bool
 	}{
-		{"expired", &rona.QuickTest{RegisteredAt: time.Now().Add(-rona.QuickTestValidityDuration - 1)}, true},
-		{"not yet expired", &rona.QuickTest{RegisteredAt: time.Now()}, false},
-		{"not yet registered", &rona.QuickTest{}, false},
+		{"expired", &rona.QuickTest{RegisteredAt: now.Add(-validity - 1)}, validity, true},
+		{"exactly at the validity duration", &rona.QuickTest{RegisteredAt: now.Add(-validity)}, validity, false},
+		{"not yet expired", &rona.QuickTest{RegisteredAt: now}, validity, false},
+		{"not yet registered", &rona.QuickTest{}, validity, false},
+		{"expired with a shorter validity", &rona.QuickTest{RegisteredAt: now.Add(-2 * time.Hour)}, time.Hour, true},
+		{"valid with a longer validity", &rona.QuickTest{RegisteredAt: now.Add(-validity - 1)}, 2 * validity, false},
 	}
 
 	for _, tc := range cases {
 		t.Run(tc.message, func(t *testing.T) {
-			isExpired := tc.qt.ShouldExpire()
+			isExpired := tc.qt.ShouldExpire(now, tc.validity)
 
 			if tc.isExpired {
 				if !isExpired {
@@ -145,3 +152,65 @@ func TestQuickTest_ShouldExpire(t *testing.T) {
 		})
 	}
 }
+
+func TestQuickTestRecordResult_Validate(t *testing.T) {
+	cases := []struct {
+		message string
+		rec     *rona.QuickTestRecordResult
+		isValid bool
+	}{
+		{
+			message: "missing id",
+			rec:     &rona.QuickTestRecordResult{Result: rona.QuickTestResultNegative},
+			isValid: false,
+		},
+		{
+			message: "missing result",
+			rec:     &rona.QuickTestRecordResult{ID: rona.NewQuickTestID()},
+			isValid: false,
+		},
+		{
+			message: "pending result",
+			rec:     &rona.QuickTestRecordResult{ID: rona.NewQuickTestID(), Result: rona.QuickTestResultPending},
+			isValid: false,
+		},
+		{
+			message: "unknown result",
+			rec:     &rona.QuickTestRecordResult{ID: rona.NewQuickTestID(), Result: "maybe"},
+			isValid: false,
+		},
+		{
+			message: "negative",
+			rec:     &rona.QuickTestRecordResult{ID: rona.NewQuickTestID(), Result: rona.QuickTestResultNegative},
+			isValid: true,
+		},
+		{
+			message: "positive",
+			rec:     &rona.QuickTestRecordResult{ID: rona.NewQuickTestID(), Result: rona.QuickTestResultPositive},
+			isValid: true,
+		},
+		{
+			message: "invalid",
+			rec:     &rona.QuickTestRecordResult{ID: rona.NewQuickTestID(), Result: rona.QuickTestResultInvalid},
+			isValid: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.message, func(t *testing.T) {
+			err := tc.rec.Validate()
+
+			if tc.isValid {
+				if err != nil {
+					t.Errorf("rec=%#v err=%v", tc.rec, err)
+				}
+			} else {
+				if err == nil {
+					t.Error("expected an error but didn't get one")
+				} else if rona.ErrorCode(err) != rona.EINVALID {
+					t.Errorf("expected EINVALID but got %v", rona.ErrorCode(err))
+				}
+			}
+		})
+	}
+}
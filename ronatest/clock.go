@@ -0,0 +1,39 @@
+package ronatest
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is a rona.Clock for tests that only moves when told to.
+type Clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewClock returns a clock set to the current time. The time is truncated
+// to whole seconds, the precision of the coarsest store.
+func NewClock() *Clock {
+	return &Clock{now: time.Now().UTC().Truncate(time.Second)}
+}
+
+// Now returns the current time of the clock.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Set moves the clock to t.
+func (c *Clock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t
+}
+
+// Add moves the clock by d.
+func (c *Clock) Add(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
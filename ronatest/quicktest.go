@@ -0,0 +1,584 @@
+// Package ronatest provides conformance tests for implementations of the
+// rona services.
+package ronatest
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/richardmarbach/rona"
+)
+
+// QuickTestServiceFactory returns an empty QuickTestService for a test.
+// The service must take the current time from clock.
+type QuickTestServiceFactory func(tb testing.TB, clock *Clock) rona.QuickTestService
+
+// TestQuickTestService checks that the services returned by newService
+// follow the documented contract of rona.QuickTestService.
+func TestQuickTestService(t *testing.T, newService QuickTestServiceFactory) {
+	// setup returns a new service and the clock it uses.
+	setup := func(tb testing.TB) (rona.QuickTestService, *Clock) {
+		clock := NewClock()
+		return newService(tb, clock), clock
+	}
+
+	t.Run("FindQuickTestByID", func(t *testing.T) {
+		t.Run("find a quick test", func(t *testing.T) {
+			s, _ := setup(t)
+			created := MustCreateQuickTest(t, s)
+
+			found, err := s.FindQuickTestByID(AdminContext(), created.ID)
+			assertNoError(t, err)
+
+			if found.ID != created.ID || found.Code != created.Code {
+				t.Errorf("want %v (%v), got %v (%v)", created.ID, created.Code, found.ID, found.Code)
+			}
+			if found.Person != "" || found.Expired || found.Registered() || found.Resulted() {
+				t.Errorf("expected a new quick test, got %+v", found)
+			}
+			if found.Result != rona.QuickTestResultPending {
+				t.Errorf("want result %v, got %v", rona.QuickTestResultPending, found.Result)
+			}
+		})
+
+		t.Run("return ENOTFOUND for unknown tests", func(t *testing.T) {
+			s, _ := setup(t)
+
+			_, err := s.FindQuickTestByID(AdminContext(), rona.NewQuickTestID())
+			assertErrorCode(t, err, rona.ENOTFOUND)
+		})
+	})
+
+	t.Run("FindQuickTestByCode", func(t *testing.T) {
+		t.Run("find a quick test", func(t *testing.T) {
+			s, _ := setup(t)
+			created := MustCreateQuickTest(t, s)
+
+			found, err := s.FindQuickTestByCode(AdminContext(), created.Code)
+			assertNoError(t, err)
+
+			if found.ID != created.ID {
+				t.Errorf("want %v, got %v", created.ID, found.ID)
+			}
+		})
+
+		t.Run("return ENOTFOUND for unknown codes", func(t *testing.T) {
+			s, _ := setup(t)
+
+			_, err := s.FindQuickTestByCode(AdminContext(), rona.NewQuickTestCode())
+			assertErrorCode(t, err, rona.ENOTFOUND)
+		})
+
+		t.Run("return EINVALID for mistyped codes", func(t *testing.T) {
+			s, _ := setup(t)
+			created := MustCreateQuickTest(t, s)
+
+			typo := []byte(created.Code)
+			if typo[0] == '0' {
+				typo[0] = '1'
+			} else {
+				typo[0] = '0'
+			}
+
+			_, err := s.FindQuickTestByCode(AdminContext(), rona.QuickTestCode(typo))
+			assertErrorCode(t, err, rona.EINVALID)
+		})
+	})
+
+	t.Run("CreateQuickTest", func(t *testing.T) {
+		t.Run("create a quick test", func(t *testing.T) {
+			s, clock := setup(t)
+			id := rona.NewQuickTestID()
+
+			created, err := s.CreateQuickTest(ManufacturerContext(), id)
+			assertNoError(t, err)
+
+			if created.ID != id {
+				t.Errorf("want id %v, got %v", id, created.ID)
+			}
+			if err := created.Code.Validate(); err != nil {
+				t.Errorf("expected a valid code: %v", err)
+			}
+			if created.Result != rona.QuickTestResultPending {
+				t.Errorf("want result %v, got %v", rona.QuickTestResultPending, created.Result)
+			}
+			assertTime(t, "CreatedAt", created.CreatedAt, clock.Now())
+			assertTime(t, "stored CreatedAt", MustFindQuickTest(t, s, id).CreatedAt, clock.Now())
+		})
+
+		t.Run("return ECONFLICT for duplicates", func(t *testing.T) {
+			s, _ := setup(t)
+			created := MustCreateQuickTest(t, s)
+
+			_, err := s.CreateQuickTest(ManufacturerContext(), created.ID)
+			assertErrorCode(t, err, rona.ECONFLICT)
+		})
+
+		t.Run("return EINVALID for invalid ids", func(t *testing.T) {
+			s, _ := setup(t)
+
+			_, err := s.CreateQuickTest(ManufacturerContext(), "nope")
+			assertErrorCode(t, err, rona.EINVALID)
+		})
+
+		t.Run("return EUNAUTHORIZED unless a manufacturer", func(t *testing.T) {
+			s, _ := setup(t)
+
+			for _, ctx := range []context.Context{context.Background(), StaffContext()} {
+				_, err := s.CreateQuickTest(ctx, rona.NewQuickTestID())
+				assertErrorCode(t, err, rona.EUNAUTHORIZED)
+			}
+		})
+	})
+
+	t.Run("CreateManyQuickTests", func(t *testing.T) {
+		t.Run("create no quick tests", func(t *testing.T) {
+			s, _ := setup(t)
+
+			created, err := s.CreateManyQuickTests(ManufacturerContext(), []rona.QuickTestID{})
+			assertNoError(t, err)
+
+			if len(created) != 0 {
+				t.Errorf("want no quick tests, got %d", len(created))
+			}
+		})
+
+		t.Run("create all quick tests in order", func(t *testing.T) {
+			s, _ := setup(t)
+			ids := []rona.QuickTestID{rona.NewQuickTestID(), rona.NewQuickTestID(), rona.NewQuickTestID()}
+
+			created, err := s.CreateManyQuickTests(ManufacturerContext(), ids)
+			assertNoError(t, err)
+
+			if len(created) != len(ids) {
+				t.Fatalf("want %d quick tests, got %d", len(ids), len(created))
+			}
+			codes := make(map[rona.QuickTestCode]bool)
+			for i, quicktest := range created {
+				if quicktest.ID != ids[i] {
+					t.Errorf("[%d] want id %v, got %v", i, ids[i], quicktest.ID)
+				}
+				codes[MustFindQuickTest(t, s, ids[i]).Code] = true
+			}
+			if len(codes) != len(ids) {
+				t.Errorf("want %d distinct codes, got %d", len(ids), len(codes))
+			}
+		})
+
+		t.Run("create large batches", func(t *testing.T) {
+			s, _ := setup(t)
+			ids := make([]rona.QuickTestID, 10000)
+			for i := range ids {
+				ids[i] = rona.NewQuickTestID()
+			}
+
+			created, err := s.CreateManyQuickTests(ManufacturerContext(), ids)
+			assertNoError(t, err)
+
+			if len(created) != len(ids) {
+				t.Fatalf("want %d quick tests, got %d", len(ids), len(created))
+			}
+			MustFindQuickTest(t, s, ids[len(ids)-1])
+		})
+
+		t.Run("create nothing when a quick test is invalid", func(t *testing.T) {
+			s, _ := setup(t)
+			id := rona.NewQuickTestID()
+
+			_, err := s.CreateManyQuickTests(ManufacturerContext(), []rona.QuickTestID{id, "nope"})
+			assertErrorCode(t, err, rona.EINVALID)
+
+			_, err = s.FindQuickTestByID(AdminContext(), id)
+			assertErrorCode(t, err, rona.ENOTFOUND)
+		})
+
+		t.Run("create nothing when a quick test exists", func(t *testing.T) {
+			s, _ := setup(t)
+			existing := MustCreateQuickTest(t, s)
+			id := rona.NewQuickTestID()
+
+			_, err := s.CreateManyQuickTests(ManufacturerContext(), []rona.QuickTestID{id, existing.ID})
+			assertErrorCode(t, err, rona.ECONFLICT)
+
+			_, err = s.FindQuickTestByID(AdminContext(), id)
+			assertErrorCode(t, err, rona.ENOTFOUND)
+		})
+
+		t.Run("return EUNAUTHORIZED unless a manufacturer", func(t *testing.T) {
+			s, _ := setup(t)
+
+			_, err := s.CreateManyQuickTests(StaffContext(), []rona.QuickTestID{rona.NewQuickTestID()})
+			assertErrorCode(t, err, rona.EUNAUTHORIZED)
+		})
+	})
+
+	t.Run("RegisterQuickTest", func(t *testing.T) {
+		t.Run("register anonymously", func(t *testing.T) {
+			s, clock := setup(t)
+			created := MustCreateQuickTest(t, s)
+			clock.Add(time.Minute)
+
+			registered, err := s.RegisterQuickTest(context.Background(), &rona.QuickTestRegister{ID: created.ID, Person: "Jimmy Hendricks"})
+			assertNoError(t, err)
+
+			if registered.ID != created.ID || registered.Person != "Jimmy Hendricks" {
+				t.Errorf("expected the test to be registered to Jimmy Hendricks, got %+v", registered)
+			}
+			assertTime(t, "RegisteredAt", registered.RegisteredAt, clock.Now())
+
+			found := MustFindQuickTest(t, s, created.ID)
+			if found.Person != "Jimmy Hendricks" {
+				t.Errorf("want person Jimmy Hendricks, got %q", found.Person)
+			}
+			assertTime(t, "stored RegisteredAt", found.RegisteredAt, clock.Now())
+		})
+
+		t.Run("return ECONFLICT when registered twice", func(t *testing.T) {
+			s, _ := setup(t)
+			registered := MustCreateRegisteredQuickTest(t, s, "Jimmy Hendricks")
+
+			_, err := s.RegisterQuickTest(context.Background(), &rona.QuickTestRegister{ID: registered.ID, Person: "Jimmy Jones"})
+			assertErrorCode(t, err, rona.ECONFLICT)
+
+			if found := MustFindQuickTest(t, s, registered.ID); found.Person != "Jimmy Hendricks" {
+				t.Errorf("want person Jimmy Hendricks, got %q", found.Person)
+			}
+		})
+
+		t.Run("return EEXPIRED for expired tests", func(t *testing.T) {
+			s, _ := setup(t)
+			created := MustCreateQuickTest(t, s)
+			assertNoError(t, s.ExpireQuickTest(StaffContext(), created.ID))
+
+			_, err := s.RegisterQuickTest(context.Background(), &rona.QuickTestRegister{ID: created.ID, Person: "Jimmy Jones"})
+			assertErrorCode(t, err, rona.EEXPIRED)
+		})
+
+		t.Run("return ENOTFOUND for unknown tests", func(t *testing.T) {
+			s, _ := setup(t)
+
+			_, err := s.RegisterQuickTest(context.Background(), &rona.QuickTestRegister{ID: rona.NewQuickTestID(), Person: "Jimmy Jones"})
+			assertErrorCode(t, err, rona.ENOTFOUND)
+		})
+
+		t.Run("return EINVALID without a person", func(t *testing.T) {
+			s, _ := setup(t)
+			created := MustCreateQuickTest(t, s)
+
+			_, err := s.RegisterQuickTest(context.Background(), &rona.QuickTestRegister{ID: created.ID})
+			assertErrorCode(t, err, rona.EINVALID)
+		})
+	})
+
+	t.Run("RecordQuickTestResult", func(t *testing.T) {
+		record := func(s rona.QuickTestService, ctx context.Context, id rona.QuickTestID) (*rona.QuickTest, error) {
+			return s.RecordQuickTestResult(ctx, &rona.QuickTestRecordResult{ID: id, Result: rona.QuickTestResultNegative})
+		}
+
+		t.Run("record a result", func(t *testing.T) {
+			s, clock := setup(t)
+			registered := MustCreateRegisteredQuickTest(t, s, "Jimmy Hendricks")
+			clock.Add(15 * time.Minute)
+
+			resulted, err := record(s, StaffContext(), registered.ID)
+			assertNoError(t, err)
+
+			if resulted.Result != rona.QuickTestResultNegative {
+				t.Errorf("want result %v, got %v", rona.QuickTestResultNegative, resulted.Result)
+			}
+			assertTime(t, "ResultedAt", resulted.ResultedAt, clock.Now())
+
+			found := MustFindQuickTest(t, s, registered.ID)
+			if found.Result != rona.QuickTestResultNegative {
+				t.Errorf("want stored result %v, got %v", rona.QuickTestResultNegative, found.Result)
+			}
+			assertTime(t, "stored ResultedAt", found.ResultedAt, clock.Now())
+		})
+
+		t.Run("return ECONFLICT when recorded twice", func(t *testing.T) {
+			s, _ := setup(t)
+			registered := MustCreateRegisteredQuickTest(t, s, "Jimmy Hendricks")
+
+			_, err := record(s, StaffContext(), registered.ID)
+			assertNoError(t, err)
+			_, err = record(s, StaffContext(), registered.ID)
+			assertErrorCode(t, err, rona.ECONFLICT)
+		})
+
+		t.Run("return EINVALID for unregistered tests", func(t *testing.T) {
+			s, _ := setup(t)
+			created := MustCreateQuickTest(t, s)
+
+			_, err := record(s, StaffContext(), created.ID)
+			assertErrorCode(t, err, rona.EINVALID)
+		})
+
+		t.Run("return EINVALID for pending results", func(t *testing.T) {
+			s, _ := setup(t)
+			registered := MustCreateRegisteredQuickTest(t, s, "Jimmy Hendricks")
+
+			_, err := s.RecordQuickTestResult(StaffContext(), &rona.QuickTestRecordResult{ID: registered.ID, Result: rona.QuickTestResultPending})
+			assertErrorCode(t, err, rona.EINVALID)
+		})
+
+		t.Run("return EEXPIRED for expired tests", func(t *testing.T) {
+			s, _ := setup(t)
+			registered := MustCreateRegisteredQuickTest(t, s, "Jimmy Hendricks")
+			assertNoError(t, s.ExpireQuickTest(StaffContext(), registered.ID))
+
+			_, err := record(s, StaffContext(), registered.ID)
+			assertErrorCode(t, err, rona.EEXPIRED)
+		})
+
+		t.Run("return ENOTFOUND for unknown tests", func(t *testing.T) {
+			s, _ := setup(t)
+
+			_, err := record(s, StaffContext(), rona.NewQuickTestID())
+			assertErrorCode(t, err, rona.ENOTFOUND)
+		})
+
+		t.Run("return EUNAUTHORIZED unless staff", func(t *testing.T) {
+			s, _ := setup(t)
+			registered := MustCreateRegisteredQuickTest(t, s, "Jimmy Hendricks")
+
+			for _, ctx := range []context.Context{context.Background(), ManufacturerContext()} {
+				_, err := record(s, ctx, registered.ID)
+				assertErrorCode(t, err, rona.EUNAUTHORIZED)
+			}
+		})
+	})
+
+	t.Run("ExpireQuickTest", func(t *testing.T) {
+		t.Run("scrub the person", func(t *testing.T) {
+			s, _ := setup(t)
+			registered := MustCreateRegisteredQuickTest(t, s, "Jimmy Hendricks")
+
+			assertNoError(t, s.ExpireQuickTest(StaffContext(), registered.ID))
+
+			AssertScrubbed(t, MustFindQuickTest(t, s, registered.ID))
+		})
+
+		t.Run("expire unregistered tests", func(t *testing.T) {
+			s, _ := setup(t)
+			created := MustCreateQuickTest(t, s)
+
+			assertNoError(t, s.ExpireQuickTest(StaffContext(), created.ID))
+
+			AssertScrubbed(t, MustFindQuickTest(t, s, created.ID))
+		})
+
+		t.Run("return ENOTFOUND for unknown tests", func(t *testing.T) {
+			s, _ := setup(t)
+
+			err := s.ExpireQuickTest(StaffContext(), rona.NewQuickTestID())
+			assertErrorCode(t, err, rona.ENOTFOUND)
+		})
+
+		t.Run("return EUNAUTHORIZED unless staff", func(t *testing.T) {
+			s, _ := setup(t)
+			created := MustCreateQuickTest(t, s)
+
+			for _, ctx := range []context.Context{context.Background(), ManufacturerContext()} {
+				err := s.ExpireQuickTest(ctx, created.ID)
+				assertErrorCode(t, err, rona.EUNAUTHORIZED)
+			}
+		})
+	})
+
+	t.Run("ExpireOutdatedQuickTests", func(t *testing.T) {
+		t.Run("expire tests registered longer than the duration ago", func(t *testing.T) {
+			s, clock := setup(t)
+			start := clock.Now()
+
+			clock.Set(start.Add(-25 * time.Hour))
+			outdated := MustCreateRegisteredQuickTest(t, s, "Tim")
+			clock.Set(start.Add(-23 * time.Hour))
+			valid := MustCreateRegisteredQuickTest(t, s, "Jim")
+			unregistered := MustCreateQuickTest(t, s)
+			clock.Set(start)
+
+			n, err := s.ExpireOutdatedQuickTests(context.Background(), 24*time.Hour)
+			assertNoError(t, err)
+
+			if n != 1 {
+				t.Errorf("want 1 expired quick test, got %d", n)
+			}
+			AssertScrubbed(t, MustFindQuickTest(t, s, outdated.ID))
+			AssertNotScrubbed(t, MustFindQuickTest(t, s, valid.ID))
+			if found := MustFindQuickTest(t, s, unregistered.ID); found.Expired {
+				t.Errorf("expected unregistered tests to stay available")
+			}
+		})
+
+		t.Run("don't count expired tests again", func(t *testing.T) {
+			s, clock := setup(t)
+			start := clock.Now()
+
+			clock.Set(start.Add(-25 * time.Hour))
+			MustCreateRegisteredQuickTest(t, s, "Tim")
+			clock.Set(start)
+
+			n, err := s.ExpireOutdatedQuickTests(context.Background(), 24*time.Hour)
+			assertNoError(t, err)
+			if n != 1 {
+				t.Errorf("want 1 expired quick test, got %d", n)
+			}
+
+			n, err = s.ExpireOutdatedQuickTests(context.Background(), 24*time.Hour)
+			assertNoError(t, err)
+			if n != 0 {
+				t.Errorf("want no expired quick tests, got %d", n)
+			}
+		})
+	})
+
+	t.Run("CanonicalIDs", func(t *testing.T) {
+		t.Run("treat IDs differing in case or format as the same test", func(t *testing.T) {
+			s, _ := setup(t)
+
+			id := rona.NewQuickTestID()
+			upper := rona.QuickTestID(strings.ToUpper(string(id)))
+
+			created, err := s.CreateQuickTest(ManufacturerContext(), upper)
+			assertNoError(t, err)
+			if created.ID != id {
+				t.Errorf("want id %v, got %v", id, created.ID)
+			}
+
+			_, err = s.CreateQuickTest(ManufacturerContext(), id)
+			assertErrorCode(t, err, rona.ECONFLICT)
+			_, err = s.CreateQuickTest(ManufacturerContext(), "urn:uuid:"+id)
+			assertErrorCode(t, err, rona.ECONFLICT)
+
+			registered, err := s.RegisterQuickTest(context.Background(), &rona.QuickTestRegister{ID: upper, Person: "Tim"})
+			assertNoError(t, err)
+			if registered.ID != id || registered.Person != "Tim" {
+				t.Errorf("unexpected quick test %+v", registered)
+			}
+
+			if found := MustFindQuickTest(t, s, upper); found.ID != id || found.Person != "Tim" {
+				t.Errorf("unexpected quick test %+v", found)
+			}
+
+			_, err = s.RecordQuickTestResult(StaffContext(), &rona.QuickTestRecordResult{ID: upper, Result: rona.QuickTestResultNegative})
+			assertNoError(t, err)
+
+			assertNoError(t, s.ExpireQuickTest(StaffContext(), upper))
+			AssertScrubbed(t, MustFindQuickTest(t, s, id))
+		})
+
+		t.Run("reject duplicates within a batch", func(t *testing.T) {
+			s, _ := setup(t)
+
+			id := rona.NewQuickTestID()
+			upper := rona.QuickTestID(strings.ToUpper(string(id)))
+
+			_, err := s.CreateManyQuickTests(ManufacturerContext(), []rona.QuickTestID{id, upper})
+			assertErrorCode(t, err, rona.ECONFLICT)
+		})
+	})
+}
+
+// AdminContext returns a context authenticated as an admin.
+func AdminContext() context.Context {
+	return ContextWithRole(rona.RoleAdmin)
+}
+
+// ManufacturerContext returns a context authenticated as a manufacturer.
+func ManufacturerContext() context.Context {
+	return ContextWithRole(rona.RoleManufacturer)
+}
+
+// StaffContext returns a context authenticated as test center staff.
+func StaffContext() context.Context {
+	return ContextWithRole(rona.RoleStaff)
+}
+
+// ContextWithRole returns a context authenticated as a user with the role.
+func ContextWithRole(role rona.Role) context.Context {
+	return rona.NewContextWithUser(context.Background(), &rona.User{ID: 1, Role: role})
+}
+
+// MustCreateQuickTest creates a quick test as a manufacturer.
+func MustCreateQuickTest(tb testing.TB, s rona.QuickTestService) *rona.QuickTest {
+	tb.Helper()
+
+	quicktest, err := s.CreateQuickTest(ManufacturerContext(), rona.NewQuickTestID())
+	assertNoError(tb, err)
+	return quicktest
+}
+
+// MustCreateRegisteredQuickTest creates a quick test registered to person.
+func MustCreateRegisteredQuickTest(tb testing.TB, s rona.QuickTestService, person string) *rona.QuickTest {
+	tb.Helper()
+
+	quicktest, err := s.RegisterQuickTest(context.Background(), &rona.QuickTestRegister{
+		ID:     MustCreateQuickTest(tb, s).ID,
+		Person: person,
+	})
+	assertNoError(tb, err)
+	return quicktest
+}
+
+// MustFindQuickTest retrieves a quick test as an admin.
+func MustFindQuickTest(tb testing.TB, s rona.QuickTestService, id rona.QuickTestID) *rona.QuickTest {
+	tb.Helper()
+
+	quicktest, err := s.FindQuickTestByID(AdminContext(), id)
+	if err != nil {
+		tb.Fatalf("failed to fetch quick test %v: %v", id, err)
+	}
+	return quicktest
+}
+
+// AssertScrubbed fails the test unless the quick test is expired and no
+// longer holds personal data.
+func AssertScrubbed(tb testing.TB, quicktest *rona.QuickTest) {
+	tb.Helper()
+
+	if !quicktest.Expired {
+		tb.Errorf("expected quicktest to be expired: %v", quicktest)
+	}
+	if quicktest.Person != "" {
+		tb.Errorf("expected quicktest Person to be unset: %v", quicktest.Person)
+	}
+}
+
+// AssertNotScrubbed fails the test unless the quick test is valid and
+// holds its person.
+func AssertNotScrubbed(tb testing.TB, quicktest *rona.QuickTest) {
+	tb.Helper()
+
+	if quicktest.Expired {
+		tb.Errorf("expected quicktest to not be expired: %v", quicktest)
+	}
+	if quicktest.Person == "" {
+		tb.Errorf("expected quicktest Person to be set: %v", quicktest.Person)
+	}
+}
+
+func assertTime(tb testing.TB, name string, got, want time.Time) {
+	tb.Helper()
+	if !got.Equal(want) {
+		tb.Errorf("want %s %v, got %v", name, want, got)
+	}
+}
+
+func assertNoError(tb testing.TB, err error) {
+	tb.Helper()
+	if err != nil {
+		tb.Fatalf("expected no error, got %v", err)
+	}
+}
+
+func assertErrorCode(tb testing.TB, err error, code string) {
+	tb.Helper()
+
+	if err == nil {
+		tb.Errorf("expected an error but didn't get one")
+	} else if rona.ErrorCode(err) != code {
+		tb.Errorf("expected %s, got %v", code, err)
+	}
+}
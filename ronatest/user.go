@@ -0,0 +1,163 @@
+package ronatest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/richardmarbach/rona"
+)
+
+// UserServiceFactory returns an empty UserService for a test.
+type UserServiceFactory func(tb testing.TB) rona.UserService
+
+// TestUserService checks that the services returned by newService follow
+// the documented contract of rona.UserService.
+func TestUserService(t *testing.T, newService UserServiceFactory) {
+	t.Run("CreateUser", func(t *testing.T) {
+		t.Run("create a user", func(t *testing.T) {
+			s := newService(t)
+
+			user := MustCreateUser(t, s, rona.RoleStaff)
+
+			if user.ID == 0 {
+				t.Errorf("expected ID to be set")
+			}
+			if user.CreatedAt.IsZero() {
+				t.Errorf("expected CreatedAt to be set")
+			}
+
+			found, err := s.FindUserByID(AdminContext(), user.ID)
+			assertNoError(t, err)
+			if found.Email != user.Email || found.Role != rona.RoleStaff {
+				t.Errorf("unexpected user: %#v", found)
+			}
+		})
+
+		t.Run("return ECONFLICT when the email is taken", func(t *testing.T) {
+			s := newService(t)
+			user := MustCreateUser(t, s, rona.RoleStaff)
+
+			_, err := s.CreateUser(AdminContext(), &rona.UserCreate{
+				Name:     "Other",
+				Email:    user.Email,
+				Role:     rona.RoleStaff,
+				Password: "password123",
+			})
+			assertErrorCode(t, err, rona.ECONFLICT)
+		})
+
+		t.Run("only admins can create users", func(t *testing.T) {
+			s := newService(t)
+
+			_, err := s.CreateUser(StaffContext(), &rona.UserCreate{
+				Name:     "Jane",
+				Email:    "jane@example.com",
+				Role:     rona.RoleStaff,
+				Password: "password123",
+			})
+			assertErrorCode(t, err, rona.EUNAUTHORIZED)
+		})
+	})
+
+	t.Run("FindUserByID", func(t *testing.T) {
+		t.Run("return ENOTFOUND when there is no such user", func(t *testing.T) {
+			s := newService(t)
+
+			_, err := s.FindUserByID(AdminContext(), 42)
+			assertErrorCode(t, err, rona.ENOTFOUND)
+		})
+	})
+
+	t.Run("Authenticate", func(t *testing.T) {
+		t.Run("authenticate with the correct password", func(t *testing.T) {
+			s := newService(t)
+			user := MustCreateUser(t, s, rona.RoleStaff)
+
+			authenticated, err := s.Authenticate(context.Background(), "JANE@example.com", "password123")
+			assertNoError(t, err)
+
+			if authenticated.ID != user.ID {
+				t.Errorf("want user %d, got %d", user.ID, authenticated.ID)
+			}
+		})
+
+		t.Run("return EUNAUTHORIZED for a wrong password", func(t *testing.T) {
+			s := newService(t)
+			MustCreateUser(t, s, rona.RoleStaff)
+
+			_, err := s.Authenticate(context.Background(), "jane@example.com", "password124")
+			assertErrorCode(t, err, rona.EUNAUTHORIZED)
+		})
+
+		t.Run("return EUNAUTHORIZED for an unknown email", func(t *testing.T) {
+			s := newService(t)
+
+			_, err := s.Authenticate(context.Background(), "nobody@example.com", "password123")
+			assertErrorCode(t, err, rona.EUNAUTHORIZED)
+		})
+	})
+
+	t.Run("APIKey", func(t *testing.T) {
+		t.Run("authenticate with a generated api key", func(t *testing.T) {
+			s := newService(t)
+			user := MustCreateUser(t, s, rona.RoleManufacturer)
+
+			key, err := s.GenerateAPIKey(AdminContext(), user.ID)
+			assertNoError(t, err)
+
+			authenticated, err := s.AuthenticateAPIKey(context.Background(), key)
+			assertNoError(t, err)
+			if authenticated.ID != user.ID {
+				t.Errorf("want user %d, got %d", user.ID, authenticated.ID)
+			}
+		})
+
+		t.Run("replace the previous api key", func(t *testing.T) {
+			s := newService(t)
+			user := MustCreateUser(t, s, rona.RoleManufacturer)
+
+			old, err := s.GenerateAPIKey(AdminContext(), user.ID)
+			assertNoError(t, err)
+			_, err = s.GenerateAPIKey(AdminContext(), user.ID)
+			assertNoError(t, err)
+
+			_, err = s.AuthenticateAPIKey(context.Background(), old)
+			assertErrorCode(t, err, rona.EUNAUTHORIZED)
+		})
+
+		t.Run("users can only generate their own keys", func(t *testing.T) {
+			s := newService(t)
+			user := MustCreateUser(t, s, rona.RoleManufacturer)
+
+			other := rona.NewContextWithUser(context.Background(), &rona.User{ID: user.ID + 1, Role: rona.RoleStaff})
+			_, err := s.GenerateAPIKey(other, user.ID)
+			assertErrorCode(t, err, rona.EUNAUTHORIZED)
+
+			own := rona.NewContextWithUser(context.Background(), user)
+			_, err = s.GenerateAPIKey(own, user.ID)
+			assertNoError(t, err)
+		})
+
+		t.Run("return EUNAUTHORIZED for an unknown api key", func(t *testing.T) {
+			s := newService(t)
+
+			_, err := s.AuthenticateAPIKey(context.Background(), "rona_unknown")
+			assertErrorCode(t, err, rona.EUNAUTHORIZED)
+		})
+	})
+}
+
+// MustCreateUser creates the user jane@example.com with the password
+// "password123" as an admin.
+func MustCreateUser(tb testing.TB, s rona.UserService, role rona.Role) *rona.User {
+	tb.Helper()
+
+	user, err := s.CreateUser(AdminContext(), &rona.UserCreate{
+		Name:     "Jane",
+		Email:    "jane@example.com",
+		Role:     role,
+		Password: "password123",
+	})
+	assertNoError(tb, err)
+	return user
+}
@@ -0,0 +1,97 @@
+// Package scheduler runs periodic background jobs for the daemon.
+package scheduler
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/richardmarbach/rona"
+)
+
+// Expiry metrics
+var (
+	expiryRunsCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "rona_scheduler_expiry_runs_total",
+		Help: "Total number of expiry runs",
+	})
+
+	expiryFailuresCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "rona_scheduler_expiry_failures_total",
+		Help: "Total number of failed expiry runs",
+	})
+
+	expiredCounter = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "rona_scheduler_expired_total",
+		Help: "Total number of tests expired by the scheduler",
+	})
+)
+
+// DefaultExpiryInterval is how often outdated tests are expired by default.
+const DefaultExpiryInterval = time.Minute
+
+// Expirer periodically expires registered quick tests that are older than
+// the validity duration, scrubbing their PII.
+type Expirer struct {
+	QuickTestService rona.QuickTestService
+
+	// Interval between expiry runs.
+	Interval time.Duration
+
+	// Validity is how long a registered test stays valid.
+	Validity time.Duration
+
+	// LogExpired logs runs that expired tests. Failed runs are always
+	// logged.
+	LogExpired bool
+
+	// LogRuns logs every run, also when nothing expired.
+	LogRuns bool
+}
+
+// NewExpirer creates a new Expirer with the default interval and validity.
+func NewExpirer(quickTestService rona.QuickTestService) *Expirer {
+	return &Expirer{
+		QuickTestService: quickTestService,
+		Interval:         DefaultExpiryInterval,
+		Validity:         rona.QuickTestValidityDuration,
+		LogExpired:       true,
+	}
+}
+
+// Run expires outdated tests immediately and then on every interval until
+// ctx is cancelled.
+func (e *Expirer) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.Interval)
+	defer ticker.Stop()
+
+	for {
+		if n, err := e.Expire(ctx); err != nil {
+			log.Printf("scheduler: expiry error: %v", err)
+		} else if e.LogRuns || (e.LogExpired && n > 0) {
+			log.Printf("scheduler: expired %d tests", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Expire runs a single expiry pass and returns the number of expired tests.
+func (e *Expirer) Expire(ctx context.Context) (int, error) {
+	expiryRunsCounter.Inc()
+
+	n, err := e.QuickTestService.ExpireOutdatedQuickTests(ctx, e.Validity)
+	if err != nil {
+		expiryFailuresCounter.Inc()
+		return 0, err
+	}
+
+	expiredCounter.Add(float64(n))
+	return n, nil
+}
@@ -0,0 +1,115 @@
+package scheduler_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/richardmarbach/rona"
+	"github.com/richardmarbach/rona/mock"
+	"github.com/richardmarbach/rona/scheduler"
+)
+
+func TestExpirer_Expire(t *testing.T) {
+	t.Run("expire tests older than the validity duration", func(t *testing.T) {
+		var s mock.QuickTestService
+		var got time.Duration
+		s.ExpireOutdatedQuickTestsFn = func(ctx context.Context, d time.Duration) (int, error) {
+			got = d
+			return 3, nil
+		}
+
+		e := scheduler.NewExpirer(&s)
+		n, err := e.Expire(context.Background())
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+
+		if n != 3 {
+			t.Errorf("want 3 expired tests, got %d", n)
+		}
+		if got != rona.QuickTestValidityDuration {
+			t.Errorf("want validity %v, got %v", rona.QuickTestValidityDuration, got)
+		}
+	})
+
+	t.Run("return the service error", func(t *testing.T) {
+		var s mock.QuickTestService
+		s.ExpireOutdatedQuickTestsFn = func(ctx context.Context, d time.Duration) (int, error) {
+			return 0, errors.New("boom")
+		}
+
+		e := scheduler.NewExpirer(&s)
+		if _, err := e.Expire(context.Background()); err == nil {
+			t.Error("expected an error but didn't get one")
+		}
+	})
+}
+
+func TestExpirer_Run(t *testing.T) {
+	t.Run("run until the context is cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+
+		runs := make(chan struct{}, 10)
+		var s mock.QuickTestService
+		s.ExpireOutdatedQuickTestsFn = func(ctx context.Context, d time.Duration) (int, error) {
+			select {
+			case runs <- struct{}{}:
+			default:
+			}
+			return 0, nil
+		}
+
+		e := scheduler.NewExpirer(&s)
+		e.Interval = time.Millisecond
+
+		done := make(chan struct{})
+		go func() {
+			e.Run(ctx)
+			close(done)
+		}()
+
+		<-runs
+		<-runs
+		cancel()
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("expected Run to return after cancellation")
+		}
+	})
+	t.Run("log runs by level", func(t *testing.T) {
+		var buf bytes.Buffer
+		log.SetOutput(&buf)
+		defer log.SetOutput(os.Stderr)
+
+		var s mock.QuickTestService
+		s.ExpireOutdatedQuickTestsFn = func(ctx context.Context, d time.Duration) (int, error) {
+			return 0, nil
+		}
+
+		run := func(e *scheduler.Expirer) string {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			buf.Reset()
+			e.Run(ctx)
+			return buf.String()
+		}
+
+		e := scheduler.NewExpirer(&s)
+		if out := run(e); out != "" {
+			t.Errorf("expected runs without expired tests to be quiet, got %q", out)
+		}
+
+		e.LogRuns = true
+		if out := run(e); !strings.Contains(out, "expired 0 tests") {
+			t.Errorf("expected every run to be logged, got %q", out)
+		}
+	})
+}
@@ -0,0 +1,212 @@
+package sqlite_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/richardmarbach/rona"
+	"github.com/richardmarbach/rona/ronatest"
+	"github.com/richardmarbach/rona/sqlite"
+)
+
+func TestAuditService_FindAuditEvents(t *testing.T) {
+	t.Run("record every state change", func(t *testing.T) {
+		ctx, s, audit := createAuditServices(t)
+		ctx = rona.NewContextWithRequestID(ctx, "req-1")
+
+		quicktest := MustCreateRegisteredQuickTest(ctx, t, s, "Tim")
+		_, err := s.RecordQuickTestResult(ctx, &rona.QuickTestRecordResult{ID: quicktest.ID, Result: rona.QuickTestResultNegative})
+		assertNoError(t, err)
+		assertNoError(t, s.ExpireQuickTest(ctx, quicktest.ID))
+
+		events, n, err := audit.FindAuditEvents(ctx, rona.AuditEventFilter{QuickTestID: &quicktest.ID})
+		assertNoError(t, err)
+
+		if n != 4 {
+			t.Fatalf("want 4 events, got %d", n)
+		}
+
+		want := []rona.AuditAction{rona.AuditActionExpire, rona.AuditActionResult, rona.AuditActionRegister, rona.AuditActionCreate}
+		for i, event := range events {
+			if event.Action != want[i] {
+				t.Errorf("event %d: want action %v, got %v", i, want[i], event.Action)
+			}
+			if event.ActorID != 1 || event.ActorRole != rona.RoleAdmin {
+				t.Errorf("event %d: unexpected actor %d (%v)", i, event.ActorID, event.ActorRole)
+			}
+			if event.RequestID != "req-1" {
+				t.Errorf("event %d: want request id req-1, got %q", i, event.RequestID)
+			}
+			if event.Outcome != rona.AuditOutcomeSuccess {
+				t.Errorf("event %d: want outcome success, got %v", i, event.Outcome)
+			}
+			if event.CreatedAt.IsZero() {
+				t.Errorf("event %d: expected CreatedAt to be set", i)
+			}
+		}
+	})
+
+	t.Run("record large batches", func(t *testing.T) {
+		ctx, s, audit := createAuditServices(t)
+		ids := make([]rona.QuickTestID, 10000)
+		for i := range ids {
+			ids[i] = rona.NewQuickTestID()
+		}
+
+		_, err := s.CreateManyQuickTests(ctx, ids)
+		assertNoError(t, err)
+
+		// The duplicate at the end fails the whole batch, which is
+		// audited as one event.
+		failed := make([]rona.QuickTestID, 0, len(ids)+1)
+		for range ids {
+			failed = append(failed, rona.NewQuickTestID())
+		}
+		failed = append(failed, ids[0])
+		_, err = s.CreateManyQuickTests(ctx, failed)
+		assertErrorCode(t, err, rona.ECONFLICT)
+
+		action := rona.AuditActionCreate
+		_, n, err := audit.FindAuditEvents(ctx, rona.AuditEventFilter{Action: &action})
+		assertNoError(t, err)
+		if want := len(ids) + 1; n != want {
+			t.Errorf("want %d create events, got %d", want, n)
+		}
+	})
+
+	t.Run("record failed actions", func(t *testing.T) {
+		ctx, s, audit := createAuditServices(t)
+
+		quicktest := MustCreateQuickTest(ctx, t, s)
+		_, err := s.RecordQuickTestResult(contextWithRole(rona.RoleManufacturer), &rona.QuickTestRecordResult{
+			ID:     quicktest.ID,
+			Result: rona.QuickTestResultPositive,
+		})
+		assertErrorCode(t, err, rona.EUNAUTHORIZED)
+
+		action := rona.AuditActionResult
+		events, n, err := audit.FindAuditEvents(ctx, rona.AuditEventFilter{Action: &action})
+		assertNoError(t, err)
+
+		// Unauthorized callers don't get to name tests in the audit log.
+		if n != 1 {
+			t.Fatalf("want 1 event, got %d", n)
+		}
+		if events[0].QuickTestID != "" {
+			t.Errorf("want no quick test, got %v", events[0].QuickTestID)
+		}
+		if events[0].Outcome != rona.EUNAUTHORIZED {
+			t.Errorf("want outcome %v, got %v", rona.EUNAUTHORIZED, events[0].Outcome)
+		}
+		if events[0].ActorID != 2 || events[0].ActorRole != rona.RoleManufacturer {
+			t.Errorf("unexpected actor %d (%v)", events[0].ActorID, events[0].ActorRole)
+		}
+	})
+
+	t.Run("record one event for an unauthorized batch", func(t *testing.T) {
+		ctx, s, audit := createAuditServices(t)
+
+		ids := []rona.QuickTestID{rona.NewQuickTestID(), rona.NewQuickTestID(), "not an id"}
+		_, err := s.CreateManyQuickTests(context.Background(), ids)
+		assertErrorCode(t, err, rona.EUNAUTHORIZED)
+
+		events, n, err := audit.FindAuditEvents(ctx, rona.AuditEventFilter{})
+		assertNoError(t, err)
+
+		if n != 1 {
+			t.Fatalf("want 1 event, got %d", n)
+		}
+		if events[0].QuickTestID != "" || events[0].ActorID != 0 || events[0].Outcome != rona.EUNAUTHORIZED {
+			t.Errorf("unexpected event %+v", events[0])
+		}
+	})
+
+	t.Run("record anonymous registrations and outdated expiry", func(t *testing.T) {
+		ctx, clock := adminContext(), ronatest.NewClock()
+		db := MustOpenDBWithClock(t, clock)
+		s, audit := sqlite.NewQuickTestService(db), sqlite.NewAuditService(db)
+
+		quicktest := MustCreateQuickTest(ctx, t, s)
+		clock.Add(-48 * time.Hour)
+		_, err := s.RegisterQuickTest(context.Background(), &rona.QuickTestRegister{ID: quicktest.ID, Person: "Tim"})
+		clock.Add(48 * time.Hour)
+		assertNoError(t, err)
+
+		n, err := s.ExpireOutdatedQuickTests(context.Background(), rona.QuickTestValidityDuration)
+		assertNoError(t, err)
+		if n != 1 {
+			t.Fatalf("want 1 expired quick test, got %d", n)
+		}
+
+		events, _, err := audit.FindAuditEvents(ctx, rona.AuditEventFilter{QuickTestID: &quicktest.ID, Limit: 2})
+		assertNoError(t, err)
+
+		if len(events) != 2 {
+			t.Fatalf("want 2 events, got %d", len(events))
+		}
+		for _, event := range events[:2] {
+			if event.ActorID != 0 || event.ActorRole != "" {
+				t.Errorf("expected an anonymous actor, got %d (%v)", event.ActorID, event.ActorRole)
+			}
+		}
+		if events[0].Action != rona.AuditActionExpire || events[1].Action != rona.AuditActionRegister {
+			t.Errorf("unexpected actions %v, %v", events[0].Action, events[1].Action)
+		}
+	})
+
+	t.Run("record events under the canonical id", func(t *testing.T) {
+		ctx, s, audit := createAuditServices(t)
+
+		id := rona.NewQuickTestID()
+		upper := rona.QuickTestID(strings.ToUpper(string(id)))
+
+		_, err := s.CreateQuickTest(ctx, upper)
+		assertNoError(t, err)
+		_, err = s.CreateQuickTest(ctx, "urn:uuid:"+id)
+		assertErrorCode(t, err, rona.ECONFLICT)
+		assertNoError(t, s.ExpireQuickTest(ctx, upper))
+
+		events, n, err := audit.FindAuditEvents(ctx, rona.AuditEventFilter{QuickTestID: &upper})
+		assertNoError(t, err)
+
+		if n != 3 {
+			t.Fatalf("want 3 events, got %d", n)
+		}
+		for _, event := range events {
+			if event.QuickTestID != id {
+				t.Errorf("want id %v, got %v", id, event.QuickTestID)
+			}
+		}
+	})
+
+	t.Run("only admins can read the audit log", func(t *testing.T) {
+		_, _, audit := createAuditServices(t)
+
+		_, _, err := audit.FindAuditEvents(contextWithRole(rona.RoleStaff), rona.AuditEventFilter{})
+		assertErrorCode(t, err, rona.EUNAUTHORIZED)
+	})
+}
+
+func TestAuditService_AppendOnly(t *testing.T) {
+	ctx, s, _ := createAuditServices(t)
+	MustCreateQuickTest(ctx, t, s)
+
+	raw := MustOpenRawDB(t)
+
+	if _, err := raw.Exec(`UPDATE audit_events SET outcome = 'forged'`); err == nil {
+		t.Errorf("expected updating audit events to fail")
+	}
+	if _, err := raw.Exec(`DELETE FROM audit_events`); err == nil {
+		t.Errorf("expected deleting audit events to fail")
+	}
+}
+
+// createAuditServices creates a QuickTestService and AuditService sharing
+// a database and a context authenticated as an admin.
+func createAuditServices(tb testing.TB) (context.Context, *sqlite.QuickTestService, *sqlite.AuditService) {
+	tb.Helper()
+	db := MustOpenDB(tb)
+	return adminContext(), sqlite.NewQuickTestService(db), sqlite.NewAuditService(db)
+}
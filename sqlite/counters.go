@@ -0,0 +1,89 @@
+package sqlite
+
+import (
+	"context"
+	"sort"
+)
+
+// countQueries recompute each quick test counter from scratch.
+var countQueries = map[string]string{
+	"total":      `SELECT COUNT(*) FROM quick_tests`,
+	"registered": `SELECT COUNT(*) FROM quick_tests WHERE expired = 0 AND registered_at IS NOT NULL`,
+	"available":  `SELECT COUNT(*) FROM quick_tests WHERE expired = 0 AND registered_at IS NULL`,
+}
+
+// Counter is a quick test counter compared to its recomputed value.
+type Counter struct {
+	Name   string
+	Stored int
+	Actual int
+}
+
+// Drift returns how far the stored value is off from the actual count.
+func (c *Counter) Drift() int {
+	return c.Stored - c.Actual
+}
+
+// ReconcileCounters recomputes the quick test counters by counting every
+// quick test and reports them with their stored values. Drifted counters
+// are corrected unless dryRun is set.
+func (db *DB) ReconcileCounters(ctx context.Context, dryRun bool) ([]*Counter, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	stored, err := findCounters(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	counters := make([]*Counter, 0, len(countQueries))
+	for name, query := range countQueries {
+		counter := &Counter{Name: name, Stored: stored[name]}
+		if err := tx.QueryRowContext(ctx, query).Scan(&counter.Actual); err != nil {
+			return nil, FormatError(err)
+		}
+		counters = append(counters, counter)
+
+		if counter.Drift() == 0 || dryRun {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO quick_test_counters (name, value) VALUES (?, ?)
+			ON CONFLICT (name) DO UPDATE SET value = excluded.value
+		`, name, counter.Actual); err != nil {
+			return nil, FormatError(err)
+		}
+	}
+	sort.Slice(counters, func(i, j int) bool { return counters[i].Name < counters[j].Name })
+
+	if err := tx.Commit(); err != nil {
+		return nil, FormatError(err)
+	}
+	return counters, nil
+}
+
+// findCounters returns the stored quick test counters by name.
+func findCounters(ctx context.Context, tx *Tx) (map[string]int, error) {
+	rows, err := tx.QueryContext(ctx, `SELECT name, value FROM quick_test_counters`)
+	if err != nil {
+		return nil, FormatError(err)
+	}
+	defer rows.Close()
+
+	counters := make(map[string]int)
+	for rows.Next() {
+		var name string
+		var value int
+		if err := rows.Scan(&name, &value); err != nil {
+			return nil, FormatError(err)
+		}
+		counters[name] = value
+	}
+	if err := rows.Err(); err != nil {
+		return nil, FormatError(err)
+	}
+	return counters, nil
+}
@@ -0,0 +1,90 @@
+package sqlite_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/richardmarbach/rona/sqlite"
+)
+
+func TestDB_ReconcileCounters(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("triggers keep the counters in sync", func(t *testing.T) {
+		dsn := filepath.Join(t.TempDir(), "db")
+		db := MustOpenFileDB(t, dsn, false)
+		s := sqlite.NewQuickTestService(db)
+
+		MustCreateQuickTest(adminContext(), t, s)
+		MustCreateRegisteredQuickTest(adminContext(), t, s, "Jane Doe")
+		expired := MustCreateRegisteredQuickTest(adminContext(), t, s, "John Doe")
+		deleted := MustCreateQuickTest(adminContext(), t, s)
+
+		raw := MustOpenRaw(t, dsn)
+		mustExec(t, raw, `UPDATE quick_tests SET expired = 1 WHERE id = ?`, sqlite.UUID(expired.ID))
+		mustExec(t, raw, `DELETE FROM quick_tests WHERE id = ?`, sqlite.UUID(deleted.ID))
+
+		counters, err := db.ReconcileCounters(ctx, true)
+		assertNoError(t, err)
+		assertCounters(t, counters, map[string]int{"available": 1, "registered": 1, "total": 3})
+	})
+
+	t.Run("correct drifted counters", func(t *testing.T) {
+		dsn := filepath.Join(t.TempDir(), "db")
+		db := MustOpenFileDB(t, dsn, false)
+		s := sqlite.NewQuickTestService(db)
+		MustCreateQuickTest(adminContext(), t, s)
+
+		mustExec(t, MustOpenRaw(t, dsn), `UPDATE quick_test_counters SET value = 5 WHERE name = 'total'`)
+
+		counters, err := db.ReconcileCounters(ctx, true)
+		assertNoError(t, err)
+		if counters[2].Name != "total" || counters[2].Drift() != 4 {
+			t.Errorf("want total to drift by 4, got %+v", counters[2])
+		}
+
+		_, err = db.ReconcileCounters(ctx, false)
+		assertNoError(t, err)
+
+		counters, err = db.ReconcileCounters(ctx, true)
+		assertNoError(t, err)
+		assertCounters(t, counters, map[string]int{"available": 1, "registered": 0, "total": 1})
+	})
+
+	t.Run("seed the counters from existing quick tests", func(t *testing.T) {
+		dsn := filepath.Join(t.TempDir(), "db")
+		db := MustOpenFileDB(t, dsn, true)
+		_, err := db.MigrateTo(ctx, 5, false)
+		assertNoError(t, err)
+
+		mustExec(t, MustOpenRaw(t, dsn), `
+			INSERT INTO quick_tests (id, code, created_at, registered_at) VALUES
+			('a', 'A', '2021-01-01T00:00:00Z', NULL),
+			('b', 'B', '2021-01-01T00:00:00Z', '2021-01-02T00:00:00Z')
+		`)
+
+		_, err = db.MigrateUp(ctx, false)
+		assertNoError(t, err)
+
+		counters, err := db.ReconcileCounters(ctx, true)
+		assertNoError(t, err)
+		assertCounters(t, counters, map[string]int{"available": 1, "registered": 1, "total": 2})
+	})
+}
+
+// assertCounters checks that the counters match want and haven't drifted.
+func assertCounters(tb testing.TB, counters []*sqlite.Counter, want map[string]int) {
+	tb.Helper()
+
+	if len(counters) != len(want) {
+		tb.Fatalf("want %d counters, got %d", len(want), len(counters))
+	}
+	for _, counter := range counters {
+		if counter.Drift() != 0 {
+			tb.Errorf("counter %s drifted by %d", counter.Name, counter.Drift())
+		} else if counter.Actual != want[counter.Name] {
+			tb.Errorf("want %s %d, got %d", counter.Name, want[counter.Name], counter.Actual)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/richardmarbach/rona"
+	"github.com/richardmarbach/rona/envelope"
+)
+
+// keyring returns the keyring of the database or an error when encryption
+// isn't configured.
+func (db *DB) keyring() (*envelope.Keyring, error) {
+	if db.Keyring == nil {
+		return nil, rona.Errorf(rona.EINTERNAL, "no encryption keyring configured")
+	}
+	return db.Keyring, nil
+}
+
+// RotateKeys re-wraps the data keys of all persons that aren't protected by
+// the primary key, and encrypts persons stored before encryption was
+// introduced. Rows are updated in batches of batchSize, each in its own
+// transaction, so rotation can be interrupted and resumed. Returns the
+// number of rows updated.
+func (db *DB) RotateKeys(ctx context.Context, batchSize int) (int, error) {
+	keyring, err := db.keyring()
+	if err != nil {
+		return 0, err
+	} else if batchSize <= 0 {
+		return 0, fmt.Errorf("batch size must be positive")
+	}
+
+	var total int
+	for {
+		n, err := db.rotateKeysBatch(ctx, keyring, batchSize)
+		total += n
+		if err != nil {
+			return total, err
+		} else if n < batchSize {
+			return total, nil
+		}
+	}
+}
+
+// rotateKeysBatch re-wraps up to batchSize rows.
+func (db *DB) rotateKeysBatch(ctx context.Context, keyring *envelope.Keyring, batchSize int) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, person, person_key_id, person_dek
+		FROM quick_tests
+		WHERE
+			person IS NOT NULL AND
+			person != '' AND
+			(person_key_id IS NULL OR person_key_id != ?)
+		LIMIT ?
+	`, keyring.PrimaryKeyID(), batchSize)
+	if err != nil {
+		return 0, FormatError(err)
+	}
+
+	type row struct {
+		id     rona.QuickTestID
+		sealed envelope.Sealed
+	}
+	var batch []*row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan((*UUID)(&r.id), &r.sealed.Ciphertext, (*NullString)(&r.sealed.KeyID), &r.sealed.DataKey); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		batch = append(batch, &r)
+	}
+	if err := rows.Close(); err != nil {
+		return 0, err
+	} else if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, r := range batch {
+		if err := keyring.Rewrap(r.id, &r.sealed); err != nil {
+			return 0, err
+		}
+
+		if _, err := tx.ExecContext(ctx, `
+			UPDATE quick_tests
+			SET person = ?,
+				person_key_id = ?,
+				person_dek = ?
+			WHERE id = ?
+		`,
+			r.sealed.Ciphertext,
+			(*NullString)(&r.sealed.KeyID),
+			r.sealed.DataKey,
+			UUID(r.id),
+		); err != nil {
+			return 0, FormatError(err)
+		}
+	}
+
+	return len(batch), tx.Commit()
+}
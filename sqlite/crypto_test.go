@@ -0,0 +1,142 @@
+package sqlite_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/richardmarbach/rona"
+	"github.com/richardmarbach/rona/envelope"
+	"github.com/richardmarbach/rona/sqlite"
+)
+
+func TestQuickTestService_Encryption(t *testing.T) {
+	t.Run("encrypt the person at rest", func(t *testing.T) {
+		ctx, s := createService(t)
+		raw := MustOpenRawDB(t)
+
+		quicktest := MustCreateRegisteredQuickTest(ctx, t, s, "Tim Tester")
+
+		var person []byte
+		var keyID string
+		if err := raw.QueryRow(`SELECT person, person_key_id FROM quick_tests WHERE id = ?`, sqlite.UUID(quicktest.ID)).Scan(&person, &keyID); err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(person, []byte("Tim Tester")) {
+			t.Errorf("expected the person to be encrypted")
+		}
+		if keyID != "test" {
+			t.Errorf("want key id test, got %v", keyID)
+		}
+
+		if found := MustFindQuickTest(ctx, t, s, quicktest.ID); found.Person != "Tim Tester" {
+			t.Errorf("want person Tim Tester, got %q", found.Person)
+		}
+	})
+
+	t.Run("reject ciphertexts moved between tests", func(t *testing.T) {
+		ctx, s := createService(t)
+		raw := MustOpenRawDB(t)
+
+		tim := MustCreateRegisteredQuickTest(ctx, t, s, "Tim")
+		jim := MustCreateRegisteredQuickTest(ctx, t, s, "Jim")
+
+		if _, err := raw.Exec(`
+			UPDATE quick_tests
+			SET (person, person_key_id, person_dek) = (SELECT person, person_key_id, person_dek FROM quick_tests WHERE id = ?)
+			WHERE id = ?
+		`, sqlite.UUID(tim.ID), sqlite.UUID(jim.ID)); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := s.FindQuickTestByID(ctx, jim.ID); err == nil {
+			t.Errorf("expected an error but didn't get one")
+		}
+	})
+
+	t.Run("scrub the encrypted person on expiry", func(t *testing.T) {
+		ctx, s := createService(t)
+		raw := MustOpenRawDB(t)
+
+		quicktest := MustCreateRegisteredQuickTest(ctx, t, s, "Tim")
+		assertNoError(t, s.ExpireQuickTest(ctx, quicktest.ID))
+
+		var n int
+		if err := raw.QueryRow(`SELECT COUNT(*) FROM quick_tests WHERE person_key_id IS NOT NULL OR person_dek IS NOT NULL`).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if n != 0 {
+			t.Errorf("expected the data key to be removed")
+		}
+	})
+}
+
+func TestDB_RotateKeys(t *testing.T) {
+	ctx, db := adminContext(), MustOpenDB(t)
+	s := sqlite.NewQuickTestService(db)
+	raw := MustOpenRawDB(t)
+
+	tim := MustCreateRegisteredQuickTest(ctx, t, s, "Tim")
+	jim := MustCreateRegisteredQuickTest(ctx, t, s, "Jim")
+	legacy := MustCreateRegisteredQuickTest(ctx, t, s, "Kim")
+	if _, err := raw.Exec(`
+		UPDATE quick_tests
+		SET person = 'Kim', person_key_id = NULL, person_dek = NULL
+		WHERE id = ?
+	`, sqlite.UUID(legacy.ID)); err != nil {
+		t.Fatal(err)
+	}
+
+	db.Keyring = MustNewKeyring(t, "rotated", "test")
+
+	n, err := db.RotateKeys(ctx, 2)
+	assertNoError(t, err)
+	if n != 3 {
+		t.Errorf("want 3 rotated rows, got %d", n)
+	}
+
+	var stale int
+	if err := raw.QueryRow(`SELECT COUNT(*) FROM quick_tests WHERE person_key_id != 'rotated' OR person_key_id IS NULL`).Scan(&stale); err != nil {
+		t.Fatal(err)
+	}
+	if stale != 0 {
+		t.Errorf("want all rows rotated, got %d stale", stale)
+	}
+
+	// The old key is no longer needed.
+	db.Keyring = MustNewKeyring(t, "rotated")
+	for _, quicktest := range []*rona.QuickTest{tim, jim, legacy} {
+		if found := MustFindQuickTest(ctx, t, s, quicktest.ID); found.Person != quicktest.Person {
+			t.Errorf("want person %v, got %q", quicktest.Person, found.Person)
+		}
+	}
+
+	n, err = db.RotateKeys(ctx, 2)
+	assertNoError(t, err)
+	if n != 0 {
+		t.Errorf("want nothing left to rotate, got %d", n)
+	}
+}
+
+// testKeys are the keys handed out by MustNewKeyring, so keyrings created
+// by different tests can decrypt each other's data.
+var testKeys = map[string][]byte{}
+
+// MustNewKeyring returns a keyring with the named keys. The first key is
+// the primary key.
+func MustNewKeyring(tb testing.TB, ids ...string) *envelope.Keyring {
+	tb.Helper()
+
+	keys := make(map[string][]byte)
+	for _, id := range ids {
+		if _, ok := testKeys[id]; !ok {
+			testKeys[id] = envelope.NewKey()
+		}
+		keys[id] = testKeys[id]
+	}
+
+	keyring, err := envelope.NewKeyring(ids[0], keys)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return keyring
+}
@@ -0,0 +1,31 @@
+//go:build !purego
+// +build !purego
+
+package sqlite
+
+import (
+	"errors"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// DriverName is the database/sql driver that opens the database. The
+// default driver wraps the SQLite C library and needs cgo.
+const DriverName = "sqlite3"
+
+// withConnectionParams configures every connection of the pool. Writers
+// wait for the write lock instead of failing with SQLITE_BUSY, and
+// secure_delete overwrites deleted content with zeros instead of leaving
+// it in free pages.
+func withConnectionParams(dsn string) string {
+	return withParam(dsn, "_busy_timeout=5000&_secure_delete=on")
+}
+
+// errorCode returns the extended SQLite result code of a driver error.
+func errorCode(err error) (int, bool) {
+	var e sqlite3.Error
+	if errors.As(err, &e) {
+		return int(e.ExtendedCode), true
+	}
+	return 0, false
+}
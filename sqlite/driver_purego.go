@@ -0,0 +1,31 @@
+//go:build purego
+// +build purego
+
+package sqlite
+
+import (
+	"errors"
+
+	moderncsqlite "modernc.org/sqlite"
+)
+
+// DriverName is the database/sql driver that opens the database. Builds
+// with the purego tag use a Go translation of SQLite that doesn't need cgo.
+const DriverName = "sqlite"
+
+// withConnectionParams configures every connection of the pool. Writers
+// wait for the write lock instead of failing with SQLITE_BUSY, and
+// secure_delete overwrites deleted content with zeros instead of leaving
+// it in free pages.
+func withConnectionParams(dsn string) string {
+	return withParam(dsn, "_pragma=busy_timeout(5000)&_pragma=secure_delete(1)")
+}
+
+// errorCode returns the extended SQLite result code of a driver error.
+func errorCode(err error) (int, bool) {
+	var e *moderncsqlite.Error
+	if errors.As(err, &e) {
+		return e.Code(), true
+	}
+	return 0, false
+}
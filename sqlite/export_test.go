@@ -0,0 +1,7 @@
+package sqlite
+
+// Queries checked by the query plan tests.
+var (
+	ExpireAuditQuery      = expireAuditQuery
+	ExpireQuickTestsQuery = expireQuickTestsQuery
+)
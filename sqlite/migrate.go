@@ -0,0 +1,498 @@
+package sqlite
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"database/sql/driver"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/richardmarbach/rona/envelope"
+)
+
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+// migrationPattern matches migration file names such as
+// 00000003_users.up.sql.
+var migrationPattern = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Migration is a schema change with its up and down SQL.
+type Migration struct {
+	Version int
+	Name    string
+
+	// Checksum is the SHA-256 of the up SQL. It detects migrations that
+	// were edited after they were applied.
+	Checksum string
+
+	UpSQL   string
+	DownSQL string
+}
+
+// MigrationStatus is the state of a migration in the database.
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt time.Time
+
+	// Unknown is set for applied migrations this binary doesn't know,
+	// which means the schema is ahead of the binary.
+	Unknown bool
+
+	// Modified is set when the applied migration differs from the
+	// migration of this binary.
+	Modified bool
+}
+
+// MigrationStep is a migration applied in a direction.
+type MigrationStep struct {
+	*Migration
+	Down bool
+}
+
+// SQL returns the statements run by the step.
+func (s *MigrationStep) SQL() string {
+	if s.Down {
+		return s.DownSQL
+	}
+	return s.UpSQL
+}
+
+// String returns a description of the step.
+func (s *MigrationStep) String() string {
+	direction := "up"
+	if s.Down {
+		direction = "down"
+	}
+	return fmt.Sprintf("%s %08d_%s", direction, s.Version, s.Name)
+}
+
+// Migrations returns the migrations embedded in the binary, ordered by
+// version.
+func Migrations() ([]*Migration, error) {
+	names, err := fs.Glob(migrationsFS, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, name := range names {
+		m := migrationPattern.FindStringSubmatch(path.Base(name))
+		if m == nil {
+			return nil, fmt.Errorf("invalid migration file name: %s", name)
+		}
+
+		version, err := strconv.Atoi(m[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration file name: %s", name)
+		}
+
+		migration := byVersion[version]
+		if migration == nil {
+			migration = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = migration
+		} else if migration.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has conflicting names %q and %q", version, migration.Name, m[2])
+		}
+
+		buf, err := fs.ReadFile(migrationsFS, name)
+		if err != nil {
+			return nil, err
+		}
+		if m[3] == "up" {
+			sum := sha256.Sum256(buf)
+			migration.UpSQL = string(buf)
+			migration.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			migration.DownSQL = string(buf)
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.UpSQL == "" || migration.DownSQL == "" {
+			return nil, fmt.Errorf("migration %d needs an up and a down file", migration.Version)
+		}
+		migrations = append(migrations, migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// appliedMigration is a row of the migrations table.
+type appliedMigration struct {
+	version   int
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+// migrate applies all pending migrations. It refuses to run when the
+// schema is ahead of the binary or applied migrations were modified.
+func (db *DB) migrate() error {
+	_, err := db.MigrateUp(context.Background(), false)
+	return err
+}
+
+// MigrationStatus returns the state of all known and applied migrations.
+// It doesn't change the database.
+func (db *DB) MigrationStatus(ctx context.Context) ([]*MigrationStatus, error) {
+	migrations, applied, err := db.readMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]*MigrationStatus, 0, len(migrations))
+	for _, migration := range migrations {
+		status := &MigrationStatus{Version: migration.Version, Name: migration.Name}
+		if a, ok := applied[migration.Version]; ok {
+			status.Applied = true
+			status.AppliedAt = a.appliedAt
+			status.Modified = a.checksum != migration.Checksum
+			delete(applied, migration.Version)
+		}
+		statuses = append(statuses, status)
+	}
+
+	for _, a := range applied {
+		statuses = append(statuses, &MigrationStatus{
+			Version:   a.version,
+			Name:      a.name,
+			Applied:   true,
+			AppliedAt: a.appliedAt,
+			Unknown:   true,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+
+	return statuses, nil
+}
+
+// MigrateUp applies all pending migrations.
+func (db *DB) MigrateUp(ctx context.Context, dryRun bool) ([]*MigrationStep, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	return db.MigrateTo(ctx, migrations[len(migrations)-1].Version, dryRun)
+}
+
+// MigrateDown reverts the given number of applied migrations.
+func (db *DB) MigrateDown(ctx context.Context, steps int, dryRun bool) ([]*MigrationStep, error) {
+	if steps < 1 {
+		return nil, fmt.Errorf("at least one migration must be reverted")
+	}
+
+	_, applied, err := db.readMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]int, 0, len(applied))
+	for version := range applied {
+		versions = append(versions, version)
+	}
+	sort.Ints(versions)
+
+	// Reverting everything leaves no version applied.
+	target := -1
+	if steps < len(versions) {
+		target = versions[len(versions)-steps-1]
+	}
+	return db.MigrateTo(ctx, target, dryRun)
+}
+
+// MigrateTo applies or reverts migrations until version is the latest
+// applied migration. A version of -1 reverts all migrations. Each
+// migration runs in its own transaction. A dry run runs all steps in a
+// single transaction that is rolled back, so it doesn't even create the
+// migrations table.
+func (db *DB) MigrateTo(ctx context.Context, version int, dryRun bool) ([]*MigrationStep, error) {
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	migrations, applied, err := db.loadMigrations(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkMigrations(migrations, applied); err != nil {
+		return nil, err
+	}
+
+	if version != -1 {
+		known := false
+		for _, migration := range migrations {
+			known = known || migration.Version == version
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown migration version %d", version)
+		}
+	}
+
+	var steps []*MigrationStep
+	for i := len(migrations) - 1; i >= 0; i-- {
+		if _, ok := applied[migrations[i].Version]; ok && migrations[i].Version > version {
+			steps = append(steps, &MigrationStep{Migration: migrations[i], Down: true})
+		}
+	}
+	for _, migration := range migrations {
+		if _, ok := applied[migration.Version]; !ok && migration.Version <= version {
+			steps = append(steps, &MigrationStep{Migration: migration})
+		}
+	}
+
+	// Running all steps and rolling them back reports errors without
+	// changing the database.
+	if dryRun {
+		for _, step := range steps {
+			if err := db.applyMigrationStep(ctx, tx, step); err != nil {
+				return steps, err
+			}
+		}
+		return steps, nil
+	}
+
+	// Keep the created or converted migrations table.
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	for _, step := range steps {
+		if err := db.runMigrationStep(ctx, step); err != nil {
+			return nil, err
+		}
+	}
+	return steps, nil
+}
+
+// checkMigrations returns an error when the applied migrations don't match
+// the migrations of the binary.
+func checkMigrations(migrations []*Migration, applied map[int]*appliedMigration) error {
+	known := make(map[int]*Migration, len(migrations))
+	for _, migration := range migrations {
+		known[migration.Version] = migration
+	}
+
+	for _, a := range applied {
+		if migration, ok := known[a.version]; !ok {
+			return fmt.Errorf("database schema is ahead of this binary: unknown migration %08d_%s was applied", a.version, a.name)
+		} else if migration.Checksum != a.checksum {
+			return fmt.Errorf("migration %08d_%s was modified after it was applied", a.version, a.name)
+		}
+	}
+	return nil
+}
+
+// runMigrationStep runs the step in its own transaction.
+func (db *DB) runMigrationStep(ctx context.Context, step *MigrationStep) error {
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := db.applyMigrationStep(ctx, tx, step); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// dataMigrations convert data that SQL can't after the up migration of
+// their version.
+var dataMigrations = map[int]func(ctx context.Context, tx *sql.Tx, keyring *envelope.Keyring) error{
+	8: convertQuickTestIDs,
+}
+
+// applyMigrationStep runs the SQL of the step and its data migration, and
+// records it in the migrations table as applied now.
+func (db *DB) applyMigrationStep(ctx context.Context, tx *sql.Tx, step *MigrationStep) error {
+	if _, err := tx.ExecContext(ctx, step.SQL()); err != nil {
+		return fmt.Errorf("migration %s: %w", step, err)
+	}
+
+	if step.Down {
+		_, err := tx.ExecContext(ctx, `DELETE FROM migrations WHERE version = ?`, step.Version)
+		return err
+	}
+
+	if migrate := dataMigrations[step.Version]; migrate != nil {
+		if err := migrate(ctx, tx, db.Keyring); err != nil {
+			return fmt.Errorf("migration %s: %w", step, err)
+		}
+	}
+
+	now := db.Clock.Now()
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO migrations (version, name, checksum, applied_at)
+		VALUES (?, ?, ?, ?)
+	`, step.Version, step.Name, step.Checksum, (*rfc3339Time)(&now))
+	return err
+}
+
+// readMigrations loads the migrations without changing the database.
+func (db *DB) readMigrations(ctx context.Context) ([]*Migration, map[int]*appliedMigration, error) {
+	tx, err := db.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	return db.loadMigrations(ctx, tx)
+}
+
+// loadMigrations returns the migrations of the binary and the migrations
+// applied to the database by version. The migrations table is created
+// within tx, so it is only kept if tx is committed.
+func (db *DB) loadMigrations(ctx context.Context, tx *sql.Tx) ([]*Migration, map[int]*appliedMigration, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := db.createMigrationsTable(ctx, tx, migrations); err != nil {
+		return nil, nil, err
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT version, name, checksum, applied_at FROM migrations`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]*appliedMigration)
+	for rows.Next() {
+		var a appliedMigration
+		if err := rows.Scan(&a.version, &a.name, &a.checksum, (*rfc3339Time)(&a.appliedAt)); err != nil {
+			return nil, nil, err
+		}
+		applied[a.version] = &a
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return migrations, applied, nil
+}
+
+// legacyMigrationPattern matches the names recorded before migrations
+// were versioned, such as migrations/00000003.sql.
+var legacyMigrationPattern = regexp.MustCompile(`^migrations/(\d+)\.sql$`)
+
+// createMigrationsTable creates the migrations table. Databases that only
+// tracked migration file names are converted, assuming the recorded
+// migrations match the ones of this binary.
+func (db *DB) createMigrationsTable(ctx context.Context, tx *sql.Tx, migrations []*Migration) error {
+	var legacy int
+	if err := tx.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM pragma_table_info('migrations') WHERE name = 'name' AND pk = 1
+	`).Scan(&legacy); err != nil {
+		return err
+	}
+
+	if legacy != 0 {
+		if _, err := tx.ExecContext(ctx, `ALTER TABLE migrations RENAME TO legacy_migrations`); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			checksum TEXT NOT NULL,
+			applied_at TEXT NOT NULL
+		)
+	`); err != nil {
+		return err
+	}
+
+	if legacy != 0 {
+		if err := convertLegacyMigrations(ctx, tx, migrations, db.Clock.Now()); err != nil {
+			return fmt.Errorf("convert migrations table: %w", err)
+		}
+	}
+	return nil
+}
+
+// convertLegacyMigrations copies the legacy migration names into the
+// migrations table, recording them as applied at now.
+func convertLegacyMigrations(ctx context.Context, tx *sql.Tx, migrations []*Migration, now time.Time) error {
+	rows, err := tx.QueryContext(ctx, `SELECT name FROM legacy_migrations`)
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		names = append(names, name)
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		m := legacyMigrationPattern.FindStringSubmatch(name)
+		if m == nil {
+			return fmt.Errorf("unknown legacy migration %q", name)
+		}
+		version, _ := strconv.Atoi(m[1])
+
+		var migration *Migration
+		for _, mig := range migrations {
+			if mig.Version == version {
+				migration = mig
+			}
+		}
+		if migration == nil {
+			return fmt.Errorf("unknown legacy migration %q", name)
+		}
+
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO migrations (version, name, checksum, applied_at)
+			VALUES (?, ?, ?, ?)
+		`, migration.Version, migration.Name, migration.Checksum, (*rfc3339Time)(&now)); err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `DROP TABLE legacy_migrations`)
+	return err
+}
+
+// rfc3339Time encodes the applied times of migrations as RFC3339 text. The
+// migrations table keeps its format across migrations, so it doesn't
+// follow the NullTime encoding of the schema.
+type rfc3339Time time.Time
+
+// Scan reads a time.Time from the database string
+func (t *rfc3339Time) Scan(value interface{}) (err error) {
+	if value, ok := value.(string); ok {
+		*(*time.Time)(t), err = time.Parse(time.RFC3339, value)
+		return err
+	}
+	return fmt.Errorf("rfc3339Time: cannot scan time.Time: %T", value)
+}
+
+// Value encodes a time.Time as a database string
+func (t *rfc3339Time) Value() (driver.Value, error) {
+	return (*time.Time)(t).UTC().Format(time.RFC3339), nil
+}
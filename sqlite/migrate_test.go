@@ -0,0 +1,297 @@
+package sqlite_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/richardmarbach/rona"
+	"github.com/richardmarbach/rona/sqlite"
+)
+
+func TestMigrations(t *testing.T) {
+	migrations, err := sqlite.Migrations()
+	assertNoError(t, err)
+
+	for i, migration := range migrations {
+		if migration.Version != i {
+			t.Errorf("want migration version %d, got %d", i, migration.Version)
+		}
+		if migration.Checksum == "" || migration.UpSQL == "" || migration.DownSQL == "" {
+			t.Errorf("migration %d is incomplete", migration.Version)
+		}
+	}
+}
+
+func TestDB_Migrate(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("revert and reapply all migrations", func(t *testing.T) {
+		dsn := filepath.Join(t.TempDir(), "db")
+		db := MustOpenFileDB(t, dsn, false)
+
+		quicktest := MustCreateRegisteredQuickTest(adminContext(), t, sqlite.NewQuickTestService(db), "Tim")
+
+		migrations, err := sqlite.Migrations()
+		assertNoError(t, err)
+
+		// Reverting the migrations one by one keeps the data of the
+		// remaining tables.
+		for i := 0; i < len(migrations)-1; i++ {
+			_, err := db.MigrateDown(ctx, 1, false)
+			assertNoError(t, err)
+		}
+		if n := countRows(t, dsn, `SELECT COUNT(*) FROM quick_tests WHERE id = ?`, quicktest.ID); n != 1 {
+			t.Errorf("expected the quick test to survive, got %d rows", n)
+		}
+
+		_, err = db.MigrateTo(ctx, -1, false)
+		assertNoError(t, err)
+		if n := countRows(t, dsn, `SELECT COUNT(*) FROM sqlite_master WHERE name != 'migrations' AND name NOT LIKE 'sqlite_%'`); n != 0 {
+			t.Errorf("expected an empty schema, got %d objects", n)
+		}
+
+		steps, err := db.MigrateUp(ctx, false)
+		assertNoError(t, err)
+
+		if len(steps) != len(migrations) {
+			t.Errorf("want %d steps, got %d", len(migrations), len(steps))
+		}
+	})
+
+	t.Run("convert times between text and unix nanoseconds", func(t *testing.T) {
+		dsn := filepath.Join(t.TempDir(), "db")
+		db := MustOpenFileDB(t, dsn, false)
+		registeredAt := time.Date(2021, 3, 4, 5, 6, 7, 890, time.UTC)
+		db.Clock = rona.ClockFunc(func() time.Time { return registeredAt })
+
+		s := sqlite.NewQuickTestService(db)
+		quicktest := MustCreateRegisteredQuickTest(adminContext(), t, s, "Tim")
+		if found := MustFindQuickTest(adminContext(), t, s, quicktest.ID); !found.RegisteredAt.Equal(registeredAt) {
+			t.Errorf("want registered at %v, got %v", registeredAt, found.RegisteredAt)
+		}
+
+		_, err := db.MigrateTo(ctx, 6, false)
+		assertNoError(t, err)
+
+		var text string
+		if err := MustOpenRaw(t, dsn).QueryRow(`SELECT registered_at FROM quick_tests`).Scan(&text); err != nil {
+			t.Fatal(err)
+		} else if text != "2021-03-04T05:06:07Z" {
+			t.Errorf("want RFC3339 text, got %q", text)
+		}
+
+		_, err = db.MigrateUp(ctx, false)
+		assertNoError(t, err)
+
+		if found := MustFindQuickTest(adminContext(), t, s, quicktest.ID); !found.RegisteredAt.Equal(registeredAt.Truncate(time.Second)) {
+			t.Errorf("want registered at %v, got %v", registeredAt.Truncate(time.Second), found.RegisteredAt)
+		}
+	})
+
+	t.Run("convert quick test ids to blobs", func(t *testing.T) {
+		dsn := filepath.Join(t.TempDir(), "db")
+		db := MustOpenFileDB(t, dsn, false)
+		s := sqlite.NewQuickTestService(db)
+
+		registered := MustCreateRegisteredQuickTest(adminContext(), t, s, "Tim")
+		upper := MustCreateQuickTest(adminContext(), t, s)
+		if n := countRows(t, dsn, `SELECT COUNT(*) FROM quick_tests WHERE typeof(id) = 'blob' AND length(id) = 16`); n != 2 {
+			t.Errorf("want 2 binary ids, got %d", n)
+		}
+
+		_, err := db.MigrateTo(ctx, 7, false)
+		assertNoError(t, err)
+
+		raw := MustOpenRaw(t, dsn)
+		mustExec(t, raw, `UPDATE quick_tests SET id = upper(id) WHERE id = ?`, string(upper.ID))
+		mustExec(t, raw, `INSERT INTO quick_tests (id, code, created_at) VALUES ('legacy', 'LEGACY', 0)`)
+
+		_, err = db.MigrateUp(ctx, false)
+		assertNoError(t, err)
+
+		if found := MustFindQuickTest(adminContext(), t, s, registered.ID); found.Person != "Tim" {
+			t.Errorf("want person Tim, got %q", found.Person)
+		}
+		if found := MustFindQuickTest(adminContext(), t, s, upper.ID); found.ID != upper.ID {
+			t.Errorf("want id %v, got %v", upper.ID, found.ID)
+		}
+		if found := MustFindQuickTest(adminContext(), t, s, "legacy"); found.ID != "legacy" {
+			t.Errorf("expected ids that aren't UUIDs to stay text, got %v", found.ID)
+		}
+	})
+
+	t.Run("report the status", func(t *testing.T) {
+		db := MustOpenFileDB(t, filepath.Join(t.TempDir(), "db"), false)
+
+		_, err := db.MigrateDown(ctx, 2, false)
+		assertNoError(t, err)
+
+		statuses, err := db.MigrationStatus(ctx)
+		assertNoError(t, err)
+
+		for i, status := range statuses {
+			if applied := i < len(statuses)-2; status.Applied != applied {
+				t.Errorf("migration %d: want applied %v, got %v", status.Version, applied, status.Applied)
+			}
+			if status.Applied && status.AppliedAt.IsZero() {
+				t.Errorf("migration %d: expected an applied time", status.Version)
+			}
+		}
+	})
+
+	t.Run("dry run without changes", func(t *testing.T) {
+		dsn := filepath.Join(t.TempDir(), "db")
+		db := MustOpenFileDB(t, dsn, false)
+
+		steps, err := db.MigrateTo(ctx, -1, true)
+		assertNoError(t, err)
+
+		if len(steps) == 0 || !steps[0].Down {
+			t.Errorf("expected down steps, got %v", steps)
+		}
+		if n := countRows(t, dsn, `SELECT COUNT(*) FROM sqlite_master WHERE name = 'quick_tests'`); n != 1 {
+			t.Errorf("expected the schema to be unchanged")
+		}
+	})
+
+	t.Run("convert legacy migration tracking", func(t *testing.T) {
+		dsn := filepath.Join(t.TempDir(), "db")
+		migrations, err := sqlite.Migrations()
+		assertNoError(t, err)
+
+		raw := MustOpenRaw(t, dsn)
+		mustExec(t, raw, `CREATE TABLE migrations (name TEXT PRIMARY KEY)`)
+		for _, migration := range migrations[:4] {
+			mustExec(t, raw, migration.UpSQL)
+			mustExec(t, raw, `INSERT INTO migrations VALUES (?)`, fmt.Sprintf("migrations/%08d.sql", migration.Version))
+		}
+		raw.Close()
+
+		db := MustOpenFileDB(t, dsn, false)
+
+		statuses, err := db.MigrationStatus(ctx)
+		assertNoError(t, err)
+		for _, status := range statuses {
+			if !status.Applied || status.Modified || status.Unknown {
+				t.Errorf("unexpected status %+v", status)
+			}
+		}
+	})
+
+	t.Run("inspect without writing", func(t *testing.T) {
+		dsn := filepath.Join(t.TempDir(), "db")
+		migrations, err := sqlite.Migrations()
+		assertNoError(t, err)
+
+		raw := MustOpenRaw(t, dsn)
+		mustExec(t, raw, `CREATE TABLE migrations (name TEXT PRIMARY KEY)`)
+		mustExec(t, raw, migrations[0].UpSQL)
+		mustExec(t, raw, `INSERT INTO migrations VALUES ('migrations/00000000.sql')`)
+
+		db := MustOpenFileDB(t, dsn, true)
+
+		statuses, err := db.MigrationStatus(ctx)
+		assertNoError(t, err)
+		if !statuses[0].Applied || statuses[1].Applied {
+			t.Errorf("unexpected statuses %+v, %+v", statuses[0], statuses[1])
+		}
+
+		steps, err := db.MigrateUp(ctx, true)
+		assertNoError(t, err)
+		if len(steps) != len(migrations)-1 {
+			t.Errorf("want %d steps, got %d", len(migrations)-1, len(steps))
+		}
+
+		// The legacy table is only converted by a migration that is
+		// applied.
+		if n := countRows(t, dsn, `SELECT COUNT(*) FROM pragma_table_info('migrations') WHERE name = 'name' AND pk = 1`); n != 1 {
+			t.Errorf("expected the legacy migrations table to be kept")
+		}
+		if n := countRows(t, dsn, `SELECT COUNT(*) FROM sqlite_master WHERE name IN ('legacy_migrations', 'users')`); n != 0 {
+			t.Errorf("expected the schema to be unchanged, got %d new tables", n)
+		}
+	})
+
+	t.Run("refuse to open a schema ahead of the binary", func(t *testing.T) {
+		dsn := filepath.Join(t.TempDir(), "db")
+		db := MustOpenFileDB(t, dsn, false)
+		db.Close()
+
+		raw := MustOpenRaw(t, dsn)
+		mustExec(t, raw, `INSERT INTO migrations VALUES (9999, 'future', 'x', '2021-01-01T00:00:00Z')`)
+		raw.Close()
+
+		err := sqlite.NewDB(dsn).Open()
+		if err == nil || !strings.Contains(err.Error(), "ahead") {
+			t.Errorf("expected the schema to be ahead, got %v", err)
+		}
+
+		db = MustOpenFileDB(t, dsn, true)
+		statuses, err := db.MigrationStatus(ctx)
+		assertNoError(t, err)
+		if last := statuses[len(statuses)-1]; !last.Unknown || last.Version != 9999 {
+			t.Errorf("expected an unknown migration, got %+v", last)
+		}
+	})
+
+	t.Run("refuse modified migrations", func(t *testing.T) {
+		dsn := filepath.Join(t.TempDir(), "db")
+		db := MustOpenFileDB(t, dsn, false)
+		db.Close()
+
+		raw := MustOpenRaw(t, dsn)
+		mustExec(t, raw, `UPDATE migrations SET checksum = 'edited' WHERE version = 1`)
+		raw.Close()
+
+		err := sqlite.NewDB(dsn).Open()
+		if err == nil || !strings.Contains(err.Error(), "modified") {
+			t.Errorf("expected a modified migration, got %v", err)
+		}
+	})
+}
+
+// MustOpenFileDB opens the database file at dsn.
+func MustOpenFileDB(tb testing.TB, dsn string, skipMigrations bool) *sqlite.DB {
+	tb.Helper()
+
+	db := sqlite.NewDB(dsn)
+	db.Keyring = MustNewKeyring(tb, "test")
+	db.SkipMigrations = skipMigrations
+	if err := db.Open(); err != nil {
+		tb.Fatalf("failed to open db: %v", err)
+	}
+	tb.Cleanup(func() { db.Close() })
+	return db
+}
+
+// MustOpenRaw opens a plain connection to the database file at dsn.
+func MustOpenRaw(tb testing.TB, dsn string) *sql.DB {
+	tb.Helper()
+
+	raw, err := sql.Open(sqlite.DriverName, dsn)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { raw.Close() })
+	return raw
+}
+
+func mustExec(tb testing.TB, db *sql.DB, query string, args ...interface{}) {
+	tb.Helper()
+	if _, err := db.Exec(query, args...); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+func countRows(tb testing.TB, dsn, query string, args ...interface{}) (n int) {
+	tb.Helper()
+	if err := MustOpenRaw(tb, dsn).QueryRow(query, args...).Scan(&n); err != nil {
+		tb.Fatal(err)
+	}
+	return n
+}
@@ -0,0 +1 @@
+DROP TABLE quick_tests;
@@ -0,0 +1,20 @@
+-- SQLite can't drop columns, so the table is rebuilt.
+CREATE TABLE quick_tests_down (
+  id BLOB PRIMARY KEY,
+  person TEXT,
+  expired INTEGER NOT NULL DEFAULT 0,
+  created_at TEXT NOT NULL,
+  registered_at TEXT
+);
+
+INSERT INTO quick_tests_down (id, person, expired, created_at, registered_at)
+SELECT id, person, expired, created_at, registered_at FROM quick_tests;
+
+DROP TABLE quick_tests;
+ALTER TABLE quick_tests_down RENAME TO quick_tests;
+
+CREATE INDEX po_quick_tests_expired ON quick_tests(expired, registered_at) 
+WHERE expired = 0 AND registered_at IS NOT NULL;
+
+CREATE INDEX po_quick_tests_free ON quick_tests(expired, registered_at)
+WHERE expired = 0 AND registered_at is NULL;
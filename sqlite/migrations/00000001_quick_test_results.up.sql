@@ -0,0 +1,2 @@
+ALTER TABLE quick_tests ADD COLUMN result TEXT NOT NULL DEFAULT 'pending';
+ALTER TABLE quick_tests ADD COLUMN resulted_at TEXT;
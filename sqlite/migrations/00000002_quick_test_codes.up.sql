@@ -0,0 +1,4 @@
+ALTER TABLE quick_tests ADD COLUMN code TEXT;
+
+-- Codes are backfilled for existing tests when the database is opened.
+CREATE UNIQUE INDEX idx_quick_tests_code ON quick_tests(code);
@@ -0,0 +1,5 @@
+-- Persons are encrypted with a per row data key, which is wrapped by the
+-- keyring key identified by person_key_id. Rows without a key ID still
+-- hold the plaintext until the keys are rotated.
+ALTER TABLE quick_tests ADD COLUMN person_key_id TEXT;
+ALTER TABLE quick_tests ADD COLUMN person_dek BLOB;
@@ -0,0 +1,4 @@
+DROP TRIGGER quick_test_counters_update;
+DROP TRIGGER quick_test_counters_delete;
+DROP TRIGGER quick_test_counters_insert;
+DROP TABLE quick_test_counters;
@@ -0,0 +1,40 @@
+-- Counters keep the number of quick tests per state up to date, so
+-- reading them doesn't scan quick_tests. The triggers adjust them in the
+-- same transaction as every change to a quick test.
+CREATE TABLE quick_test_counters (
+  name TEXT PRIMARY KEY,
+  value INTEGER NOT NULL DEFAULT 0
+);
+
+INSERT INTO quick_test_counters (name, value)
+SELECT 'total', COUNT(*) FROM quick_tests
+UNION ALL
+SELECT 'registered', COUNT(*) FROM quick_tests WHERE expired = 0 AND registered_at IS NOT NULL
+UNION ALL
+SELECT 'available', COUNT(*) FROM quick_tests WHERE expired = 0 AND registered_at IS NULL;
+
+CREATE TRIGGER quick_test_counters_insert AFTER INSERT ON quick_tests
+BEGIN
+  UPDATE quick_test_counters SET value = value + 1
+  WHERE name = 'total'
+  OR name = CASE WHEN NEW.expired = 0 AND NEW.registered_at IS NULL THEN 'available'
+                 WHEN NEW.expired = 0 THEN 'registered' END;
+END;
+
+CREATE TRIGGER quick_test_counters_delete AFTER DELETE ON quick_tests
+BEGIN
+  UPDATE quick_test_counters SET value = value - 1
+  WHERE name = 'total'
+  OR name = CASE WHEN OLD.expired = 0 AND OLD.registered_at IS NULL THEN 'available'
+                 WHEN OLD.expired = 0 THEN 'registered' END;
+END;
+
+CREATE TRIGGER quick_test_counters_update AFTER UPDATE OF expired, registered_at ON quick_tests
+BEGIN
+  UPDATE quick_test_counters SET value = value - 1
+  WHERE name = CASE WHEN OLD.expired = 0 AND OLD.registered_at IS NULL THEN 'available'
+                    WHEN OLD.expired = 0 THEN 'registered' END;
+  UPDATE quick_test_counters SET value = value + 1
+  WHERE name = CASE WHEN NEW.expired = 0 AND NEW.registered_at IS NULL THEN 'available'
+                    WHEN NEW.expired = 0 THEN 'registered' END;
+END;
@@ -0,0 +1,134 @@
+-- Times are stored as integer Unix nanoseconds instead of RFC3339 text,
+-- so they keep sub-second precision and expiry can range scan the
+-- registered_at index. SQLite can't change column types, so the tables
+-- are rebuilt.
+CREATE TABLE quick_tests_new (
+  id BLOB PRIMARY KEY,
+  code TEXT,
+  person TEXT,
+  person_key_id TEXT,
+  person_dek BLOB,
+  expired INTEGER NOT NULL DEFAULT 0,
+  result TEXT NOT NULL DEFAULT 'pending',
+  created_at INTEGER NOT NULL,
+  registered_at INTEGER,
+  resulted_at INTEGER
+);
+
+INSERT INTO quick_tests_new (id, code, person, person_key_id, person_dek, expired, result, created_at, registered_at, resulted_at)
+SELECT
+  id,
+  code,
+  person,
+  person_key_id,
+  person_dek,
+  expired,
+  result,
+  CAST(strftime('%s', created_at) AS INTEGER) * 1000000000,
+  CAST(strftime('%s', registered_at) AS INTEGER) * 1000000000,
+  CAST(strftime('%s', resulted_at) AS INTEGER) * 1000000000
+FROM quick_tests;
+
+DROP TABLE quick_tests;
+ALTER TABLE quick_tests_new RENAME TO quick_tests;
+
+CREATE INDEX po_quick_tests_expired ON quick_tests(expired, registered_at)
+WHERE expired = 0 AND registered_at IS NOT NULL;
+
+CREATE INDEX po_quick_tests_free ON quick_tests(expired, registered_at)
+WHERE expired = 0 AND registered_at is NULL;
+
+CREATE UNIQUE INDEX idx_quick_tests_code ON quick_tests(code);
+
+-- Dropping quick_tests dropped its counter triggers.
+CREATE TRIGGER quick_test_counters_insert AFTER INSERT ON quick_tests
+BEGIN
+  UPDATE quick_test_counters SET value = value + 1
+  WHERE name = 'total'
+  OR name = CASE WHEN NEW.expired = 0 AND NEW.registered_at IS NULL THEN 'available'
+                 WHEN NEW.expired = 0 THEN 'registered' END;
+END;
+
+CREATE TRIGGER quick_test_counters_delete AFTER DELETE ON quick_tests
+BEGIN
+  UPDATE quick_test_counters SET value = value - 1
+  WHERE name = 'total'
+  OR name = CASE WHEN OLD.expired = 0 AND OLD.registered_at IS NULL THEN 'available'
+                 WHEN OLD.expired = 0 THEN 'registered' END;
+END;
+
+CREATE TRIGGER quick_test_counters_update AFTER UPDATE OF expired, registered_at ON quick_tests
+BEGIN
+  UPDATE quick_test_counters SET value = value - 1
+  WHERE name = CASE WHEN OLD.expired = 0 AND OLD.registered_at IS NULL THEN 'available'
+                    WHEN OLD.expired = 0 THEN 'registered' END;
+  UPDATE quick_test_counters SET value = value + 1
+  WHERE name = CASE WHEN NEW.expired = 0 AND NEW.registered_at IS NULL THEN 'available'
+                    WHEN NEW.expired = 0 THEN 'registered' END;
+END;
+
+CREATE TABLE users_new (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  name TEXT NOT NULL,
+  email TEXT NOT NULL UNIQUE,
+  role TEXT NOT NULL,
+  password_hash TEXT NOT NULL,
+  -- SHA-256 of the API key. The key itself is never stored.
+  api_key_hash TEXT UNIQUE,
+  created_at INTEGER NOT NULL,
+  updated_at INTEGER NOT NULL
+);
+
+INSERT INTO users_new (id, name, email, role, password_hash, api_key_hash, created_at, updated_at)
+SELECT
+  id,
+  name,
+  email,
+  role,
+  password_hash,
+  api_key_hash,
+  CAST(strftime('%s', created_at) AS INTEGER) * 1000000000,
+  CAST(strftime('%s', updated_at) AS INTEGER) * 1000000000
+FROM users;
+
+DROP TABLE users;
+ALTER TABLE users_new RENAME TO users;
+
+CREATE TABLE audit_events_new (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  action TEXT NOT NULL,
+  quick_test_id TEXT NOT NULL,
+  actor_id INTEGER,
+  actor_role TEXT,
+  request_id TEXT,
+  outcome TEXT NOT NULL,
+  created_at INTEGER NOT NULL
+);
+
+INSERT INTO audit_events_new (id, action, quick_test_id, actor_id, actor_role, request_id, outcome, created_at)
+SELECT
+  id,
+  action,
+  quick_test_id,
+  actor_id,
+  actor_role,
+  request_id,
+  outcome,
+  CAST(strftime('%s', created_at) AS INTEGER) * 1000000000
+FROM audit_events;
+
+DROP TABLE audit_events;
+ALTER TABLE audit_events_new RENAME TO audit_events;
+
+CREATE INDEX idx_audit_events_quick_test_id ON audit_events(quick_test_id, id);
+
+-- The audit log is append-only.
+CREATE TRIGGER audit_events_no_update BEFORE UPDATE ON audit_events
+BEGIN
+  SELECT RAISE(ABORT, 'audit events are append-only');
+END;
+
+CREATE TRIGGER audit_events_no_delete BEFORE DELETE ON audit_events
+BEGIN
+  SELECT RAISE(ABORT, 'audit events are append-only');
+END;
@@ -0,0 +1,9 @@
+UPDATE quick_tests
+SET id = lower(
+  substr(hex(id), 1, 8) || '-' ||
+  substr(hex(id), 9, 4) || '-' ||
+  substr(hex(id), 13, 4) || '-' ||
+  substr(hex(id), 17, 4) || '-' ||
+  substr(hex(id), 21)
+)
+WHERE typeof(id) = 'blob';
@@ -0,0 +1,4 @@
+-- Quick test IDs are stored as 16 byte blobs. The id column is already
+-- declared as a BLOB, the existing text IDs are converted by the data
+-- migration of this version.
+SELECT 1;
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/richardmarbach/rona"
+	"github.com/richardmarbach/rona/envelope"
 )
 
 var _ rona.QuickTestService = &QuickTestService{}
@@ -30,35 +31,82 @@ func (s *QuickTestService) FindQuickTestByID(ctx context.Context, id rona.QuickT
 	}
 	defer tx.Rollback()
 
-	row := tx.QueryRow(`
+	return findQuickTestByID(ctx, tx, id)
+}
+
+// FindQuickTestByCode retrieves a quicktest by its short code.
+func (s *QuickTestService) FindQuickTestByCode(ctx context.Context, code rona.QuickTestCode) (*rona.QuickTest, error) {
+	if err := code.Validate(); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	return findQuickTest(ctx, tx, "code", code)
+}
+
+// findQuickTestByID retrieves a quicktest by id within the given transaction.
+func findQuickTestByID(ctx context.Context, tx *Tx, id rona.QuickTestID) (*rona.QuickTest, error) {
+	return findQuickTest(ctx, tx, "id", UUID(id))
+}
+
+// findQuickTest retrieves the quicktest whose column matches value. column
+// must be a trusted column name.
+func findQuickTest(ctx context.Context, tx *Tx, column string, value interface{}) (*rona.QuickTest, error) {
+	row := tx.QueryRowContext(ctx, `
 		SELECT
 			id,
+			code,
 			person,
+			person_key_id,
+			person_dek,
 			expired,
+			result,
 			created_at,
-			registered_at
+			registered_at,
+			resulted_at
 		FROM quick_tests
-		WHERE id = ?
+		WHERE `+column+` = ?
 		LIMIT 1
-	`, id)
+	`, value)
 
 	var quicktest rona.QuickTest
+	var person envelope.Sealed
 	if err := row.Scan(
-		&quicktest.ID,
-		(*NullString)(&quicktest.Person),
+		(*UUID)(&quicktest.ID),
+		(*NullString)(&quicktest.Code),
+		&person.Ciphertext,
+		(*NullString)(&person.KeyID),
+		&person.DataKey,
 		&quicktest.Expired,
+		&quicktest.Result,
 		(*NullTime)(&quicktest.CreatedAt),
 		(*NullTime)(&quicktest.RegisteredAt),
+		(*NullTime)(&quicktest.ResultedAt),
 	); err != nil && err == sql.ErrNoRows {
-		return nil, rona.Errorf(rona.ENOTFOUND, "No quick test found for %v", id)
+		return nil, rona.Errorf(rona.ENOTFOUND, "No quick test found for %v", value)
 	} else if err != nil {
-		return nil, err
+		return nil, FormatError(err)
 	}
 
 	if row.Err() != nil {
 		return nil, row.Err()
 	}
 
+	if len(person.Ciphertext) > 0 {
+		keyring, err := tx.db.keyring()
+		if err != nil {
+			return nil, err
+		}
+		if quicktest.Person, err = keyring.Open(quicktest.ID, &person); err != nil {
+			return nil, err
+		}
+	}
+
 	return &quicktest, nil
 }
 
@@ -75,8 +123,21 @@ func (s *QuickTestService) CreateQuickTest(ctx context.Context, id rona.QuickTes
 	return quicktests[0], nil
 }
 
-// CreateManyQuickTests creates quick tests in batches.
+// CreateManyQuickTests creates quick tests in batches. Only manufacturers
+// can create quick tests.
 func (s *QuickTestService) CreateManyQuickTests(ctx context.Context, ids []rona.QuickTestID) ([]*rona.QuickTest, error) {
+	quicktests, err := s.createManyQuickTests(ctx, ids)
+	if err != nil {
+		s.recordFailure(ctx, rona.AuditActionCreate, ids, err)
+	}
+	return quicktests, err
+}
+
+func (s *QuickTestService) createManyQuickTests(ctx context.Context, ids []rona.QuickTestID) ([]*rona.QuickTest, error) {
+	if err := rona.RequireRole(ctx, rona.RoleManufacturer); err != nil {
+		return nil, err
+	}
+
 	for _, id := range ids {
 		if err := id.Validate(); err != nil {
 			return nil, err
@@ -94,79 +155,229 @@ func (s *QuickTestService) CreateManyQuickTests(ctx context.Context, ids []rona.
 	defer tx.Rollback()
 
 	quicktests := make([]*rona.QuickTest, 0, len(ids))
+	events := make([]*rona.AuditEvent, 0, len(ids))
 	for _, id := range ids {
 		quicktest := &rona.QuickTest{
-			ID:        id,
+			ID:        canonicalID(id),
+			Result:    rona.QuickTestResultPending,
 			CreatedAt: tx.Now,
 		}
 		quicktests = append(quicktests, quicktest)
+		events = append(events, rona.NewAuditEvent(ctx, rona.AuditActionCreate, quicktest.ID, nil))
 	}
 
+	for start := 0; start < len(quicktests); start += maxInsertRows {
+		end := start + maxInsertRows
+		if end > len(quicktests) {
+			end = len(quicktests)
+		}
+
+		// Short codes are random and may collide with existing codes. A
+		// failed statement doesn't abort the transaction, so retry the
+		// rows with fresh codes.
+		for attempt := 1; ; attempt++ {
+			err := insertQuickTests(ctx, tx, quicktests[start:end])
+			if err == nil {
+				break
+			} else if !isCodeConflict(err) || attempt == maxCodeAttempts {
+				return nil, FormatError(err)
+			}
+		}
+	}
+
+	if err := insertAuditEvents(ctx, tx, events...); err != nil {
+		return nil, err
+	}
+
+	return quicktests, tx.Commit()
+}
+
+// maxInsertRows limits the rows of multi-row inserts, so their bind
+// parameters stay below SQLITE_MAX_VARIABLE_NUMBER.
+const maxInsertRows = 1000
+
+// maxCodeAttempts limits how often new short codes are generated when
+// they collide with existing codes.
+const maxCodeAttempts = 5
+
+// insertQuickTests inserts the quick tests with newly generated codes.
+func insertQuickTests(ctx context.Context, tx *Tx, quicktests []*rona.QuickTest) error {
 	valueStrings := make([]string, 0, len(quicktests))
-	valueArgs := make([]interface{}, 0, len(quicktests)*2)
+	valueArgs := make([]interface{}, 0, len(quicktests)*3)
 	for _, quicktest := range quicktests {
-		valueStrings = append(valueStrings, "(?, ?)")
-		valueArgs = append(valueArgs, quicktest.ID)
+		quicktest.Code = rona.NewQuickTestCode()
+
+		valueStrings = append(valueStrings, "(?, ?, ?)")
+		valueArgs = append(valueArgs, UUID(quicktest.ID))
+		valueArgs = append(valueArgs, quicktest.Code)
 		valueArgs = append(valueArgs, (*NullTime)(&quicktest.CreatedAt))
 	}
 
-	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`
-		INSERT INTO quick_tests (id, created_at)
+	_, err := tx.ExecContext(ctx, fmt.Sprintf(`
+		INSERT INTO quick_tests (id, code, created_at)
 		VALUES %s;
 		`, strings.Join(valueStrings, ",")),
 		valueArgs...,
-	); err != nil {
-		return nil, FormatError(err)
-	}
+	)
+	return err
+}
 
-	return quicktests, tx.Commit()
+// isCodeConflict reports whether err is a unique violation of the short code.
+func isCodeConflict(err error) bool {
+	return isUniqueViolation(err) && strings.Contains(err.Error(), "quick_tests.code")
 }
 
 // RegisterQuickTest registers a new QuickTest
 func (s *QuickTestService) RegisterQuickTest(ctx context.Context, reg *rona.QuickTestRegister) (*rona.QuickTest, error) {
+	quicktest, err := s.registerQuickTest(ctx, reg)
+	if err != nil {
+		s.recordFailure(ctx, rona.AuditActionRegister, []rona.QuickTestID{reg.ID}, err)
+	}
+	return quicktest, err
+}
+
+func (s *QuickTestService) registerQuickTest(ctx context.Context, reg *rona.QuickTestRegister) (*rona.QuickTest, error) {
 	if err := reg.Validate(); err != nil {
 		return nil, err
 	}
 
+	keyring, err := s.db.keyring()
+	if err != nil {
+		return nil, err
+	}
+	person, err := keyring.Seal(canonicalID(reg.ID), reg.Person)
+	if err != nil {
+		return nil, err
+	}
+
 	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, err
 	}
 	defer tx.Rollback()
 
-	quicktest, err := s.FindQuickTestByID(ctx, reg.ID)
+	// Only register tests that are still available. Checking and setting
+	// in one statement keeps concurrent registrations from overwriting
+	// each other.
+	res, err := tx.ExecContext(ctx, `
+		UPDATE quick_tests
+		SET person = ?,
+			person_key_id = ?,
+			person_dek = ?,
+			registered_at = ?
+		WHERE
+			id = ? AND
+			registered_at IS NULL AND
+			expired = 0
+	`,
+		person.Ciphertext,
+		(*NullString)(&person.KeyID),
+		person.DataKey,
+		(*NullTime)(&tx.Now),
+		UUID(reg.ID),
+	)
+	if err != nil {
+		return nil, FormatError(err)
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return nil, FormatError(err)
+	}
+
+	quicktest, err := findQuickTestByID(ctx, tx, reg.ID)
 	if err != nil {
 		return nil, err
 	}
 
-	if quicktest.Registered() {
+	// Report why the update didn't match.
+	if rows != 1 && quicktest.Registered() {
 		return nil, rona.Errorf(rona.ECONFLICT, "test has already been registered")
-	} else if quicktest.Expired {
+	} else if rows != 1 {
+		return nil, rona.Errorf(rona.EEXPIRED, "test has already expired")
+	}
+
+	if err := insertAuditEvents(ctx, tx, rona.NewAuditEvent(ctx, rona.AuditActionRegister, quicktest.ID, nil)); err != nil {
+		return nil, err
+	}
+
+	return quicktest, tx.Commit()
+}
+
+// RecordQuickTestResult records the result of a registered QuickTest. Only
+// test center staff can record results.
+func (s *QuickTestService) RecordQuickTestResult(ctx context.Context, rec *rona.QuickTestRecordResult) (*rona.QuickTest, error) {
+	quicktest, err := s.recordQuickTestResult(ctx, rec)
+	if err != nil {
+		s.recordFailure(ctx, rona.AuditActionResult, []rona.QuickTestID{rec.ID}, err)
+	}
+	return quicktest, err
+}
+
+func (s *QuickTestService) recordQuickTestResult(ctx context.Context, rec *rona.QuickTestRecordResult) (*rona.QuickTest, error) {
+	if err := rona.RequireRole(ctx, rona.RoleStaff); err != nil {
+		return nil, err
+	} else if err := rec.Validate(); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	quicktest, err := findQuickTestByID(ctx, tx, rec.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if quicktest.Expired {
 		return nil, rona.Errorf(rona.EEXPIRED, "test has already expired")
+	} else if !quicktest.Registered() {
+		return nil, rona.Errorf(rona.EINVALID, "test has not been registered")
+	} else if quicktest.Resulted() {
+		return nil, rona.Errorf(rona.ECONFLICT, "test result has already been recorded")
 	}
 
-	quicktest.Person = reg.Person
-	quicktest.RegisteredAt = tx.Now
+	quicktest.Result = rec.Result
+	quicktest.ResultedAt = tx.Now
 
 	if _, err := tx.ExecContext(ctx, `
 		UPDATE quick_tests
-		SET person = ?,
-			registered_at = ?
+		SET result = ?,
+			resulted_at = ?
 		WHERE id = ?
 	`,
-		(*NullString)(&quicktest.Person),
-		(*NullTime)(&quicktest.RegisteredAt),
-		quicktest.ID,
+		quicktest.Result,
+		(*NullTime)(&quicktest.ResultedAt),
+		UUID(quicktest.ID),
 	); err != nil {
 		return nil, FormatError(err)
 	}
 
+	if err := insertAuditEvents(ctx, tx, rona.NewAuditEvent(ctx, rona.AuditActionResult, quicktest.ID, nil)); err != nil {
+		return nil, err
+	}
+
 	return quicktest, tx.Commit()
 }
 
-// ExpireQuickTest by ID. An expired quicktest removes PII.
+// ExpireQuickTest by ID. An expired quicktest removes PII, including its
+// data key and any former values left in the WAL. Only test center
+// staff can expire quick tests.
 func (s *QuickTestService) ExpireQuickTest(ctx context.Context, id rona.QuickTestID) error {
-	if err := id.Validate(); err != nil {
+	err := s.expireQuickTest(ctx, id)
+	if err != nil {
+		s.recordFailure(ctx, rona.AuditActionExpire, []rona.QuickTestID{id}, err)
+	}
+	return err
+}
+
+func (s *QuickTestService) expireQuickTest(ctx context.Context, id rona.QuickTestID) error {
+	if err := rona.RequireRole(ctx, rona.RoleStaff); err != nil {
+		return err
+	} else if err := id.Validate(); err != nil {
 		return err
 	}
 
@@ -179,12 +390,14 @@ func (s *QuickTestService) ExpireQuickTest(ctx context.Context, id rona.QuickTes
 	res, err := tx.ExecContext(ctx, `
 		UPDATE quick_tests
 		SET expired = ?,
-			person = ?
+			person = ?,
+			person_key_id = NULL,
+			person_dek = NULL
 		WHERE id = ?
 	`,
 		true,
 		"",
-		id,
+		UUID(id),
 	)
 	if err != nil {
 		return FormatError(err)
@@ -198,32 +411,98 @@ func (s *QuickTestService) ExpireQuickTest(ctx context.Context, id rona.QuickTes
 		return rona.Errorf(rona.ENOTFOUND, "quick test does not exist: %v", id)
 	}
 
-	return tx.Commit()
-}
+	if err := insertAuditEvents(ctx, tx, rona.NewAuditEvent(ctx, rona.AuditActionExpire, canonicalID(id), nil)); err != nil {
+		return err
+	}
 
-// ExpireOutdatedQuickTests expires all quick tests registered after the given duration.
-func (s *QuickTestService) ExpireOutdatedQuickTests(ctx context.Context, d time.Duration) error {
-	tx, err := s.db.BeginTx(ctx, nil)
-	if err != nil {
+	if err := tx.Commit(); err != nil {
 		return err
 	}
-	defer tx.Rollback()
+	s.db.scrub(ctx)
+	return nil
+}
 
-	if _, err := tx.ExecContext(ctx, `
+// quickTestIDText formats the binary id column of quick_tests as the
+// canonical UUID text, which is how the audit log refers to quick tests.
+// IDs that aren't UUIDs are stored as text already.
+const quickTestIDText = `CASE typeof(id) WHEN 'blob' THEN lower(
+	substr(hex(id), 1, 8) || '-' ||
+	substr(hex(id), 9, 4) || '-' ||
+	substr(hex(id), 13, 4) || '-' ||
+	substr(hex(id), 17, 4) || '-' ||
+	substr(hex(id), 21)
+) ELSE id END`
+
+// The expiry queries select the tests to expire with the same condition, so
+// both search po_quick_tests_expired instead of scanning quick_tests.
+const (
+	expireAuditQuery = `
+		INSERT INTO audit_events (action, quick_test_id, actor_id, actor_role, request_id, outcome, created_at)
+		SELECT ?, ` + quickTestIDText + `, ?, ?, ?, ?, ?
+		FROM quick_tests
+		WHERE
+			expired = 0 AND
+			registered_at IS NOT NULL AND
+			registered_at < ?
+	`
+
+	expireQuickTestsQuery = `
 		UPDATE quick_tests
 		SET expired = ?,
-			person = ?
-		WHERE 
+			person = ?,
+			person_key_id = NULL,
+			person_dek = NULL
+		WHERE
 			expired = 0 AND
 			registered_at IS NOT NULL AND
-			strftime('%s', registered_at) < strftime('%s', DATETIME('now', ?))
-	`,
+			registered_at < ?
+	`
+)
+
+// ExpireOutdatedQuickTests expires all quick tests registered after the given duration.
+func (s *QuickTestService) ExpireOutdatedQuickTests(ctx context.Context, d time.Duration) (int, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Take the cutoff from the transaction time, so expiry follows the
+	// same clock as registration.
+	cutoff := tx.Now.Add(-d)
+
+	event := rona.NewAuditEvent(ctx, rona.AuditActionExpire, "", nil)
+	if _, err := tx.ExecContext(ctx, expireAuditQuery,
+		event.Action,
+		(*NullInt)(&event.ActorID),
+		(*NullString)(&event.ActorRole),
+		(*NullString)(&event.RequestID),
+		event.Outcome,
+		(*NullTime)(&tx.Now),
+		(*NullTime)(&cutoff),
+	); err != nil {
+		return 0, FormatError(err)
+	}
+
+	res, err := tx.ExecContext(ctx, expireQuickTestsQuery,
 		true,
 		"",
-		fmt.Sprintf("-%d second", int64(d.Seconds())),
-	); err != nil {
-		return FormatError(err)
+		(*NullTime)(&cutoff),
+	)
+	if err != nil {
+		return 0, FormatError(err)
 	}
 
-	return tx.Commit()
+	rows, err := res.RowsAffected()
+	if err != nil {
+		return 0, FormatError(err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	if rows > 0 {
+		s.db.scrub(ctx)
+	}
+	return int(rows), nil
 }
@@ -2,315 +2,185 @@ package sqlite_test
 
 import (
 	"context"
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/richardmarbach/rona"
+	"github.com/richardmarbach/rona/ronatest"
 	"github.com/richardmarbach/rona/sqlite"
 )
 
-func TestQuickTestService_FindQuickTestByID(t *testing.T) {
-	t.Run("find record by id", func(t *testing.T) {
-		ctx, s := createService(t)
-
-		quicktest := MustCreateQuickTest(ctx, t, s)
-
-		found, err := s.FindQuickTestByID(ctx, quicktest.ID)
-		assertNoError(t, err)
-
-		if found.ID != quicktest.ID {
-			t.Errorf("want ID %v, got %v", quicktest.ID, found.ID)
-		}
-		if found.CreatedAt.IsZero() {
-			t.Errorf("expected CreatedAt to not be zero")
+func TestQuickTestService(t *testing.T) {
+	ronatest.TestQuickTestService(t, func(tb testing.TB, clock *ronatest.Clock) rona.QuickTestService {
+		return sqlite.NewQuickTestService(MustOpenDBWithClock(tb, clock))
+	})
+}
+
+func TestQuickTestService_ConcurrentRegistration(t *testing.T) {
+	// Shared cache in-memory databases lock whole tables instead of
+	// waiting for each other, so race on a file like production does.
+	db := MustOpenFileDB(t, filepath.Join(t.TempDir(), "db"), false)
+	s := sqlite.NewQuickTestService(db)
+
+	for round := 0; round < 5; round++ {
+		quicktest := MustCreateQuickTest(adminContext(), t, s)
+
+		const n = 20
+		start := make(chan struct{})
+		people := make(chan string, n)
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func(person string) {
+				defer wg.Done()
+				<-start
+				_, err := s.RegisterQuickTest(context.Background(), &rona.QuickTestRegister{ID: quicktest.ID, Person: person})
+				if err == nil {
+					people <- person
+				} else if rona.ErrorCode(err) != rona.ECONFLICT {
+					t.Errorf("expected ECONFLICT, got %v", err)
+				}
+			}(fmt.Sprintf("Person %d", i))
 		}
-	})
-
-	t.Run("no record found", func(t *testing.T) {
-		ctx, s := createService(t)
+		close(start)
+		wg.Wait()
+		close(people)
 
-		id := rona.NewQuickTestID()
-
-		_, err := s.FindQuickTestByID(ctx, id)
-		assertErrorCode(t, err, rona.ENOTFOUND)
-	})
-}
-
-func TestQuickTestService_CreateQuickTest(t *testing.T) {
-	t.Run("create quick test", func(t *testing.T) {
-		ctx, s := createService(t)
-
-		id := rona.NewQuickTestID()
-
-		quicktest, err := s.CreateQuickTest(ctx, id)
-		assertNoError(t, err)
-
-		if quicktest.ID != id {
-			t.Errorf("want id %v, got %v", id, quicktest.ID)
+		var registered []string
+		for person := range people {
+			registered = append(registered, person)
 		}
-		if quicktest.CreatedAt.IsZero() {
-			t.Errorf("expected CreatedAt to be set")
+		if len(registered) != 1 {
+			t.Fatalf("want exactly 1 registration, got %d", len(registered))
 		}
-	})
-
-	t.Run("inserting duplicate quicktest fails with ECONFLICT", func(t *testing.T) {
-		ctx, s := createService(t)
-		quicktest := MustCreateQuickTest(ctx, t, s)
 
-		_, err := s.CreateQuickTest(ctx, quicktest.ID)
-
-		assertErrorCode(t, err, rona.ECONFLICT)
-	})
-}
-
-func TestQuickTestService_CreateMany(t *testing.T) {
-	t.Run("create no quick tests", func(t *testing.T) {
-		ctx, s := createService(t)
-
-		ids := []rona.QuickTestID{}
-
-		quicktests, err := s.CreateManyQuickTests(ctx, ids)
-		assertNoError(t, err)
-		if len(quicktests) != 0 {
-			t.Errorf("expected 0 quicktest, got %d", len(quicktests))
-		}
-	})
-	t.Run("create one quick test", func(t *testing.T) {
-		ctx, s := createService(t)
-
-		ids := []rona.QuickTestID{
-			rona.NewQuickTestID(),
+		if found := MustFindQuickTest(adminContext(), t, s, quicktest.ID); found.Person != registered[0] {
+			t.Errorf("want person %q, got %q", registered[0], found.Person)
 		}
-
-		quicktests, err := s.CreateManyQuickTests(ctx, ids)
-		assertNoError(t, err)
-
-		if len(quicktests) != 1 {
-			t.Errorf("expected 1 quicktest, got %d", len(quicktests))
-		}
-	})
-
-	t.Run("create many quick tests", func(t *testing.T) {
-		ctx, s := createService(t)
-
-		ids := []rona.QuickTestID{
-			rona.NewQuickTestID(),
-			rona.NewQuickTestID(),
-			rona.NewQuickTestID(),
-			rona.NewQuickTestID(),
-		}
-
-		quicktests, err := s.CreateManyQuickTests(ctx, ids)
-		assertNoError(t, err)
-
-		for i, quicktest := range quicktests {
-			if quicktest.ID != ids[i] {
-				t.Errorf("[%d] wanted id %v, got %v", i, ids[i], quicktest.ID)
-			}
-
-			if quicktest.CreatedAt.IsZero() {
-				t.Errorf("[%d] expected CreatedAt to not be empty", i)
-			}
-		}
-	})
-
-	t.Run("fails with ECONFLICT if the quicktest already exists", func(t *testing.T) {
-		ctx, s := createService(t)
-
-		exists := MustCreateQuickTest(ctx, t, s)
-
-		ids := []rona.QuickTestID{
-			rona.NewQuickTestID(),
-			exists.ID,
-		}
-
-		_, err := s.CreateManyQuickTests(ctx, ids)
-		assertErrorCode(t, err, rona.ECONFLICT)
-
-		_, err = s.FindQuickTestByID(ctx, ids[0])
-		assertErrorCode(t, err, rona.ENOTFOUND)
-	})
-
-	t.Run("fails if any of the IDs fail validation", func(t *testing.T) {
-		ctx, s := createService(t)
-
-		ids := []rona.QuickTestID{
-			rona.NewQuickTestID(),
-			"abc",
-		}
-
-		_, err := s.CreateManyQuickTests(ctx, ids)
-		assertErrorCode(t, err, rona.EINVALID)
-	})
+	}
 }
 
-func TestQuickTestService_Register(t *testing.T) {
-	t.Run("register a quick test", func(t *testing.T) {
-		ctx, s := createService(t)
-		quicktest := MustCreateQuickTest(ctx, t, s)
-
-		registered, err := s.RegisterQuickTest(ctx, &rona.QuickTestRegister{
-			ID:     quicktest.ID,
-			Person: "Jimmy Hendricks",
-		})
-		assertNoError(t, err)
-
-		if registered == nil {
-			t.Fatal("expected a new QuickTest but didn't get one")
-		}
-		if registered.ID != quicktest.ID {
-			t.Errorf("want %s, got %s", quicktest.ID, registered.ID)
-		}
-		if registered.Person != "Jimmy Hendricks" {
-			t.Errorf("want %s, got %s", quicktest.Person, registered.Person)
-		}
-		if registered.RegisteredAt.IsZero() {
-			t.Errorf("expected RegisteredAt to be set")
-		}
-	})
-
-	t.Run("return ENOTFOUND when there is no such test", func(t *testing.T) {
-		ctx, s := createService(t)
+func TestQuickTestService_ExpiryQueryPlan(t *testing.T) {
+	dsn := filepath.Join(t.TempDir(), "db")
+	MustOpenFileDB(t, dsn, false)
+	seedRegisteredQuickTests(t, dsn, 1000, time.Now())
 
-		_, err := s.RegisterQuickTest(ctx, &rona.QuickTestRegister{
-			ID:     rona.NewQuickTestID(),
-			Person: "Jimmy Hendricks",
-		})
-
-		assertErrorCode(t, err, rona.ENOTFOUND)
-	})
-
-	t.Run("return ECONFLICT when the test is already registered", func(t *testing.T) {
-		ctx, s := createService(t)
-		quicktest := MustCreateRegisteredQuickTest(ctx, t, s, "Jimmy Hendricks")
-
-		_, err := s.RegisterQuickTest(ctx, &rona.QuickTestRegister{
-			ID:     quicktest.ID,
-			Person: "Jimmy Jones",
-		})
-
-		assertErrorCode(t, err, rona.ECONFLICT)
-	})
-
-	t.Run("return EEXPIRED when the test has already expired", func(t *testing.T) {
-		ctx, s := createService(t)
-		quicktest := MustCreateExpiredQuickTest(ctx, t, s)
-
-		_, err := s.RegisterQuickTest(ctx, &rona.QuickTestRegister{
-			ID:     quicktest.ID,
-			Person: "Jimmy Jones",
-		})
-
-		assertErrorCode(t, err, rona.EEXPIRED)
-	})
+	assertIndexRangeScan(t, MustOpenRaw(t, dsn), "po_quick_tests_expired")
 }
 
-func TestQuickTestService_Expire(t *testing.T) {
-	t.Run("expire a test", func(t *testing.T) {
-		ctx, s := createService(t)
-		quicktest := MustCreateQuickTest(ctx, t, s)
+// BenchmarkQuickTestService_ExpireOutdatedQuickTests expires a thousand
+// quick tests per iteration out of millions of registered ones.
+func BenchmarkQuickTestService_ExpireOutdatedQuickTests(b *testing.B) {
+	const n = 2000000
+	const validity = 24 * time.Hour
 
-		err := s.ExpireQuickTest(ctx, quicktest.ID)
-		assertNoError(t, err)
+	dsn := filepath.Join(b.TempDir(), "db")
+	db := MustOpenFileDB(b, dsn, false)
+	s := sqlite.NewQuickTestService(db)
 
-		quicktest = MustFindQuickTest(ctx, t, s, quicktest.ID)
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	seedRegisteredQuickTests(b, dsn, n, start)
+	assertIndexRangeScan(b, MustOpenRaw(b, dsn), "po_quick_tests_expired")
 
-		AssertScrubbed(t, quicktest)
-	})
+	clock := ronatest.NewClock()
+	clock.Set(start.Add(validity))
+	db.Clock = clock
 
-	t.Run("return ENOTFOUND when quicktest doesn't exist", func(t *testing.T) {
-		ctx, s := createService(t)
-		err := s.ExpireQuickTest(ctx, rona.NewQuickTestID())
-
-		assertErrorCode(t, err, rona.ENOTFOUND)
-	})
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		clock.Add(1000 * time.Second)
+		if _, err := s.ExpireOutdatedQuickTests(context.Background(), validity); err != nil {
+			b.Fatal(err)
+		}
+	}
 }
 
-func TestQuickTestService_ExpireOutdatedQuickTests(t *testing.T) {
-	t.Run("expires outdated quicktest", func(t *testing.T) {
-		ctx, s := createService(t)
-
-		quicktest := MustCreateRegisteredQuickTestAt(ctx, t, s, "Tim", -25*time.Hour)
-
-		err := s.ExpireOutdatedQuickTests(ctx, 24*time.Hour)
-		assertNoError(t, err)
-
-		quicktest = MustFindQuickTest(ctx, t, s, quicktest.ID)
-
-		AssertScrubbed(t, quicktest)
-	})
-
-	t.Run("only expire quicktest older than the given duration", func(t *testing.T) {
-		ctx, s := createService(t)
-
-		outdatedTest := MustCreateRegisteredQuickTestAt(ctx, t, s, "Tim", -25*time.Hour)
-		validTest := MustCreateRegisteredQuickTestAt(ctx, t, s, "Jim", -23*time.Hour)
-
-		err := s.ExpireOutdatedQuickTests(ctx, 24*time.Hour)
-		assertNoError(t, err)
-
-		outdatedTest = MustFindQuickTest(ctx, t, s, outdatedTest.ID)
-		validTest = MustFindQuickTest(ctx, t, s, validTest.ID)
+// seedRegisteredQuickTests inserts n quick tests registered a second apart
+// from start.
+func seedRegisteredQuickTests(tb testing.TB, dsn string, n int, start time.Time) {
+	tb.Helper()
 
-		AssertScrubbed(t, outdatedTest)
-		AssertNotScrubbed(t, validTest)
-	})
+	mustExec(tb, MustOpenRaw(tb, dsn), `
+		WITH RECURSIVE seq(i) AS (SELECT 0 UNION ALL SELECT i + 1 FROM seq WHERE i + 1 < ?)
+		INSERT INTO quick_tests (id, code, person, created_at, registered_at)
+		SELECT randomblob(16), printf('S%d', i), 'Jane Doe', ?, ? + i * 1000000000
+		FROM seq
+	`, n, start.UnixNano(), start.UnixNano())
 }
 
-func AssertNotScrubbed(tb testing.TB, quicktest *rona.QuickTest) {
+// assertIndexRangeScan checks that the expiry queries search the index
+// instead of scanning quick_tests.
+func assertIndexRangeScan(tb testing.TB, db *sql.DB, index string) {
 	tb.Helper()
 
-	if quicktest.Expired {
-		tb.Errorf("expected quicktest to not be expired: %v", quicktest)
+	cutoff := time.Now().UnixNano()
+	queries := []struct {
+		name  string
+		query string
+		args  []interface{}
+	}{
+		{"audit", sqlite.ExpireAuditQuery, []interface{}{"expire", nil, nil, nil, "success", cutoff, cutoff}},
+		{"update", sqlite.ExpireQuickTestsQuery, []interface{}{true, "", cutoff}},
 	}
 
-	if quicktest.Person == "" {
-		tb.Errorf("expected quicktest Person to be set: %v", quicktest.Person)
+	// IS NOT NULL becomes a lower bound, so the search has both bounds.
+	// The update only needs the rowids, so it may use the covering index.
+	search := " " + index + " (expired=? AND registered_at>? AND registered_at<?)"
+	want := "SEARCH quick_tests USING INDEX" + search
+	covering := "SEARCH quick_tests USING COVERING INDEX" + search
+	for _, q := range queries {
+		plan := explainQueryPlan(tb, db, q.query, q.args...)
+		if len(plan) != 1 || (plan[0] != want && plan[0] != covering) {
+			tb.Fatalf("%s: want plan %q, got %q", q.name, want, plan)
+		}
 	}
 }
 
-func AssertScrubbed(tb testing.TB, quicktest *rona.QuickTest) {
+// explainQueryPlan returns the details of the query plan of query.
+func explainQueryPlan(tb testing.TB, db *sql.DB, query string, args ...interface{}) []string {
 	tb.Helper()
 
-	if !quicktest.Expired {
-		tb.Errorf("expected quicktest to be expired: %v", quicktest)
+	rows, err := db.Query("EXPLAIN QUERY PLAN "+query, args...)
+	if err != nil {
+		tb.Fatal(err)
 	}
-
-	if quicktest.Person != "" {
-		tb.Errorf("expected quicktest Person to be unset: %v", quicktest.Person)
+	defer rows.Close()
+
+	var plan []string
+	for rows.Next() {
+		var id, parent, notused int
+		var detail string
+		if err := rows.Scan(&id, &parent, &notused, &detail); err != nil {
+			tb.Fatal(err)
+		}
+		plan = append(plan, detail)
 	}
+	if err := rows.Err(); err != nil {
+		tb.Fatal(err)
+	}
+	return plan
 }
 
-func MustCreateRegisteredQuickTestAt(
-	ctx context.Context,
-	tb testing.TB,
-	s *sqlite.QuickTestService,
-	person string,
-	d time.Duration,
-) *rona.QuickTest {
+// createService creates a QuickTestService and a context authenticated as
+// an admin.
+func createService(tb testing.TB) (context.Context, *sqlite.QuickTestService) {
 	tb.Helper()
-
-	resetTime := atTime(tb, time.Now().Add(d))
-	defer resetTime()
-	return MustCreateRegisteredQuickTest(ctx, tb, s, person)
+	s := sqlite.NewQuickTestService(MustOpenDB(tb))
+	return adminContext(), s
 }
 
-// atTime changes the time that transactions are run at.
-func atTime(tb testing.TB, currentTime time.Time) func() {
-	tb.Helper()
-
-	now := sqlite.Now
-	sqlite.Now = func() time.Time { return currentTime }
-
-	return func() {
-		sqlite.Now = now
-	}
+// adminContext returns a context authenticated as an admin.
+func adminContext() context.Context {
+	return rona.NewContextWithUser(context.Background(), &rona.User{ID: 1, Role: rona.RoleAdmin})
 }
 
-func createService(tb testing.TB) (context.Context, *sqlite.QuickTestService) {
-	tb.Helper()
-	s := sqlite.NewQuickTestService(MustOpenDB(tb))
-	return context.Background(), s
+// contextWithRole returns a context authenticated as a user with the role.
+func contextWithRole(role rona.Role) context.Context {
+	return rona.NewContextWithUser(context.Background(), &rona.User{ID: 2, Role: role})
 }
 
 func MustFindQuickTest(
@@ -358,19 +228,6 @@ func MustCreateRegisteredQuickTest(
 	return quicktest
 }
 
-func MustCreateExpiredQuickTest(
-	ctx context.Context,
-	tb testing.TB,
-	s *sqlite.QuickTestService,
-) *rona.QuickTest {
-	tb.Helper()
-
-	quicktest := MustCreateQuickTest(ctx, tb, s)
-	err := s.ExpireQuickTest(ctx, quicktest.ID)
-	assertNoError(tb, err)
-	return MustFindQuickTest(ctx, tb, s, quicktest.ID)
-}
-
 func assertNoError(tb testing.TB, err error) {
 	tb.Helper()
 	if err != nil {
@@ -4,21 +4,32 @@ import (
 	"context"
 	"database/sql"
 	"database/sql/driver"
-	"embed"
+	"errors"
 	"fmt"
-	"io/fs"
 	"log"
 	"os"
 	"path/filepath"
-	"sort"
 	"strings"
+	"sync"
 	"time"
 
-	// Use sqlite driver
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/google/uuid"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/richardmarbach/rona"
+	"github.com/richardmarbach/rona/envelope"
+)
+
+// SQLite result codes, see https://sqlite.org/rescode.html. Extended
+// codes carry the primary code in their lowest byte.
+const (
+	codeBusy       = 5
+	codeLocked     = 6
+	codeInterrupt  = 9
+	codeConstraint = 19
+
+	codeConstraintPrimaryKey = 1555
+	codeConstraintUnique     = 2067
 )
 
 // Database metrics
@@ -39,26 +50,38 @@ var (
 	})
 )
 
-//go:embed migrations/*.sql
-var migrationsFS embed.FS
-
-// Now wraps time.Now. This let's us set the transaction
-// time in testing.
-var Now = time.Now
-
 // DB represents a database connection.
 type DB struct {
 	db     *sql.DB
 	ctx    context.Context
 	cancel func()
 
+	// monitorDone is closed when the monitor goroutine exits.
+	monitorDone chan struct{}
+
+	// scrubMu serializes scrubs and guards scrubPending, which is set
+	// while erased data may still linger in the WAL.
+	scrubMu      sync.Mutex
+	scrubPending bool
+
 	DSN string
+
+	// Clock tells the time of transactions. Defaults to the system clock.
+	Clock rona.Clock
+
+	// Keyring encrypts the personal data of quick tests.
+	Keyring *envelope.Keyring
+
+	// SkipMigrations opens the database without applying pending
+	// migrations, so they can be managed with the Migrate methods.
+	SkipMigrations bool
 }
 
 // NewDB creates a new database connection
 func NewDB(dsn string) *DB {
 	db := &DB{
-		DSN: dsn,
+		DSN:   dsn,
+		Clock: rona.SystemClock,
 	}
 	db.ctx, db.cancel = context.WithCancel(context.Background())
 	return db
@@ -76,7 +99,7 @@ func (db *DB) Open() (err error) {
 		}
 	}
 
-	if db.db, err = sql.Open("sqlite3", db.DSN); err != nil {
+	if db.db, err = sql.Open(DriverName, withConnectionParams(db.DSN)); err != nil {
 		return err
 	}
 
@@ -84,91 +107,219 @@ func (db *DB) Open() (err error) {
 		return err
 	}
 
-	if err := db.migrate(); err != nil {
-		return fmt.Errorf("migrate: %w", err)
+	if !db.SkipMigrations {
+		if err := db.migrate(); err != nil {
+			return fmt.Errorf("migrate: %w", err)
+		}
+
+		if err := db.backfillQuickTestCodes(); err != nil {
+			return fmt.Errorf("backfill codes: %w", err)
+		}
 	}
 
+	db.monitorDone = make(chan struct{})
 	go db.monitor()
 
 	return nil
 }
 
-// migrate sets up migration tracking and runs the migrations.
-func (db *DB) migrate() error {
-	if _, err := db.db.Exec(`CREATE TABLE IF NOT EXISTS migrations (name TEXT PRIMARY KEY);`); err != nil {
+// convertQuickTestIDs stores the IDs of quick tests created before IDs were
+// stored as blobs in their binary form. Persons are authenticated with the
+// text ID, so persons of IDs that weren't in canonical form are sealed
+// again for the ID they read back as, which requires the keyring.
+func convertQuickTestIDs(ctx context.Context, tx *sql.Tx, keyring *envelope.Keyring) error {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, person, person_key_id, person_dek
+		FROM quick_tests
+		WHERE typeof(id) = 'text'
+	`)
+	if err != nil {
 		return err
 	}
 
-	names, err := fs.Glob(migrationsFS, "migrations/*.sql")
-	if err != nil {
+	type row struct {
+		id     rona.QuickTestID
+		sealed envelope.Sealed
+	}
+	var converted []*row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.id, &r.sealed.Ciphertext, (*NullString)(&r.sealed.KeyID), &r.sealed.DataKey); err != nil {
+			rows.Close()
+			return err
+		}
+		converted = append(converted, &r)
+	}
+	if err := rows.Close(); err != nil {
 		return err
 	}
-	sort.Strings(names)
 
-	for _, name := range names {
-		if err := db.migrateFile(name); err != nil {
-			return fmt.Errorf("migration error: name=%q error=%v", name, err)
+	for _, r := range converted {
+		// IDs that aren't UUIDs stay text, which is how UUID binds them.
+		if r.id.Validate() != nil {
+			continue
+		}
+
+		id := canonicalID(r.id)
+		if id != r.id && r.sealed.KeyID != "" {
+			if keyring == nil {
+				return fmt.Errorf("quick test %q: a keyring is required to convert encrypted persons", r.id)
+			}
+			person, err := keyring.Open(r.id, &r.sealed)
+			if err != nil {
+				return err
+			}
+			sealed, err := keyring.Seal(id, person)
+			if err != nil {
+				return err
+			}
+
+			if _, err := tx.ExecContext(ctx, `
+				UPDATE quick_tests
+				SET person = ?,
+					person_key_id = ?,
+					person_dek = ?
+				WHERE id = ?
+			`, sealed.Ciphertext, (*NullString)(&sealed.KeyID), sealed.DataKey, string(r.id)); err != nil {
+				return err
+			}
 		}
-	}
 
+		if _, err := tx.ExecContext(ctx, `UPDATE quick_tests SET id = ? WHERE id = ?`, UUID(id), string(r.id)); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (db *DB) migrateFile(name string) error {
+// backfillQuickTestCodes assigns short codes to quick tests created before
+// short codes existed.
+func (db *DB) backfillQuickTestCodes() error {
 	tx, err := db.db.Begin()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	var n int
-	if err := tx.QueryRow(`SELECT COUNT(*) FROM migrations WHERE name = ?`, name).Scan(&n); err != nil {
+	rows, err := tx.Query(`SELECT id FROM quick_tests WHERE code IS NULL`)
+	if err != nil {
 		return err
-	} else if n != 0 {
-		// migration already run
-		return nil
 	}
 
-	// Run the migration
-	if buf, err := fs.ReadFile(migrationsFS, name); err != nil {
-		return err
-	} else if _, err := tx.Exec(string(buf)); err != nil {
+	var ids []rona.QuickTestID
+	for rows.Next() {
+		var id rona.QuickTestID
+		if err := rows.Scan((*UUID)(&id)); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Close(); err != nil {
 		return err
 	}
 
-	if _, err := tx.Exec(`INSERT INTO migrations VALUES (?)`, name); err != nil {
-		return err
+	for _, id := range ids {
+		for attempt := 1; ; attempt++ {
+			_, err := tx.Exec(`UPDATE quick_tests SET code = ? WHERE id = ?`, rona.NewQuickTestCode(), UUID(id))
+			if err == nil {
+				break
+			} else if !isCodeConflict(err) || attempt == maxCodeAttempts {
+				return err
+			}
+		}
 	}
 
 	return tx.Commit()
 }
 
-// Close the database connection
+// Close stops the monitor, checkpoints the WAL into the database file and
+// closes the database connection.
 func (db *DB) Close() error {
 	db.cancel()
 
-	if db.db != nil {
-		return db.db.Close()
+	if db.monitorDone != nil {
+		<-db.monitorDone
+	}
+
+	if db.db == nil {
+		return nil
+	}
+
+	if err := db.Checkpoint(context.Background()); err != nil {
+		db.db.Close()
+		return err
+	}
+	return db.db.Close()
+}
+
+// Ping checks that the database is reachable.
+func (db *DB) Ping(ctx context.Context) error {
+	return FormatError(db.db.PingContext(ctx))
+}
+
+// withParam adds the query parameter to the DSN.
+func withParam(dsn, param string) string {
+	if strings.Contains(dsn, "?") {
+		return dsn + "&" + param
+	}
+	return dsn + "?" + param
+}
+
+// Checkpoint copies the WAL into the database file and truncates it.
+func (db *DB) Checkpoint(ctx context.Context) error {
+	var busy, frames, checkpointed int
+	if err := db.db.QueryRowContext(ctx, `PRAGMA wal_checkpoint(TRUNCATE);`).Scan(&busy, &frames, &checkpointed); err != nil {
+		return fmt.Errorf("wal checkpoint: %w", err)
+	} else if busy != 0 {
+		return fmt.Errorf("wal checkpoint: blocked by active readers")
 	}
 	return nil
 }
 
+// scrub checkpoints the WAL after personal data was erased, so the former
+// values don't linger in old WAL frames. Failed scrubs are retried by the
+// monitor.
+func (db *DB) scrub(ctx context.Context) {
+	db.scrubMu.Lock()
+	defer db.scrubMu.Unlock()
+
+	err := db.Checkpoint(ctx)
+	if err != nil {
+		log.Printf("scrub error: %v", err)
+	}
+	db.scrubPending = err != nil
+}
+
+// retryScrub scrubs again when the last scrub failed.
+func (db *DB) retryScrub(ctx context.Context) {
+	db.scrubMu.Lock()
+	pending := db.scrubPending
+	db.scrubMu.Unlock()
+
+	if pending {
+		db.scrub(ctx)
+	}
+}
+
 // BeginTx starts a new transaction
 func (db *DB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*Tx, error) {
 	tx, err := db.db.BeginTx(ctx, opts)
 	if err != nil {
-		return nil, err
+		return nil, FormatError(err)
 	}
 
 	return &Tx{
 		Tx:  tx,
 		db:  db,
-		Now: Now(),
+		Now: db.Clock.Now(),
 	}, nil
 }
 
-// monitor gathers database metrics
+// monitor gathers database metrics and retries failed scrubs.
 func (db *DB) monitor() {
+	defer close(db.monitorDone)
+
 	ticker := time.NewTicker(10 * time.Second)
 	defer ticker.Stop()
 
@@ -182,10 +333,11 @@ func (db *DB) monitor() {
 		if err := db.updateStats(db.ctx); err != nil {
 			log.Printf("stats error: %v", err)
 		}
+		db.retryScrub(db.ctx)
 	}
 }
 
-// updateStats updates the database metrics
+// updateStats updates the database metrics from the quick test counters.
 func (db *DB) updateStats(ctx context.Context) error {
 	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
@@ -193,21 +345,13 @@ func (db *DB) updateStats(ctx context.Context) error {
 	}
 	defer tx.Rollback()
 
-	var n int
-	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM quick_tests;`).Scan(&n); err != nil {
-		return err
-	}
-	testCountGauge.Set(float64(n))
-
-	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM quick_tests WHERE expired = 0 AND registered_at IS NOT NULL;`).Scan(&n); err != nil {
-		return err
-	}
-	registeredCountGauge.Set(float64(n))
-
-	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM quick_tests WHERE expired = 0 AND registered_at IS NULL;`).Scan(&n); err != nil {
+	counters, err := findCounters(ctx, tx)
+	if err != nil {
 		return err
 	}
-	availableCountGauge.Set(float64(n))
+	testCountGauge.Set(float64(counters["total"]))
+	registeredCountGauge.Set(float64(counters["registered"]))
+	availableCountGauge.Set(float64(counters["available"]))
 
 	return nil
 }
@@ -219,16 +363,43 @@ type Tx struct {
 	Now time.Time
 }
 
-// FormatError wraps the sqlite error as an application error when possible.
+// FormatError translates the sqlite error into an application error.
+// Unique violations become ECONFLICT, other constraint violations EINVALID,
+// busy or locked databases the retryable EUNAVAILABLE and canceled
+// operations ECANCELED. Anything else is wrapped as EINTERNAL.
 func FormatError(err error) error {
+	var e *rona.Error
 	if err == nil {
 		return nil
+	} else if errors.As(err, &e) {
+		return err
+	} else if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return rona.WrapError(rona.ECANCELED, err, "operation canceled")
 	}
 
-	if strings.HasPrefix(err.Error(), "UNIQUE constraint failed") {
-		return rona.Errorf(rona.ECONFLICT, "duplicate record")
+	code, ok := errorCode(err)
+	if !ok {
+		return rona.WrapError(rona.EINTERNAL, err, "database error")
 	}
-	return err
+
+	switch {
+	case code == codeConstraintUnique || code == codeConstraintPrimaryKey:
+		return rona.WrapError(rona.ECONFLICT, err, "duplicate record")
+	case code&0xff == codeConstraint:
+		return rona.WrapError(rona.EINVALID, err, "invalid record")
+	case code&0xff == codeBusy || code&0xff == codeLocked:
+		return rona.WrapError(rona.EUNAVAILABLE, err, "database is busy, try again")
+	case code&0xff == codeInterrupt:
+		return rona.WrapError(rona.ECANCELED, err, "operation canceled")
+	}
+	return rona.WrapError(rona.EINTERNAL, err, "database error")
+}
+
+// isUniqueViolation reports whether err is a unique or primary key
+// constraint violation.
+func isUniqueViolation(err error) bool {
+	code, ok := errorCode(err)
+	return ok && (code == codeConstraintUnique || code == codeConstraintPrimaryKey)
 }
 
 // NullString maps empty string to nil
@@ -255,26 +426,94 @@ func (s *NullString) Value() (driver.Value, error) {
 	return string(*s), nil
 }
 
-// NullTime encodes time as an RFC3339 encoded string.
+// UUID stores a quick test ID as a 16 byte blob instead of its 36 character
+// text form.
+type UUID rona.QuickTestID
+
+// Scan reads a quick test ID from the database blob. IDs that weren't
+// converted to blobs yet are read as they are.
+func (id *UUID) Scan(value interface{}) error {
+	switch value := value.(type) {
+	case []byte:
+		if u, err := uuid.FromBytes(value); err == nil {
+			*(*rona.QuickTestID)(id) = rona.QuickTestID(u.String())
+		} else {
+			*(*rona.QuickTestID)(id) = rona.QuickTestID(value)
+		}
+		return nil
+	case string:
+		*(*rona.QuickTestID)(id) = rona.QuickTestID(value)
+		return nil
+	}
+
+	return fmt.Errorf("UUID: cannot scan quick test id: %T", value)
+}
+
+// Value encodes the quick test ID as a database blob. IDs that aren't
+// UUIDs can't match a stored quick test, so they are kept as text and
+// lookups by them find nothing.
+func (id UUID) Value() (driver.Value, error) {
+	u, err := uuid.Parse(string(id))
+	if err != nil {
+		return string(id), nil
+	}
+	return u[:], nil
+}
+
+// canonicalID returns the quick test ID in the form it reads back from the
+// database.
+func canonicalID(id rona.QuickTestID) rona.QuickTestID {
+	if u, err := uuid.Parse(string(id)); err == nil {
+		return rona.QuickTestID(u.String())
+	}
+	return id
+}
+
+// NullInt maps zero to nil
+type NullInt int
+
+// Scan reads an integer value from the database
+func (n *NullInt) Scan(value interface{}) error {
+	if value == nil {
+		*(*int)(n) = 0
+		return nil
+	} else if value, ok := value.(int64); ok {
+		*(*int)(n) = int(value)
+		return nil
+	}
+
+	return fmt.Errorf("NullInt: cannot scan int: %v", value)
+}
+
+// Value formats the integer for the database.
+func (n *NullInt) Value() (driver.Value, error) {
+	if int(*n) == 0 {
+		return nil, nil
+	}
+	return int64(*n), nil
+}
+
+// NullTime encodes time as integer Unix nanoseconds, so times keep their
+// precision and compare as plain integers in indexes.
 type NullTime time.Time
 
-// Scan reads a time.Time from the database string
-func (n *NullTime) Scan(value interface{}) (err error) {
+// Scan reads a time.Time from the database integer
+func (n *NullTime) Scan(value interface{}) error {
 	if value == nil {
 		*(*time.Time)(n) = time.Time{}
 		return nil
-	} else if value, ok := value.(string); ok {
-		*(*time.Time)(n), err = time.Parse(time.RFC3339, value)
-		return err
+	} else if value, ok := value.(int64); ok {
+		*(*time.Time)(n) = time.Unix(0, value).UTC()
+		return nil
 	}
 
 	return fmt.Errorf("NullTime: cannot scan time.Time: %T", value)
 }
 
-// Value encodes a time.Time as a database string
+// Value encodes a time.Time as a database integer
 func (n *NullTime) Value() (driver.Value, error) {
 	if n == nil || (*time.Time)(n).IsZero() {
 		return nil, nil
 	}
-	return (*time.Time)(n).UTC().Format(time.RFC3339), nil
+	return (*time.Time)(n).UnixNano(), nil
 }
@@ -1,12 +1,20 @@
 package sqlite_test
 
 import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/richardmarbach/rona"
+	"github.com/richardmarbach/rona/ronatest"
 	"github.com/richardmarbach/rona/sqlite"
 )
 
@@ -18,6 +26,12 @@ func TestDB(t *testing.T) {
 
 func MustOpenDB(tb testing.TB) *sqlite.DB {
 	tb.Helper()
+	return MustOpenDBWithClock(tb, rona.SystemClock)
+}
+
+// MustOpenDBWithClock opens a database that takes the time from clock.
+func MustOpenDBWithClock(tb testing.TB, clock rona.Clock) *sqlite.DB {
+	tb.Helper()
 
 	dsn := "file::memory:?cache=shared"
 
@@ -31,6 +45,8 @@ func MustOpenDB(tb testing.TB) *sqlite.DB {
 	}
 
 	db := sqlite.NewDB(dsn)
+	db.Clock = clock
+	db.Keyring = MustNewKeyring(tb, "test")
 	if err := db.Open(); err != nil {
 		tb.Fatalf("failed to open db: %v", err)
 	}
@@ -43,3 +59,268 @@ func MustOpenDB(tb testing.TB) *sqlite.DB {
 
 	return db
 }
+
+// MustOpenRawDB opens a plain connection to the test database opened by
+// MustOpenDB, bypassing the application layer.
+func MustOpenRawDB(tb testing.TB) *sql.DB {
+	tb.Helper()
+
+	raw, err := sql.Open(sqlite.DriverName, "file::memory:?cache=shared")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { raw.Close() })
+	return raw
+}
+
+func TestDB_Close(t *testing.T) {
+	t.Run("checkpoint the WAL on close", func(t *testing.T) {
+		dsn := filepath.Join(t.TempDir(), "db")
+
+		db := sqlite.NewDB(dsn)
+		if err := db.Open(); err != nil {
+			t.Fatalf("failed to open db: %v", err)
+		}
+
+		s := sqlite.NewQuickTestService(db)
+		if _, err := s.CreateQuickTest(adminContext(), rona.NewQuickTestID()); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := db.Close(); err != nil {
+			t.Fatalf("failed to close db: %v", err)
+		}
+
+		if fi, err := os.Stat(dsn + "-wal"); err == nil && fi.Size() != 0 {
+			t.Errorf("expected WAL to be empty, got %d bytes", fi.Size())
+		}
+	})
+
+	t.Run("close an unopened db", func(t *testing.T) {
+		if err := sqlite.NewDB("db").Close(); err != nil {
+			t.Errorf("expected no error, got %v", err)
+		}
+	})
+}
+
+func TestDB_Open(t *testing.T) {
+	t.Run("backfill missing short codes", func(t *testing.T) {
+		ctx := adminContext()
+		dsn := filepath.Join(t.TempDir(), "db")
+
+		db := sqlite.NewDB(dsn)
+		if err := db.Open(); err != nil {
+			t.Fatalf("failed to open db: %v", err)
+		}
+		quicktest, err := sqlite.NewQuickTestService(db).CreateQuickTest(ctx, rona.NewQuickTestID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		raw, err := sql.Open(sqlite.DriverName, dsn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := raw.Exec(`UPDATE quick_tests SET code = NULL`); err != nil {
+			t.Fatal(err)
+		}
+		if err := raw.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		db = sqlite.NewDB(dsn)
+		if err := db.Open(); err != nil {
+			t.Fatalf("failed to reopen db: %v", err)
+		}
+		defer db.Close()
+
+		found, err := sqlite.NewQuickTestService(db).FindQuickTestByID(ctx, quicktest.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := found.Code.Validate(); err != nil {
+			t.Errorf("expected a backfilled code: %v", err)
+		}
+	})
+}
+
+func TestDB_SecureErasure(t *testing.T) {
+	expire := map[string]func(ctx context.Context, s *sqlite.QuickTestService, id rona.QuickTestID) error{
+		"expire a quick test": func(ctx context.Context, s *sqlite.QuickTestService, id rona.QuickTestID) error {
+			return s.ExpireQuickTest(ctx, id)
+		},
+		"expire outdated quick tests": func(ctx context.Context, s *sqlite.QuickTestService, id rona.QuickTestID) error {
+			_, err := s.ExpireOutdatedQuickTests(ctx, 24*time.Hour)
+			return err
+		},
+	}
+
+	for name, expire := range expire {
+		t.Run(name, func(t *testing.T) {
+			ctx := adminContext()
+			dsn := filepath.Join(t.TempDir(), "db")
+
+			clock := ronatest.NewClock()
+			db := sqlite.NewDB(dsn)
+			db.Clock = clock
+			db.Keyring = MustNewKeyring(t, "test")
+			if err := db.Open(); err != nil {
+				t.Fatalf("failed to open db: %v", err)
+			}
+			defer db.Close()
+			s := sqlite.NewQuickTestService(db)
+
+			clock.Add(-25 * time.Hour)
+			encrypted := MustCreateRegisteredQuickTest(ctx, t, s, "Tim Encrypted")
+			legacy := MustCreateRegisteredQuickTest(ctx, t, s, "Kim Plaintext")
+			clock.Add(25 * time.Hour)
+
+			raw, err := sql.Open(sqlite.DriverName, dsn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := raw.Exec(`
+				UPDATE quick_tests
+				SET person = 'Kim Plaintext', person_key_id = NULL, person_dek = NULL
+				WHERE id = ?
+			`, sqlite.UUID(legacy.ID)); err != nil {
+				t.Fatal(err)
+			}
+
+			var ciphertext, dataKey []byte
+			if err := raw.QueryRow(`SELECT person, person_dek FROM quick_tests WHERE id = ?`, sqlite.UUID(encrypted.ID)).Scan(&ciphertext, &dataKey); err != nil {
+				t.Fatal(err)
+			}
+			if err := raw.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			secrets := [][]byte{[]byte("Kim Plaintext"), ciphertext, dataKey}
+			if !bytes.Contains(readDBFiles(t, dsn), secrets[0]) {
+				t.Fatalf("expected the plaintext to be stored before expiry")
+			}
+
+			for _, id := range []rona.QuickTestID{encrypted.ID, legacy.ID} {
+				if err := expire(ctx, s, id); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			files := readDBFiles(t, dsn)
+			for _, secret := range secrets {
+				if bytes.Contains(files, secret) {
+					t.Errorf("expected %q to be erased from the database files", secret)
+				}
+			}
+		})
+	}
+}
+
+func TestFormatError(t *testing.T) {
+	MustOpenDB(t)
+	raw := MustOpenRawDB(t)
+
+	insertUser := `INSERT INTO users (name, email, role, password_hash, created_at, updated_at) VALUES ('Jane', 'jane@example.com', 'admin', '', '', '')`
+	insertQuickTest := `INSERT INTO quick_tests (id, created_at) VALUES ('test', '')`
+	for _, query := range []string{insertUser, insertQuickTest} {
+		if _, err := raw.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	cases := []struct {
+		message string
+		query   func() error
+		code    string
+	}{
+		{"unique violation", func() error {
+			_, err := raw.Exec(insertUser)
+			return err
+		}, rona.ECONFLICT},
+		{"primary key violation", func() error {
+			_, err := raw.Exec(insertQuickTest)
+			return err
+		}, rona.ECONFLICT},
+		{"not null violation", func() error {
+			_, err := raw.Exec(`INSERT INTO quick_tests (id) VALUES ('other')`)
+			return err
+		}, rona.EINVALID},
+		{"canceled context", func() error {
+			_, err := raw.ExecContext(canceled, `SELECT 1`)
+			return err
+		}, rona.ECANCELED},
+		{"unknown table", func() error {
+			_, err := raw.Exec(`SELECT * FROM missing`)
+			return err
+		}, rona.EINTERNAL},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.message, func(t *testing.T) {
+			err := tc.query()
+			if err == nil {
+				t.Fatal("expected the query to fail")
+			}
+
+			got := sqlite.FormatError(err)
+			assertErrorCode(t, got, tc.code)
+			if !errors.Is(got, err) {
+				t.Errorf("expected %v to wrap %v", got, err)
+			}
+		})
+	}
+
+	t.Run("busy database", func(t *testing.T) {
+		dsn := filepath.Join(t.TempDir(), "db")
+		writer, reader := MustOpenRaw(t, dsn), MustOpenRaw(t, dsn)
+		reader.SetMaxOpenConns(1)
+		mustExec(t, reader, `PRAGMA busy_timeout = 0`)
+		mustExec(t, writer, `CREATE TABLE t (x)`)
+
+		tx, err := writer.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tx.Rollback()
+		if _, err := tx.Exec(`INSERT INTO t VALUES (1)`); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = reader.Exec(`INSERT INTO t VALUES (2)`)
+		assertErrorCode(t, sqlite.FormatError(err), rona.EUNAVAILABLE)
+	})
+
+	t.Run("keep application errors", func(t *testing.T) {
+		err := rona.Errorf(rona.ENOTFOUND, "not found")
+		if got := sqlite.FormatError(err); got != err {
+			t.Errorf("want %v, got %v", err, got)
+		}
+	})
+
+	t.Run("keep nil", func(t *testing.T) {
+		if err := sqlite.FormatError(nil); err != nil {
+			t.Errorf("expected no error, got %v", err)
+		}
+	})
+}
+
+// readDBFiles returns the content of the database file and its WAL.
+func readDBFiles(tb testing.TB, dsn string) []byte {
+	tb.Helper()
+
+	var content []byte
+	for _, path := range []string{dsn, dsn + "-wal"} {
+		buf, err := ioutil.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			tb.Fatal(err)
+		}
+		content = append(content, buf...)
+	}
+	return content
+}
@@ -0,0 +1,15 @@
+package sqlite_test
+
+import (
+	"testing"
+
+	"github.com/richardmarbach/rona"
+	"github.com/richardmarbach/rona/ronatest"
+	"github.com/richardmarbach/rona/sqlite"
+)
+
+func TestUserService(t *testing.T) {
+	ronatest.TestUserService(t, func(tb testing.TB) rona.UserService {
+		return sqlite.NewUserService(MustOpenDB(tb))
+	})
+}
@@ -0,0 +1,132 @@
+package rona
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// User constants
+const (
+	UserMinPasswordLen = 8
+
+	// bcrypt ignores everything after 72 bytes.
+	UserMaxPasswordLen = 72
+)
+
+// Role determines which operations a user is allowed to perform.
+type Role string
+
+// Available roles.
+const (
+	// RoleManufacturer creates the tests that are shipped in kits.
+	RoleManufacturer Role = "manufacturer"
+
+	// RoleStaff works at a test center, records results and expires tests.
+	RoleStaff Role = "staff"
+
+	// RoleAdmin is allowed to perform every operation.
+	RoleAdmin Role = "admin"
+)
+
+// Validate the role.
+func (r Role) Validate() error {
+	switch r {
+	case RoleManufacturer, RoleStaff, RoleAdmin:
+		return nil
+	case "":
+		return Errorf(EINVALID, "role is required")
+	}
+	return Errorf(EINVALID, "invalid role: %q", string(r))
+}
+
+// User is a person or machine client acting on behalf of a manufacturer,
+// a test center or the operators of the service.
+type User struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	Role  Role   `json:"role"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// HasRole checks if the user is allowed to act in one of the roles.
+// Admins have every role.
+func (u *User) HasRole(roles ...Role) bool {
+	if u == nil {
+		return false
+	} else if u.Role == RoleAdmin {
+		return true
+	}
+
+	for _, role := range roles {
+		if u.Role == role {
+			return true
+		}
+	}
+	return false
+}
+
+// RequireRole returns EUNAUTHORIZED unless the user in the context is
+// allowed to act in one of the roles.
+func RequireRole(ctx context.Context, roles ...Role) error {
+	user := UserFromContext(ctx)
+	if user == nil {
+		return Errorf(EUNAUTHORIZED, "you must be signed in")
+	} else if !user.HasRole(roles...) {
+		return Errorf(EUNAUTHORIZED, "you are not allowed to perform this action")
+	}
+	return nil
+}
+
+// A UserService manages users and their credentials.
+type UserService interface {
+	// FindUserByID retrieves a user by ID.
+	// Returns ENOTFOUND if the user doesn't exist.
+	FindUserByID(ctx context.Context, id int) (*User, error)
+
+	// Authenticate a user by email and password.
+	// Returns EUNAUTHORIZED if the credentials don't match.
+	Authenticate(ctx context.Context, email, password string) (*User, error)
+
+	// AuthenticateAPIKey finds the user owning the API key.
+	// Returns EUNAUTHORIZED if the key doesn't belong to any user.
+	AuthenticateAPIKey(ctx context.Context, key string) (*User, error)
+
+	// CreateUser creates a new user. Only admins can create users.
+	// Returns EINVALID if the user fails validation.
+	// Returns ECONFLICT if the email is already in use.
+	CreateUser(ctx context.Context, create *UserCreate) (*User, error)
+
+	// GenerateAPIKey creates a new API key for the user, replacing any
+	// previous key. The key is only returned once. Users can generate
+	// their own keys, admins can generate keys for everyone.
+	// Returns ENOTFOUND if the user doesn't exist.
+	GenerateAPIKey(ctx context.Context, id int) (string, error)
+}
+
+// UserCreate is the set of fields that are needed to create a user.
+type UserCreate struct {
+	Name     string
+	Email    string
+	Role     Role
+	Password string
+}
+
+// Validate the fields required to create a user.
+func (c *UserCreate) Validate() error {
+	if c.Name == "" {
+		return Errorf(EINVALID, "name is required")
+	} else if c.Email == "" {
+		return Errorf(EINVALID, "email is required")
+	} else if !strings.Contains(c.Email, "@") {
+		return Errorf(EINVALID, "invalid email")
+	} else if len(c.Password) < UserMinPasswordLen {
+		return Errorf(EINVALID, "password must be at least %d characters long", UserMinPasswordLen)
+	} else if len(c.Password) > UserMaxPasswordLen {
+		return Errorf(EINVALID, "password must be at most %d bytes long", UserMaxPasswordLen)
+	}
+	return c.Role.Validate()
+}
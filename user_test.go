@@ -0,0 +1,84 @@
+package rona_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/richardmarbach/rona"
+)
+
+func TestUserCreate_Validate(t *testing.T) {
+	valid := func() *rona.UserCreate {
+		return &rona.UserCreate{Name: "Jane", Email: "jane@example.com", Role: rona.RoleStaff, Password: "correct horse"}
+	}
+
+	cases := []struct {
+		message string
+		modify  func(c *rona.UserCreate)
+		isValid bool
+	}{
+		{"valid", func(c *rona.UserCreate) {}, true},
+		{"missing name", func(c *rona.UserCreate) { c.Name = "" }, false},
+		{"missing email", func(c *rona.UserCreate) { c.Email = "" }, false},
+		{"invalid email", func(c *rona.UserCreate) { c.Email = "jane" }, false},
+		{"short password", func(c *rona.UserCreate) { c.Password = "short" }, false},
+		{"long password", func(c *rona.UserCreate) { c.Password = strings.Repeat("a", rona.UserMaxPasswordLen+1) }, false},
+		{"missing role", func(c *rona.UserCreate) { c.Role = "" }, false},
+		{"unknown role", func(c *rona.UserCreate) { c.Role = "janitor" }, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.message, func(t *testing.T) {
+			create := valid()
+			tc.modify(create)
+			err := create.Validate()
+
+			if tc.isValid {
+				if err != nil {
+					t.Errorf("create=%#v err=%v", create, err)
+				}
+			} else {
+				if err == nil {
+					t.Error("expected an error but didn't get one")
+				} else if rona.ErrorCode(err) != rona.EINVALID {
+					t.Errorf("expected EINVALID but got %v", rona.ErrorCode(err))
+				}
+			}
+		})
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	cases := []struct {
+		message   string
+		user      *rona.User
+		roles     []rona.Role
+		isAllowed bool
+	}{
+		{"anonymous", nil, []rona.Role{rona.RoleStaff}, false},
+		{"matching role", &rona.User{Role: rona.RoleStaff}, []rona.Role{rona.RoleStaff}, true},
+		{"one of many roles", &rona.User{Role: rona.RoleStaff}, []rona.Role{rona.RoleManufacturer, rona.RoleStaff}, true},
+		{"other role", &rona.User{Role: rona.RoleManufacturer}, []rona.Role{rona.RoleStaff}, false},
+		{"admin", &rona.User{Role: rona.RoleAdmin}, []rona.Role{rona.RoleStaff}, true},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.message, func(t *testing.T) {
+			ctx := context.Background()
+			if tc.user != nil {
+				ctx = rona.NewContextWithUser(ctx, tc.user)
+			}
+
+			err := rona.RequireRole(ctx, tc.roles...)
+
+			if tc.isAllowed {
+				if err != nil {
+					t.Errorf("expected no error, got %v", err)
+				}
+			} else if rona.ErrorCode(err) != rona.EUNAUTHORIZED {
+				t.Errorf("expected EUNAUTHORIZED but got %v", err)
+			}
+		})
+	}
+}